@@ -1,4 +1,4 @@
-import type { Schema, Instance, NewSchemaRequest, NewInstanceRequest, ApiError } from './types';
+import type { Schema, Instance, Summary, ResolvedVisualization, Page, ListOptions, NewSchemaRequest, NewInstanceRequest, ApiError } from './types';
 
 const API_BASE = 'http://localhost:5499';
 
@@ -10,10 +10,37 @@ async function handleResponse<T>(response: Response): Promise<T> {
   return response.json();
 }
 
+function listQuery(options: ListOptions): string {
+  const params = new URLSearchParams();
+  for (const [key, value] of Object.entries(options)) {
+    if (value !== undefined && value !== '') {
+      params.set(key, String(value));
+    }
+  }
+  const query = params.toString();
+  return query ? `?${query}` : '';
+}
+
+// Follows next_cursor until every page has been read.
+async function getAllPages(getPage: (options: ListOptions) => Promise<Page<Summary>>): Promise<Summary[]> {
+  const items: Summary[] = [];
+  let cursor: string | undefined;
+  do {
+    const page = await getPage({ limit: 200, cursor });
+    items.push(...page.items);
+    cursor = page.next_cursor;
+  } while (cursor);
+  return items;
+}
+
 // Schema API
-export async function getSchemas(user: string): Promise<Schema[]> {
-  const response = await fetch(`${API_BASE}/${user}/schemas`);
-  return handleResponse<Schema[]>(response);
+export async function getSchemaPage(user: string, options: ListOptions = {}): Promise<Page<Summary>> {
+  const response = await fetch(`${API_BASE}/${user}/schemas${listQuery(options)}`);
+  return handleResponse<Page<Summary>>(response);
+}
+
+export async function getSchemas(user: string): Promise<Summary[]> {
+  return getAllPages(options => getSchemaPage(user, options));
 }
 
 export async function createSchema(user: string, data: NewSchemaRequest): Promise<Schema> {
@@ -35,9 +62,13 @@ export async function saveSchema(user: string, schema: Schema): Promise<string>
 }
 
 // Instance API
-export async function getInstances(user: string): Promise<Instance[]> {
-  const response = await fetch(`${API_BASE}/${user}/instances`);
-  return handleResponse<Instance[]>(response);
+export async function getInstancePage(user: string, options: ListOptions = {}): Promise<Page<Summary>> {
+  const response = await fetch(`${API_BASE}/${user}/instances${listQuery(options)}`);
+  return handleResponse<Page<Summary>>(response);
+}
+
+export async function getInstances(user: string): Promise<Summary[]> {
+  return getAllPages(options => getInstancePage(user, options));
 }
 
 export async function createInstance(user: string, data: NewInstanceRequest): Promise<Instance> {
@@ -57,3 +88,8 @@ export async function saveInstance(user: string, instance: Instance): Promise<st
   });
   return response.text();
 }
+
+export async function getVisualization(user: string, id: string): Promise<ResolvedVisualization> {
+  const response = await fetch(`${API_BASE}/${user}/instances/${id}/visualization`);
+  return handleResponse<ResolvedVisualization>(response);
+}
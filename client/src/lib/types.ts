@@ -17,6 +17,46 @@ export interface Instance {
   updated_at: string;
 }
 
+// Returned by the listing endpoints instead of full documents
+export interface Summary {
+  _id: string;
+  schema_id?: string;
+  name: string;
+  description: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface Page<T> {
+  items: T[];
+  total: number;
+  next_cursor?: string;
+}
+
+export interface ListOptions {
+  limit?: number;
+  sort?: 'updated_at' | 'created_at' | 'name' | 'relevance';
+  order?: 'asc' | 'desc';
+  cursor?: string;
+  q?: string;
+}
+
+export type VisualizationType =
+  'grid' | 'row' | 'accordion' | 'single_field' | 'slot_tracker' | 'card' | 'tabs' | 'default' | 'custom';
+
+// An instance's visualization with values filled in by the server
+export interface ResolvedVisualization {
+  name: string;
+  type: VisualizationType;
+  columns?: number;
+  field?: string;
+  value?: unknown;
+  max?: unknown;
+  open?: boolean;
+  error?: string;
+  children?: ResolvedVisualization[];
+}
+
 export interface NewSchemaRequest {
   name: string;
   description: string;
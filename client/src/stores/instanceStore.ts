@@ -1,16 +1,16 @@
 import { writable } from 'svelte/store';
 import type { Writable } from 'svelte/store';
 import * as api from '../lib/api';
-import type { Instance, NewInstanceRequest } from '../lib/types';
+import type { Instance, Summary, NewInstanceRequest } from '../lib/types';
 
-interface InstanceStore extends Writable<Instance[]> {
+interface InstanceStore extends Writable<Summary[]> {
   load: (user: string) => Promise<void>;
   create: (user: string, data: NewInstanceRequest) => Promise<Instance>;
   save: (user: string, instance: Instance) => Promise<void>;
 }
 
 function createInstanceStore(): InstanceStore {
-  const { subscribe, set, update } = writable<Instance[]>([]);
+  const { subscribe, set, update } = writable<Summary[]>([]);
 
   return {
     subscribe,
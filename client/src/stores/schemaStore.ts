@@ -1,16 +1,16 @@
 import { writable } from 'svelte/store';
 import type { Writable } from 'svelte/store';
 import * as api from '../lib/api';
-import type { Schema, NewSchemaRequest } from '../lib/types';
+import type { Schema, Summary, NewSchemaRequest } from '../lib/types';
 
-interface SchemaStore extends Writable<Schema[]> {
+interface SchemaStore extends Writable<Summary[]> {
   load: (user: string) => Promise<void>;
   create: (user: string, data: NewSchemaRequest) => Promise<Schema>;
   save: (user: string, schema: Schema) => Promise<void>;
 }
 
 function createSchemaStore(): SchemaStore {
-  const { subscribe, set, update } = writable<Schema[]>([]);
+  const { subscribe, set, update } = writable<Summary[]>([]);
 
   return {
     subscribe,
@@ -42,7 +42,7 @@ function createSchemaStore(): SchemaStore {
       try {
         await api.saveSchema(user, schema);
         update(schemas => 
-          schemas.map(s => s.id === schema.id ? schema : s)
+          schemas.map(s => s._id === schema._id ? schema : s)
         );
       } catch (error) {
         console.error('Failed to save schema:', error);
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Attachments
+//
+// Uploading and downloading the files in the BlobStore. Uploads answer with
+// the file's hash, which is what goes into attachment variables. Since a
+// hash always names the same content, downloads can be cached forever.
+
+// Room for the multipart headers around an upload of maxAttachmentSize.
+const maxUploadSize = maxAttachmentSize + 1<<20
+
+// Reads the upload from a multipart "file" field or the raw request body.
+// The body is capped before anything parses it.
+func readAttachmentUpload(c echo.Context) ([]byte, error) {
+	request := c.Request()
+	request.Body = http.MaxBytesReader(c.Response(), request.Body, maxUploadSize)
+	var reader io.Reader = request.Body
+
+	file, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return nil, errAttachmentTooLarge
+	case err == nil:
+		upload, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer upload.Close()
+		reader = upload
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxAttachmentSize+1))
+	if errors.As(err, &tooLarge) {
+		return nil, errAttachmentTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAttachmentSize {
+		return nil, errAttachmentTooLarge
+	}
+	return data, nil
+}
+
+func attachmentError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errAttachmentNotFound):
+		return httpError(c, http.StatusNotFound, err)
+	case errors.Is(err, errAttachmentTooLarge), errors.Is(err, errImageTooLarge):
+		return httpError(c, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, errAttachmentType):
+		return httpError(c, http.StatusUnsupportedMediaType, err)
+	}
+	return httpError(c, http.StatusBadRequest, err)
+}
+
+func registerAttachmentRoutes(u *echo.Group, blobs *BlobStore) {
+	attachments := u.Group("/attachments")
+
+	attachments.POST("/upload", func(c echo.Context) error {
+		data, err := readAttachmentUpload(c)
+		if err != nil {
+			return attachmentError(c, err)
+		}
+		attachment, err := blobs.Put(c.Param("user"), data)
+		if err != nil {
+			return attachmentError(c, err)
+		}
+		return c.JSON(http.StatusOK, attachment)
+	})
+
+	attachments.GET("/:hash/info", func(c echo.Context) error {
+		attachment, err := blobs.Stat(c.Param("user"), c.Param("hash"))
+		if err != nil {
+			return attachmentError(c, err)
+		}
+		return c.JSON(http.StatusOK, attachment)
+	})
+
+	serve := func(thumbnail bool) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			attachment, path, err := blobs.Open(c.Param("user"), c.Param("hash"), thumbnail)
+			if err != nil {
+				return attachmentError(c, err)
+			}
+			contentType := attachment.ContentType
+			if thumbnail {
+				contentType = "image/png"
+			} else if contentType == "text/plain" {
+				contentType += "; charset=utf-8"
+			}
+
+			header := c.Response().Header()
+			header.Set(echo.HeaderContentType, contentType)
+			header.Set("Cache-Control", "public, max-age=31536000, immutable")
+			header.Set("ETag", fmt.Sprintf("%q", attachment.Hash))
+			header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+			return c.File(path)
+		}
+	}
+	attachments.GET("/:hash", serve(false))
+	attachments.GET("/:hash/thumbnail", serve(true))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestAttachmentRoutes(t *testing.T) {
+	dir := t.TempDir()
+	blobs := NewBlobStore(dir)
+	router := echo.New()
+	registerAttachmentRoutes(router.Group("/:user"), blobs)
+	do := func(method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set(echo.HeaderContentType, contentType)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	portrait := image.NewRGBA(image.Rect(0, 0, 600, 300))
+	for x := range 600 {
+		for y := range 300 {
+			portrait.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	var encoded bytes.Buffer
+	png.Encode(&encoded, portrait)
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "portrait.txt") // the name doesn't decide the type
+	part.Write(encoded.Bytes())
+	writer.Close()
+
+	rec := do(http.MethodPost, "/ada/attachments/upload", writer.FormDataContentType(), form.Bytes())
+	var attachment Attachment
+	json.Unmarshal(rec.Body.Bytes(), &attachment)
+	if rec.Code != http.StatusOK || attachment.ContentType != "image/png" || attachment.Width != 600 || !attachment.Thumbnail {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// same content, no second copy
+	rec = do(http.MethodPost, "/ada/attachments/upload", "image/png", encoded.Bytes())
+	var again Attachment
+	json.Unmarshal(rec.Body.Bytes(), &again)
+	if again.Hash != attachment.Hash || !again.CreatedAt.Equal(attachment.CreatedAt) {
+		t.Errorf("re-upload: %s", rec.Body.String())
+	}
+	if files, _ := os.ReadDir(filepath.Join(dir, "ada")); len(files) != 3 {
+		t.Errorf("stored files: %v", files)
+	}
+
+	rec = do(http.MethodGet, "/ada/attachments/"+attachment.Hash, "", nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), encoded.Bytes()) || rec.Header().Get(echo.HeaderContentType) != "image/png" {
+		t.Errorf("download: %d %s", rec.Code, rec.Header())
+	}
+	rec = do(http.MethodGet, "/ada/attachments/"+attachment.Hash+"/thumbnail", "", nil)
+	thumbnail, err := png.Decode(rec.Body)
+	if err != nil || thumbnail.Bounds().Dx() != thumbnailSize || thumbnail.Bounds().Dy() != thumbnailSize/2 {
+		t.Errorf("thumbnail: %v %v", err, thumbnail)
+	}
+	if rec := do(http.MethodGet, "/bob/attachments/"+attachment.Hash, "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("another user's attachment: got %d", rec.Code)
+	}
+
+	rec = do(http.MethodPost, "/ada/attachments/upload", "", []byte("Dear Ada,\nthe harvest was good."))
+	var letter Attachment
+	json.Unmarshal(rec.Body.Bytes(), &letter)
+	if letter.ContentType != "text/plain" || letter.Thumbnail {
+		t.Errorf("text upload: %s", rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/ada/attachments/"+letter.Hash+"/thumbnail", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("thumbnail of a text file: got %d", rec.Code)
+	}
+	rec = do(http.MethodGet, "/ada/attachments/"+letter.Hash+"/info", "", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"size":31`) {
+		t.Errorf("info: %s", rec.Body.String())
+	}
+
+	// a tiny PNG whose header claims 100000×100000 pixels
+	var huge bytes.Buffer
+	png.Encode(&huge, image.NewGray(image.Rect(0, 0, 1, 1)))
+	header := huge.Bytes()
+	binary.BigEndian.PutUint32(header[16:], 100000)
+	binary.BigEndian.PutUint32(header[20:], 100000)
+	binary.BigEndian.PutUint32(header[29:], crc32.ChecksumIEEE(header[12:29]))
+
+	tests := []struct {
+		body []byte
+		code int
+	}{
+		{[]byte("<html><script>alert(1)</script></html>"), http.StatusUnsupportedMediaType},
+		{bytes.Repeat([]byte("a"), maxAttachmentSize+1), http.StatusRequestEntityTooLarge},
+		{append([]byte("\x89PNG\r\n\x1a\n"), "not really"...), http.StatusBadRequest},
+		{header, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		if rec := do(http.MethodPost, "/ada/attachments/upload", "", test.body); rec.Code != test.code {
+			t.Errorf("upload %.20q: got %d, want %d", test.body, rec.Code, test.code)
+		}
+	}
+	form.Reset()
+	writer = multipart.NewWriter(&form)
+	part, _ = writer.CreateFormFile("file", "big.txt")
+	part.Write(bytes.Repeat([]byte("a"), maxUploadSize))
+	writer.Close()
+	if rec := do(http.MethodPost, "/ada/attachments/upload", writer.FormDataContentType(), form.Bytes()); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized multipart upload: got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/ada/attachments/..%2F..%2Fetc", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("bad hash: got %d", rec.Code)
+	}
+
+	schema := &lib.Schema{Variables: map[string]lib.Variable{"portrait": {Type: lib.TypeAttachment}}}
+	if err := blobs.CheckAttachments("ada", schema, &lib.Instance{VariableValues: map[string]any{"portrait": attachment.Hash}}); err != nil {
+		t.Error(err)
+	}
+	if err := blobs.CheckAttachments("bob", schema, &lib.Instance{VariableValues: map[string]any{"portrait": attachment.Hash}}); err == nil {
+		t.Error("missing attachment accepted")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/plexlad/gardi/server/lib"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Blob store
+//
+// Files behind attachment variables (see lib.TypeAttachment), kept in a
+// directory beside the database's, one per user. Each file is stored under
+// its SHA-256 as <hash>, with what's known about it in <hash>.json and, for
+// images, a PNG thumbnail in <hash>.thumb.png. Storing the same content twice
+// keeps one copy.
+
+const (
+	maxAttachmentSize = 20 << 20
+	thumbnailSize     = 256 // longest side, in pixels
+	// width × height. Decoding takes about 4 bytes a pixel, whatever the
+	// size of the file.
+	maxImagePixels = 40_000_000
+)
+
+// Content types accepted for attachments, as sniffed from the content rather
+// than taken from the upload. Anything a browser would run (HTML, SVG) is
+// left out since attachments are served back as is.
+var attachmentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"text/plain",
+}
+
+var (
+	errAttachmentNotFound = errors.New("attachment not found")
+	errAttachmentTooLarge = fmt.Errorf("attachment larger than %d bytes", maxAttachmentSize)
+	errAttachmentType     = errors.New("unsupported attachment type")
+	errImageTooLarge      = fmt.Errorf("image larger than %d pixels", maxImagePixels)
+)
+
+type Attachment struct {
+	Hash        string    `json:"hash"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	Width       int       `json:"width,omitempty"` // images only
+	Height      int       `json:"height,omitempty"`
+	Thumbnail   bool      `json:"thumbnail"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type BlobStore struct {
+	basePath string
+	mu       sync.Mutex
+}
+
+func NewBlobStore(basePath string) *BlobStore {
+	return &BlobStore{basePath: basePath}
+}
+
+func (b *BlobStore) path(user, hash, suffix string) (string, error) {
+	if !lib.IsAttachmentHash(hash) {
+		return "", errAttachmentNotFound
+	}
+	return filepath.Join(b.basePath, user, hash+suffix), nil
+}
+
+// Stores the file and returns its details. Content that is already stored
+// isn't written again.
+func (b *BlobStore) Put(user string, data []byte) (Attachment, error) {
+	if len(data) > maxAttachmentSize {
+		return Attachment{}, errAttachmentTooLarge
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !slices.Contains(attachmentTypes, contentType) {
+		return Attachment{}, fmt.Errorf("%w %s", errAttachmentType, contentType)
+	}
+
+	sum := sha256.Sum256(data)
+	attachment := Attachment{
+		Hash:        hex.EncodeToString(sum[:]),
+		ContentType: contentType,
+		Size:        len(data),
+		CreatedAt:   time.Now(),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if existing, err := b.stat(user, attachment.Hash); err == nil {
+		return existing, nil
+	}
+
+	var thumbnail []byte
+	if contentType != "application/pdf" && contentType != "text/plain" {
+		// checked before decoding: a small file can claim a huge image
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return Attachment{}, fmt.Errorf("unreadable image: %w", err)
+		}
+		if int64(config.Width)*int64(config.Height) > maxImagePixels {
+			return Attachment{}, fmt.Errorf("%w: %d×%d", errImageTooLarge, config.Width, config.Height)
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Attachment{}, fmt.Errorf("unreadable image: %w", err)
+		}
+		bounds := img.Bounds()
+		attachment.Width, attachment.Height = bounds.Dx(), bounds.Dy()
+		if thumbnail, err = makeThumbnail(img); err != nil {
+			return Attachment{}, err
+		}
+		attachment.Thumbnail = true
+	}
+
+	dir := filepath.Join(b.basePath, user)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Attachment{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, attachment.Hash), data, 0644); err != nil {
+		return Attachment{}, fmt.Errorf("write failed: %w", err)
+	}
+	if thumbnail != nil {
+		if err := os.WriteFile(filepath.Join(dir, attachment.Hash+".thumb.png"), thumbnail, 0644); err != nil {
+			return Attachment{}, fmt.Errorf("write failed: %w", err)
+		}
+	}
+	// written last: a file without its details counts as missing
+	info, err := json.MarshalIndent(attachment, "", " ")
+	if err != nil {
+		return Attachment{}, fmt.Errorf("marshal failed: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, attachment.Hash+".json"), info, 0644); err != nil {
+		return Attachment{}, fmt.Errorf("write failed: %w", err)
+	}
+	return attachment, nil
+}
+
+func (b *BlobStore) Stat(user, hash string) (Attachment, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.stat(user, hash)
+}
+
+// Called with the lock held.
+func (b *BlobStore) stat(user, hash string) (Attachment, error) {
+	path, err := b.path(user, hash, ".json")
+	if err != nil {
+		return Attachment{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Attachment{}, errAttachmentNotFound
+		}
+		return Attachment{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	var attachment Attachment
+	if err := json.Unmarshal(data, &attachment); err != nil {
+		return Attachment{}, fmt.Errorf("failed to unmarshal data: %w", err)
+	}
+	return attachment, nil
+}
+
+// The attachment's details and the path of its file, or of its thumbnail.
+func (b *BlobStore) Open(user, hash string, thumbnail bool) (Attachment, string, error) {
+	attachment, err := b.Stat(user, hash)
+	if err != nil {
+		return Attachment{}, "", err
+	}
+	if !thumbnail {
+		path, err := b.path(user, hash, "")
+		return attachment, path, err
+	}
+	if !attachment.Thumbnail {
+		return Attachment{}, "", fmt.Errorf("%w: %s has no thumbnail", errAttachmentNotFound, hash)
+	}
+	path, err := b.path(user, hash, ".thumb.png")
+	return attachment, path, err
+}
+
+// Checks that every attachment of the instance has been uploaded.
+// The hashes of the instance's attachments that aren't in the store.
+func (b *BlobStore) MissingAttachments(user string, schema *lib.Schema, instance *lib.Instance) []string {
+	var missing []string
+	for _, hash := range schema.Attachments(instance) {
+		if _, err := b.Stat(user, hash); err != nil {
+			missing = append(missing, hash)
+		}
+	}
+	return missing
+}
+
+func (b *BlobStore) CheckAttachments(user string, schema *lib.Schema, instance *lib.Instance) error {
+	for _, hash := range schema.Attachments(instance) {
+		if _, err := b.Stat(user, hash); err != nil {
+			return fmt.Errorf("attachment %s: %w", hash, err)
+		}
+	}
+	return nil
+}
+
+// Scales the image to fit thumbnailSize, keeping its proportions. Smaller
+// images are only re-encoded.
+func makeThumbnail(img image.Image) ([]byte, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if longest := max(width, height); longest > thumbnailSize {
+		width = max(width*thumbnailSize/longest, 1)
+		height = max(height*thumbnailSize/longest, 1)
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, thumbnail); err != nil {
+		return nil, fmt.Errorf("thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Export and import of schema bundles (see lib.WriteBundle for the format).
+
+// Upload limit for POST /:user/import.
+const maxBundleSize = 64 << 20
+
+type ImportResponse struct {
+	SchemaID           string               `json:"schema_id"`
+	Instances          int                  `json:"instances"`
+	IDMap              map[string]string    `json:"id_map"`
+	Conflicts          []lib.BundleConflict `json:"conflicts"`
+	MissingAttachments []string             `json:"missing_attachments"` // sorted hashes
+	DryRun             bool                 `json:"dry_run"`
+}
+
+var errInvalidBundle = errors.New("invalid bundle")
+
+// Writes the remapped bundle. Nothing is written when dryRun is set.
+// Bundles don't carry attachment files, so attachments aren't required to
+// exist; the ones missing here are reported, and uploading a file fills in
+// every variable holding its hash.
+func importBundle(db *JsonDB, blobs *BlobStore, user string, bundle *lib.Bundle, dryRun bool) (*ImportResponse, error) {
+	taken := func(kind, id string) bool {
+		collection := CollectionInstances
+		if kind == "schema" {
+			collection = CollectionSchemas
+		}
+		var existing struct{}
+		return db.Get(collection, user, id, &existing) == nil
+	}
+	newID := func() string { return uuid.New().String() }
+
+	ids, conflicts := bundle.Remap(taken, newID)
+	response := &ImportResponse{
+		SchemaID:  bundle.Schema.ID,
+		Instances: len(bundle.Instances),
+		IDMap:     ids,
+		Conflicts: conflicts,
+		DryRun:    dryRun,
+	}
+	if response.Conflicts == nil {
+		response.Conflicts = []lib.BundleConflict{}
+	}
+
+	// references may point at other instances of the bundle
+	bundled := make(map[string]*lib.Instance, len(bundle.Instances))
+	for i := range bundle.Instances {
+		bundled[bundle.Instances[i].ID] = &bundle.Instances[i]
+	}
+	lookup := func(id string) (*lib.Instance, *lib.Schema, error) {
+		if instance, ok := bundled[id]; ok {
+			return instance, &bundle.Schema, nil
+		}
+		return getInstance(db, user, id)
+	}
+	missing := []string{}
+	for i := range bundle.Instances {
+		instance := &bundle.Instances[i]
+		if err := checkInstanceValues(&bundle.Schema, instance, lookup); err != nil {
+			return nil, fmt.Errorf("%w: instance %s: %w", errInvalidBundle, instance.ID, err)
+		}
+		missing = append(missing, blobs.MissingAttachments(user, &bundle.Schema, instance)...)
+	}
+	slices.Sort(missing)
+	response.MissingAttachments = slices.Compact(missing)
+	if dryRun {
+		return response, nil
+	}
+
+	now := time.Now()
+	bundle.Schema.UpdatedAt = now
+	if err := db.Set(CollectionSchemas, user, bundle.Schema.ID, bundle.Schema); err != nil {
+		return nil, fmt.Errorf("failed to save schema: %w", err)
+	}
+	for _, instance := range bundle.Instances {
+		instance.UserID = user
+		instance.UpdatedAt = now
+		if err := db.Set(CollectionInstances, user, instance.ID, instance); err != nil {
+			return nil, fmt.Errorf("failed to save instance %s: %w", instance.ID, err)
+		}
+	}
+
+	return response, nil
+}
+
+// Reads the upload from a multipart "bundle" field or the raw request body.
+func readBundleUpload(c echo.Context) ([]byte, error) {
+	var reader io.Reader = c.Request().Body
+
+	if file, err := c.FormFile("bundle"); err == nil {
+		upload, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer upload.Close()
+		reader = upload
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxBundleSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBundleSize {
+		return nil, fmt.Errorf("bundle larger than %d bytes", maxBundleSize)
+	}
+	return data, nil
+}
+
+func registerBundleRoutes(u *echo.Group, db *JsonDB, blobs *BlobStore) {
+	// ?instances=false leaves the instances out
+	u.GET("/schemas/:id/export", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		var schema lib.Schema
+		if err := db.Get(CollectionSchemas, user, id, &schema); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		withInstances := true
+		if param := c.QueryParam("instances"); param != "" {
+			parsed, err := strconv.ParseBool(param)
+			if err != nil {
+				return httpError(c, http.StatusBadRequest, fmt.Errorf("instances must be true or false"))
+			}
+			withInstances = parsed
+		}
+
+		instances := []lib.Instance{}
+		if withInstances {
+			ids, err := db.Lookup(CollectionInstances, IndexInstancesBySchema, user, id)
+			if err != nil {
+				return httpError(c, http.StatusInternalServerError, err)
+			}
+			found, err := GetMany[lib.Instance](db, CollectionInstances, user, ids)
+			if err != nil {
+				return httpError(c, http.StatusInternalServerError, err)
+			}
+			for _, instance := range found {
+				instances = append(instances, instance)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := lib.WriteBundle(&buf, schema, instances, time.Now().UTC()); err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition,
+			fmt.Sprintf(`attachment; filename="%s.gardi.zip"`, id))
+		return c.Blob(http.StatusOK, "application/zip", buf.Bytes())
+	})
+
+	// ?dry_run=true validates and reports conflicts without saving
+	u.POST("/import", func(c echo.Context) error {
+		user := c.Param("user")
+
+		data, err := readBundleUpload(c)
+		if err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		bundle, err := lib.ReadBundle(data)
+		if err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+		response, err := importBundle(db, blobs, user, bundle, dryRun)
+		if errors.Is(err, errInvalidBundle) {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, response)
+	})
+}
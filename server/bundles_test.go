@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestImportBundleTwice(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	blobs := NewBlobStore(t.TempDir())
+	createIndexes(db)
+
+	var buf bytes.Buffer
+	schema := lib.Schema{ID: "party", Name: "Party", Variables: map[string]lib.Variable{
+		"ally": {Type: lib.TypeReference, Schema: "party"},
+	}}
+	instances := []lib.Instance{
+		{ID: "hero", SchemaID: "party", Name: "Hero", VariableValues: map[string]any{"ally": "sidekick"}},
+		{ID: "sidekick", SchemaID: "party", Name: "Sidekick"},
+	}
+	if err := lib.WriteBundle(&buf, schema, instances, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func() *lib.Bundle {
+		bundle, err := lib.ReadBundle(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bundle
+	}
+
+	first, err := importBundle(db, blobs, "gm", load(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.SchemaID != "party" || len(first.Conflicts) != 0 {
+		t.Errorf("first import should keep ids: %+v", first)
+	}
+
+	dry, err := importBundle(db, blobs, "gm", load(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dry.Conflicts) != 3 {
+		t.Errorf("dry run should report every collision: %+v", dry.Conflicts)
+	}
+	if ids, _ := db.List(CollectionSchemas, "gm"); len(ids) != 1 {
+		t.Errorf("dry run wrote schemas: %v", ids)
+	}
+
+	second, err := importBundle(db, blobs, "gm", load(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.SchemaID == "party" {
+		t.Fatal("second import reused the schema id")
+	}
+	ids, err := db.Lookup(CollectionInstances, IndexInstancesBySchema, "gm", second.SchemaID)
+	if err != nil || len(ids) != 2 || ids[0] == "hero" || ids[1] == "hero" {
+		t.Errorf("expected two remapped instances under the new schema, got %v (%v)", ids, err)
+	}
+	hero, _, err := getInstance(db, "gm", second.IDMap["hero"])
+	if err != nil || hero.VariableValues["ally"] != second.IDMap["sidekick"] {
+		t.Errorf("the reference should follow the remapped instance: %v (%v)", hero, err)
+	}
+
+	buf.Reset()
+	instances = []lib.Instance{{ID: "loner", SchemaID: "party", VariableValues: map[string]any{"ally": "nobody"}}}
+	if err := lib.WriteBundle(&buf, schema, instances, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importBundle(db, blobs, "gm", load(), true); !errors.Is(err, errInvalidBundle) {
+		t.Errorf("a dangling reference should be rejected: %v", err)
+	}
+
+	// attachment files stay behind on the old server
+	buf.Reset()
+	schema.Variables["portrait"] = lib.Variable{Type: lib.TypeAttachment}
+	portrait := strings.Repeat("ab", 32)
+	instances = []lib.Instance{{ID: "painted", SchemaID: "party", VariableValues: map[string]any{"portrait": portrait}}}
+	if err := lib.WriteBundle(&buf, schema, instances, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := importBundle(db, blobs, "gm", load(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(moved.MissingAttachments, []string{portrait}) {
+		t.Errorf("missing attachments: %v", moved.MissingAttachments)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Command line tools, run as `server <command> [args]`.
+// Without a command the server starts as usual.
+
+const usage = `usage:
+  server                               start the web server
+  server convert [flags] input [output]
+      convert a schema between json, yaml and toml
+`
+
+// Returns the process exit code.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	switch args[0] {
+	case "convert":
+		if err := runConvert(args[1:], stdout); err != nil {
+			fmt.Fprintln(stderr, "convert:", err)
+			return 1
+		}
+		return 0
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q\n%s", args[0], usage)
+	return 2
+}
+
+// Reads a schema in one format and writes it in another. Formats come from
+// the file extensions unless -from/-to are given; "-" means stdin/stdout.
+// The schema is validated before it's written.
+func runConvert(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	from := flags.String("from", "", "input format (json, yaml, toml)")
+	to := flags.String("to", "", "output format (json, yaml, toml)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return fmt.Errorf("expected an input file and an optional output file")
+	}
+
+	input := flags.Arg(0)
+	output := "-"
+	if flags.NArg() == 2 {
+		output = flags.Arg(1)
+	}
+
+	inFormat, err := pickFormat(*from, input)
+	if err != nil {
+		return err
+	}
+	outFormat, err := pickFormat(*to, output)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if input == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(input)
+	}
+	if err != nil {
+		return err
+	}
+
+	schema, err := lib.UnmarshalSchema(data, inFormat)
+	if err != nil {
+		return err
+	}
+	if err := schema.Validate(); err != nil {
+		return err
+	}
+
+	converted, err := lib.MarshalSchema(schema, outFormat)
+	if err != nil {
+		return err
+	}
+
+	if output == "-" {
+		_, err = stdout.Write(converted)
+		return err
+	}
+	return os.WriteFile(output, converted, 0644)
+}
+
+// Explicit name wins, then the file extension. Stdin/stdout default to JSON.
+func pickFormat(name, file string) (lib.SchemaFormat, error) {
+	if name != "" {
+		return lib.ParseSchemaFormat(name)
+	}
+	if file == "-" {
+		return lib.SchemaJSON, nil
+	}
+	return lib.SchemaFormatForFile(file)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertCommand(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "monster.yaml")
+	os.WriteFile(input, []byte(`
+_id: monster
+name: Monster
+# comments are fine in yaml
+variables:
+  hp: {type: number, min: 0}
+`), 0644)
+
+	output := filepath.Join(dir, "monster.toml")
+	var stdout, stderr bytes.Buffer
+	if code := runCommand([]string{"convert", input, output}, &stdout, &stderr); code != 0 {
+		t.Fatalf("convert failed (%d): %s", code, stderr.String())
+	}
+
+	stdout.Reset()
+	if code := runCommand([]string{"convert", "-to", "json", output}, &stdout, &stderr); code != 0 {
+		t.Fatalf("convert back failed (%d): %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), `"_id": "monster"`) || !strings.Contains(stdout.String(), `"min": 0`) {
+		t.Errorf("unexpected json output:\n%s", stdout.String())
+	}
+
+	if code := runCommand([]string{"convert", filepath.Join(dir, "schema.txt")}, &stdout, &stderr); code == 0 {
+		t.Error("expected an unknown extension to fail")
+	}
+	if code := runCommand([]string{"bogus"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected usage exit code for unknown commands, got %d", code)
+	}
+}
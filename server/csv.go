@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Spreadsheet export and import of a schema's instances (see
+// lib.WriteInstancesCSV for the columns).
+
+// Upload limit for POST /:user/schemas/:id/instances.csv.
+const maxCSVSize = 16 << 20
+
+type CSVImportResponse struct {
+	Created []string       `json:"created"`
+	Updated []string       `json:"updated"`
+	Errors  []lib.CSVError `json:"errors"`
+	DryRun  bool           `json:"dry_run"`
+}
+
+// Rows with an _id of an instance of this schema update it; other rows
+// create an instance (keeping the _id if one was given). Rows with errors,
+// including instances that fail the checks of a save, are skipped and
+// reported. Nothing is written when dryRun is set.
+func importInstancesCSV(db *JsonDB, blobs *BlobStore, hooks *WebhookDispatcher, user string, schema *lib.Schema, data []byte, dryRun bool) (*CSVImportResponse, error) {
+	rows, problems, err := lib.ParseInstancesCSV(bytes.NewReader(data), schema)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &CSVImportResponse{
+		Created: []string{},
+		Updated: []string{},
+		Errors:  problems,
+		DryRun:  dryRun,
+	}
+
+	now := time.Now()
+	for _, row := range rows {
+		var previous *lib.Instance
+		var instance lib.Instance
+		if row.ID != "" && db.Get(CollectionInstances, user, row.ID, &instance) == nil {
+			if instance.SchemaID != schema.ID {
+				response.Errors = append(response.Errors, lib.CSVError{
+					Row: row.Line, Column: "_id",
+					Error: fmt.Sprintf("instance %s belongs to another schema", row.ID),
+				})
+				continue
+			}
+			stored := instance
+			previous = &stored
+			if row.Name != "" {
+				instance.Name = row.Name
+			}
+			if row.Description != "" {
+				instance.Description = row.Description
+			}
+		} else {
+			instance = lib.Instance{
+				ID:          row.ID,
+				SchemaID:    schema.ID,
+				UserID:      user,
+				Name:        row.Name,
+				Description: row.Description,
+				CreatedAt:   now,
+			}
+			if instance.ID == "" {
+				instance.ID = uuid.New().String()
+			}
+		}
+
+		for name, value := range row.Values {
+			instance.SetVariable(name, value)
+		}
+		if err := checkInstance(blobs, user, schema, &instance, instanceLookup(db, user)); err != nil {
+			response.Errors = append(response.Errors, lib.CSVError{Row: row.Line, Error: err.Error()})
+			continue
+		}
+		instance.UpdatedAt = now
+
+		if previous == nil {
+			response.Created = append(response.Created, instance.ID)
+		} else {
+			response.Updated = append(response.Updated, instance.ID)
+		}
+		if dryRun {
+			continue
+		}
+
+		if err := db.Set(CollectionInstances, user, instance.ID, instance); err != nil {
+			return nil, fmt.Errorf("failed to save instance %s: %w", instance.ID, err)
+		}
+		if previous == nil {
+			hooks.DispatchInstance(user, EventInstanceCreated, nil, &instance)
+		} else {
+			hooks.DispatchInstance(user, EventInstanceSaved, previous, &instance)
+		}
+	}
+
+	return response, nil
+}
+
+// Reads the upload from a multipart "file" field or the raw request body.
+func readCSVUpload(c echo.Context) ([]byte, error) {
+	var reader io.Reader = c.Request().Body
+
+	if file, err := c.FormFile("file"); err == nil {
+		upload, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer upload.Close()
+		reader = upload
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxCSVSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCSVSize {
+		return nil, fmt.Errorf("csv larger than %d bytes", maxCSVSize)
+	}
+	return data, nil
+}
+
+func registerCSVRoutes(schemas *echo.Group, db *JsonDB, blobs *BlobStore, hooks *WebhookDispatcher) {
+	schemas.GET("/:id/instances.csv", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		var schema lib.Schema
+		if err := db.Get(CollectionSchemas, user, id, &schema); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		ids, err := db.Lookup(CollectionInstances, IndexInstancesBySchema, user, id)
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		found, err := GetMany[lib.Instance](db, CollectionInstances, user, ids)
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		instances := make([]lib.Instance, 0, len(found))
+		for _, id := range ids {
+			if instance, ok := found[id]; ok {
+				instances = append(instances, instance)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := lib.WriteInstancesCSV(&buf, &schema, instances, evalOptions(db, user)); err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition,
+			fmt.Sprintf(`attachment; filename="%s.csv"`, id))
+		return c.Blob(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+	})
+
+	// ?dry_run=true checks the file and reports what would change
+	schemas.POST("/:id/instances.csv", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		var schema lib.Schema
+		if err := db.Get(CollectionSchemas, user, id, &schema); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		data, err := readCSVUpload(c)
+		if err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+		response, err := importInstancesCSV(db, blobs, hooks, user, &schema, data, dryRun)
+		if err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		return c.JSON(http.StatusOK, response)
+	})
+}
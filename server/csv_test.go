@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestInstancesCSVRoutes(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	createIndexes(db)
+	hooks := NewWebhookDispatcher(db)
+
+	schema := lib.Schema{
+		ID: "dnd",
+		Variables: map[string]lib.Variable{
+			"level":  {Type: lib.TypeNumber, Default: 1.0},
+			"mentor": {Type: lib.TypeReference},
+		},
+		Properties: map[string]lib.Property{"double": {Formula: "level * 2"}},
+	}
+	db.Set(CollectionSchemas, "gm", "dnd", schema)
+	db.Set(CollectionSchemas, "gm", "other", lib.Schema{ID: "other"})
+	db.Set(CollectionInstances, "gm", "hero", lib.Instance{ID: "hero", SchemaID: "dnd", Name: "Hero"})
+	db.Set(CollectionInstances, "gm", "stranger", lib.Instance{ID: "stranger", SchemaID: "other"})
+
+	router := echo.New()
+	schemas := router.Group("/:user/schemas")
+	registerCSVRoutes(schemas, db, NewBlobStore(t.TempDir()), hooks)
+	schemas.GET("/:id/instances", func(c echo.Context) error { return c.String(http.StatusOK, "list") })
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/gm/schemas/dnd/instances", ""); rec.Body.String() != "list" {
+		t.Fatalf("instances route shadowed: %q", rec.Body.String())
+	}
+
+	body := "_id,name,level,mentor\n" +
+		"hero,,4,\n" +
+		",Sidekick,2,hero\n" +
+		",Bad,lots,\n" +
+		"stranger,,3,\n" +
+		"../../escape,,1,\n" +
+		",Orphan,1,nobody\n"
+	rec := do(http.MethodPost, "/gm/schemas/dnd/instances.csv?dry_run=true", body)
+	var dry CSVImportResponse
+	json.Unmarshal(rec.Body.Bytes(), &dry)
+	if rec.Code != http.StatusOK || len(dry.Created) != 1 || len(dry.Updated) != 1 || len(dry.Errors) != 4 {
+		t.Fatalf("unexpected dry run %d: %s", rec.Code, rec.Body.String())
+	}
+	if ids, _ := db.List(CollectionInstances, "gm"); len(ids) != 2 {
+		t.Errorf("dry run wrote instances: %v", ids)
+	}
+
+	rec = do(http.MethodPost, "/gm/schemas/dnd/instances.csv", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import failed %d: %s", rec.Code, rec.Body.String())
+	}
+	var hero lib.Instance
+	db.Get(CollectionInstances, "gm", "hero", &hero)
+	if level, _ := hero.GetNumber("level"); level != 4 || hero.Name != "Hero" {
+		t.Errorf("hero not updated: %+v", hero)
+	}
+
+	rec = do(http.MethodGet, "/gm/schemas/dnd/instances.csv", "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), "text/csv") {
+		t.Fatalf("export failed %d: %s", rec.Code, rec.Body.String())
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != "_id,name,description,level,mentor,double" || !slices.Contains(lines, "hero,Hero,,4,,8") {
+		t.Errorf("unexpected export:\n%s", rec.Body.String())
+	}
+}
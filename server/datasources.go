@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Incoming REST data sources
+//
+// A schema can declare data sources (see lib.DataSource). Refreshing an
+// instance calls each source and copies the mapped response fields into the
+// instance's variables. Sources with refresh_seconds set are also refreshed
+// in the background by the DataSourceScheduler.
+
+// Responses larger than this are rejected.
+const maxSourceResponseBytes = 1 << 20
+
+// Outcome of one data source for one refresh.
+type RefreshResult struct {
+	Source  string         `json:"source"`
+	Updated map[string]any `json:"updated"`
+	Errors  []string       `json:"errors,omitempty"`
+}
+
+type RefreshResponse struct {
+	Instance lib.Instance    `json:"instance"`
+	Results  []RefreshResult `json:"results"`
+}
+
+type SourceFetcher struct {
+	client *http.Client
+}
+
+func NewSourceFetcher() *SourceFetcher {
+	return &SourceFetcher{client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// Calls the source for the instance and returns the decoded JSON body.
+func (f *SourceFetcher) Fetch(ctx context.Context, source lib.DataSource, instance *lib.Instance) (any, error) {
+	var body io.Reader
+	if source.Body != "" {
+		body = strings.NewReader(lib.ExpandTemplate(source.Body, instance))
+	}
+
+	url := lib.ExpandURL(source.URL, instance)
+	req, err := http.NewRequestWithContext(ctx, source.HTTPMethod(), url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+	if body != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for key, value := range source.Headers {
+		req.Header.Set(key, lib.ExpandTemplate(value, instance))
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if len(data) > maxSourceResponseBytes {
+		return nil, fmt.Errorf("response larger than %d bytes", maxSourceResponseBytes)
+	}
+
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("response is not JSON: %w", err)
+	}
+
+	return doc, nil
+}
+
+// A source's decoded response, or why it couldn't be fetched.
+type sourceResponse struct {
+	source string
+	doc    any
+	err    error
+}
+
+// Calls the named sources (all of them when names is empty) for the instance.
+func (f *SourceFetcher) fetchAll(ctx context.Context, schema *lib.Schema, instance *lib.Instance, names []string) ([]sourceResponse, error) {
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(schema.DataSources))
+	}
+
+	responses := make([]sourceResponse, 0, len(names))
+	for _, name := range names {
+		source, ok := schema.DataSources[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown data source %q", name)
+		}
+
+		doc, err := f.Fetch(ctx, source, instance)
+		responses = append(responses, sourceResponse{source: name, doc: doc, err: err})
+	}
+
+	return responses, nil
+}
+
+// Copies the mapped fields of the responses into the instance's variables.
+func applyResponses(schema *lib.Schema, instance *lib.Instance, responses []sourceResponse) []RefreshResult {
+	results := make([]RefreshResult, 0, len(responses))
+	for _, response := range responses {
+		result := RefreshResult{Source: response.source, Updated: map[string]any{}}
+		if response.err != nil {
+			result.Errors = append(result.Errors, response.err.Error())
+			results = append(results, result)
+			continue
+		}
+
+		source := schema.DataSources[response.source]
+		updated, errs := source.Apply(schema, instance, response.doc)
+		result.Updated = updated
+		for _, err := range errs {
+			result.Errors = append(result.Errors, err.Error())
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Returned from an update to leave the instance as it is.
+var errUnchanged = errors.New("nothing changed")
+
+// Loads the instance and its schema, calls the sources and, when they
+// changed anything, applies their values to the instance as it is by then,
+// like any other update. Webhooks see the save like any other.
+func refreshInstance(ctx context.Context, db *JsonDB, fetcher *SourceFetcher, hooks *WebhookDispatcher, user, id string, names []string) (*RefreshResponse, error) {
+	instance, schema, err := getInstance(db, user, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the requests run without the database lock
+	responses, err := fetcher.fetchAll(ctx, schema, instance, names)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []RefreshResult
+	updated, err := updateInstance(db, hooks, user, schema, id, func(instance *lib.Instance, _ lib.EvalOptions) error {
+		results = applyResponses(schema, instance, responses)
+		for _, result := range results {
+			if len(result.Updated) > 0 {
+				return nil
+			}
+		}
+		return errUnchanged
+	})
+	if errors.Is(err, errUnchanged) {
+		return &RefreshResponse{Instance: *instance, Results: results}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefreshResponse{Instance: *updated, Results: results}, nil
+}
+
+// Scheduler //
+
+// Periodically refreshes instances whose schema has sources with
+// refresh_seconds set. Last run times are kept in memory, so every scheduled
+// source runs once shortly after the server starts.
+type DataSourceScheduler struct {
+	db      *JsonDB
+	fetcher *SourceFetcher
+	hooks   *WebhookDispatcher
+	tick    time.Duration
+
+	mu      sync.Mutex
+	lastRun map[string]time.Time
+}
+
+func NewDataSourceScheduler(db *JsonDB, fetcher *SourceFetcher, hooks *WebhookDispatcher) *DataSourceScheduler {
+	return &DataSourceScheduler{
+		db:      db,
+		fetcher: fetcher,
+		hooks:   hooks,
+		tick:    15 * time.Second,
+		lastRun: make(map[string]time.Time),
+	}
+}
+
+// Blocks until ctx is cancelled.
+func (s *DataSourceScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	for {
+		s.RunDue(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refreshes every scheduled source that is due at now.
+func (s *DataSourceScheduler) RunDue(ctx context.Context, now time.Time) {
+	all, err := s.db.ListAll(CollectionInstances)
+	if err != nil {
+		return
+	}
+
+	for user, ids := range all {
+		schemas := make(map[string]*lib.Schema)
+
+		for _, id := range ids {
+			var instance lib.Instance
+			if err := s.db.Get(CollectionInstances, user, id, &instance); err != nil {
+				continue
+			}
+
+			schema, ok := schemas[instance.SchemaID]
+			if !ok {
+				schema = &lib.Schema{}
+				if err := s.db.Get(CollectionSchemas, user, instance.SchemaID, schema); err != nil {
+					schema = nil
+				}
+				schemas[instance.SchemaID] = schema
+			}
+			if schema == nil {
+				continue
+			}
+
+			due := s.dueSources(user, id, schema, now)
+			if len(due) == 0 {
+				continue
+			}
+			refreshInstance(ctx, s.db, s.fetcher, s.hooks, user, id, due)
+		}
+	}
+}
+
+func (s *DataSourceScheduler) dueSources(user, id string, schema *lib.Schema, now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []string
+	for name, source := range schema.DataSources {
+		if source.RefreshSeconds <= 0 {
+			continue
+		}
+		key := user + "/" + id + "/" + name
+		if last, ok := s.lastRun[key]; ok && now.Sub(last) < time.Duration(source.RefreshSeconds)*time.Second {
+			continue
+		}
+		s.lastRun[key] = now
+		due = append(due, name)
+	}
+	slices.Sort(due)
+	return due
+}
+
+// Routes //
+
+func registerDataSourceRoutes(instances *echo.Group, db *JsonDB, fetcher *SourceFetcher, hooks *WebhookDispatcher) {
+	// ?source=name limits the refresh to one source, repeatable
+	instances.POST("/:id/refresh", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		if _, _, err := getInstance(db, user, id); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		response, err := refreshInstance(c.Request().Context(), db, fetcher, hooks, user, id, c.QueryParams()["source"])
+		if err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		return c.JSON(http.StatusOK, response)
+	})
+}
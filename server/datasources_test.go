@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Stand-in for a league scoreboard API.
+func newScoreboard(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/players/7" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"player": {"name": "Ana", "season": {"kills": 31, "aces": 4}}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func seedDataSource(t *testing.T, db *JsonDB, url string, refreshSeconds int) {
+	t.Helper()
+	schema := lib.Schema{
+		ID: "volleyball",
+		Variables: map[string]lib.Variable{
+			"player_id": {Type: lib.TypeNumber},
+			"kills":     {Type: lib.TypeNumber},
+			"aces":      {Type: lib.TypeNumber},
+		},
+		DataSources: map[string]lib.DataSource{
+			"scoreboard": {
+				URL: url + "/players/{{player_id}}",
+				Mapping: map[string]string{
+					"kills": "player.season.kills",
+					"aces":  "$.player.season.aces",
+				},
+				RefreshSeconds: refreshSeconds,
+			},
+		},
+	}
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	instance := lib.Instance{
+		ID:             "ana",
+		SchemaID:       "volleyball",
+		VariableValues: map[string]any{"player_id": 7.0},
+	}
+	db.Set(CollectionSchemas, "coach", schema.ID, schema)
+	db.Set(CollectionInstances, "coach", instance.ID, instance)
+}
+
+func TestRefreshInstance(t *testing.T) {
+	server := newScoreboard(t)
+	db := NewJsonDB(t.TempDir())
+	seedDataSource(t, db, server.URL, 0)
+
+	response, err := refreshInstance(context.Background(), db, NewSourceFetcher(), NewWebhookDispatcher(db), "coach", "ana", nil)
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if len(response.Results) != 1 || len(response.Results[0].Errors) != 0 {
+		t.Fatalf("unexpected results: %+v", response.Results)
+	}
+
+	var saved lib.Instance
+	if err := db.Get(CollectionInstances, "coach", "ana", &saved); err != nil {
+		t.Fatal(err)
+	}
+	if kills, _ := saved.GetNumber("kills"); kills != 31 {
+		t.Errorf("expected kills 31, got %v", saved.VariableValues["kills"])
+	}
+	if aces, _ := saved.GetNumber("aces"); aces != 4 {
+		t.Errorf("expected aces 4, got %v", saved.VariableValues["aces"])
+	}
+
+	if _, err := refreshInstance(context.Background(), db, NewSourceFetcher(), NewWebhookDispatcher(db), "coach", "ana", []string{"nope"}); err == nil {
+		t.Error("expected an unknown source name to fail")
+	}
+
+	router := echo.New()
+	registerDataSourceRoutes(router.Group("/:user/instances"), db, NewSourceFetcher(), NewWebhookDispatcher(db))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/coach/instances/nobody/refresh", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("refreshing an unknown instance: got %d", rec.Code)
+	}
+}
+
+// Changes made while the sources are called survive, and conditional
+// features follow the new values.
+func TestRefreshInstanceMergesChanges(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ana lib.Instance
+		db.Get(CollectionInstances, "coach", "ana", &ana)
+		ana.Name = "Ana Souza"
+		db.Set(CollectionInstances, "coach", "ana", ana)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"player": {"season": {"kills": 31, "aces": 4}}}`))
+	}))
+	t.Cleanup(server.Close)
+	seedDataSource(t, db, server.URL, 0)
+
+	var schema lib.Schema
+	db.Get(CollectionSchemas, "coach", "volleyball", &schema)
+	schema.Features = map[string]lib.Feature{"hitter": {Condition: "kills > 30"}}
+	db.Set(CollectionSchemas, "coach", "volleyball", schema)
+
+	if _, err := refreshInstance(context.Background(), db, NewSourceFetcher(), NewWebhookDispatcher(db), "coach", "ana", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var saved lib.Instance
+	db.Get(CollectionInstances, "coach", "ana", &saved)
+	if kills, _ := saved.GetNumber("kills"); kills != 31 || saved.Name != "Ana Souza" {
+		t.Errorf("refresh lost a change: %+v", saved)
+	}
+	if !slices.Equal(saved.ActiveFeatures, []string{"hitter"}) {
+		t.Errorf("active features: %v", saved.ActiveFeatures)
+	}
+}
+
+func TestDataSourceSchedulerRunsDueSources(t *testing.T) {
+	server := newScoreboard(t)
+	db := NewJsonDB(t.TempDir())
+	seedDataSource(t, db, server.URL, 60)
+
+	scheduler := NewDataSourceScheduler(db, NewSourceFetcher(), NewWebhookDispatcher(db))
+	start := time.Now()
+	scheduler.RunDue(context.Background(), start)
+
+	var saved lib.Instance
+	db.Get(CollectionInstances, "coach", "ana", &saved)
+	if kills, _ := saved.GetNumber("kills"); kills != 31 {
+		t.Fatalf("expected first scheduled run to refresh, got %v", saved.VariableValues)
+	}
+
+	schema := lib.Schema{}
+	db.Get(CollectionSchemas, "coach", "volleyball", &schema)
+	if due := scheduler.dueSources("coach", "ana", &schema, start.Add(30*time.Second)); len(due) != 0 {
+		t.Errorf("source should not be due before refresh_seconds, got %v", due)
+	}
+	if due := scheduler.dueSources("coach", "ana", &schema, start.Add(61*time.Second)); len(due) != 1 {
+		t.Errorf("source should be due after refresh_seconds, got %v", due)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,32 +13,123 @@ import (
 type JsonDB struct {
 	basePath string
 	mu       sync.RWMutex
+
+	hooksMu sync.RWMutex
+	hooks   map[string][]WriteHook
+
+	// collection -> index name, see db_index.go
+	indexes map[string]map[string]*secondaryIndex
 }
 
+var errNotFound = errors.New("entry not found")
+
+type WriteOp string
+
+const (
+	WriteSet    WriteOp = "set"
+	WriteDelete WriteOp = "delete"
+)
+
+// Called after a successful write to a watched collection. data is the stored
+// JSON for WriteSet and nil for WriteDelete.
+// Hooks run after the database lock is released, so they may read from the
+// database, but they must not block for long.
+type WriteHook func(op WriteOp, user, entry string, data []byte)
+
 func NewJsonDB(basePath string) *JsonDB {
-	return &JsonDB{basePath: basePath}
+	return &JsonDB{basePath: basePath, hooks: make(map[string][]WriteHook)}
+}
+
+// Registers a hook for every write to the collection. Used to keep indexes
+// in sync with the files.
+func (db *JsonDB) Watch(collection string, hook WriteHook) {
+	db.hooksMu.Lock()
+	defer db.hooksMu.Unlock()
+
+	db.hooks[collection] = append(db.hooks[collection], hook)
+}
+
+func (db *JsonDB) notify(collection string, op WriteOp, user, entry string, data []byte) {
+	db.hooksMu.RLock()
+	hooks := db.hooks[collection]
+	db.hooksMu.RUnlock()
+
+	for _, hook := range hooks {
+		hook(op, user, entry, data)
+	}
 }
 
 func (db *JsonDB) Set(collection, user, entry string, data any) error {
+	jsonData, err := db.set(collection, user, entry, data)
+	if err != nil {
+		return err
+	}
+
+	db.notify(collection, WriteSet, user, entry, jsonData)
+	return nil
+}
+
+func (db *JsonDB) set(collection, user, entry string, data any) ([]byte, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	return db.write(collection, user, entry, data)
+}
+
+// Called with the write lock held.
+func (db *JsonDB) write(collection, user, entry string, data any) ([]byte, error) {
 	dir := filepath.Join(db.basePath, collection, user)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to marshal data: %w", err)
+		return nil, fmt.Errorf("failed to marshal data: %w", err)
 	}
 
 	filePath := filepath.Join(dir, entry+".json")
 
 	jsonData, err := json.MarshalIndent(data, "", " ")
 	if err != nil {
-		return fmt.Errorf("marshal failed: %w", err)
+		return nil, fmt.Errorf("marshal failed: %w", err)
 	}
 
 	if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
-		return fmt.Errorf("write failed: %w", err)
+		return nil, fmt.Errorf("write failed: %w", err)
 	}
 
+	db.updateIndexes(collection, user, entry, jsonData)
+	return jsonData, nil
+}
+
+// Reads an entry, passes it to update and writes it back, holding the write
+// lock throughout so concurrent updates of the same entry can't interleave.
+// Nothing is written when update returns an error. update must not use the
+// database except through db.read and db.write, which doesn't run the Watch
+// hooks.
+func Update[T any](db *JsonDB, collection, user, entry string, update func(*T) error) error {
+	jsonData, err := func() ([]byte, error) {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		data, err := os.ReadFile(filepath.Join(db.basePath, collection, user, entry+".json"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, errNotFound
+			}
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+
+		var value T
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal data: %w", err)
+		}
+		if err := update(&value); err != nil {
+			return nil, err
+		}
+		return db.write(collection, user, entry, value)
+	}()
+	if err != nil {
+		return err
+	}
+
+	db.notify(collection, WriteSet, user, entry, jsonData)
 	return nil
 }
 
@@ -45,6 +137,11 @@ func (db *JsonDB) Get(collection, user, entry string, dest any) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	return db.read(collection, user, entry, dest)
+}
+
+// Called with the lock held, e.g. from an Update callback.
+func (db *JsonDB) read(collection, user, entry string, dest any) error {
 	// checks if dest is a pointer
 	if reflect.ValueOf(dest).Kind() != reflect.Pointer {
 		return fmt.Errorf("dest must be a pointer")
@@ -55,7 +152,7 @@ func (db *JsonDB) Get(collection, user, entry string, dest any) error {
 	data, err := os.ReadFile(filepath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("entry not found")
+			return errNotFound
 		}
 		return fmt.Errorf("failed to read file: %w", err)
 	}
@@ -68,6 +165,15 @@ func (db *JsonDB) Get(collection, user, entry string, dest any) error {
 }
 
 func (db *JsonDB) Delete(collection, user, entry string) error {
+	if err := db.delete(collection, user, entry); err != nil {
+		return err
+	}
+
+	db.notify(collection, WriteDelete, user, entry, nil)
+	return nil
+}
+
+func (db *JsonDB) delete(collection, user, entry string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
@@ -75,11 +181,12 @@ func (db *JsonDB) Delete(collection, user, entry string) error {
 
 	if err := os.Remove(filePath); err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("entry not found")
+			return errNotFound
 		}
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
 
+	db.updateIndexes(collection, user, entry, nil)
 	return nil
 }
 
@@ -109,6 +216,73 @@ func (db *JsonDB) List(collection, user string) ([]string, error) {
 	return names, nil
 }
 
+// Reads every entry of a user's collection under a single lock and one
+// directory scan. Entries that fail to decode are skipped.
+func GetAll[T any](db *JsonDB, collection, user string) (map[string]T, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dir := filepath.Join(db.basePath, collection, user)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]T{}, nil
+		}
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	result := make(map[string]T, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		var value T
+		if err := json.Unmarshal(data, &value); err != nil {
+			continue
+		}
+
+		// remove .json extension
+		result[entry.Name()[:len(entry.Name())-5]] = value
+	}
+
+	return result, nil
+}
+
+// Like GetAll but only for the given entries, e.g. the result of Lookup.
+// Missing entries are skipped.
+func GetMany[T any](db *JsonDB, collection, user string, entries []string) (map[string]T, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dir := filepath.Join(db.basePath, collection, user)
+
+	result := make(map[string]T, len(entries))
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry+".json"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+
+		var value T
+		if err := json.Unmarshal(data, &value); err != nil {
+			continue
+		}
+		result[entry] = value
+	}
+
+	return result, nil
+}
+
 func (db *JsonDB) ListAll(collection string) (map[string][]string, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Secondary indexes
+//
+// An index maps a key derived from each stored document to the entries that
+// have it, per user. Indexes live in memory: a user's index is built from the
+// files on the first lookup and then updated by Set and Delete while they
+// still hold the write lock, so lookups never see a stale index.
+
+// Extracts the index keys from a stored document. Returning no keys leaves the
+// entry out of the index.
+type IndexKeyFunc func(data []byte) []string
+
+const (
+	IndexInstancesBySchema = "by_schema"
+	IndexHistoryByInstance = "by_instance"
+)
+
+type secondaryIndex struct {
+	key IndexKeyFunc
+
+	mu    sync.Mutex
+	users map[string]*indexEntries
+}
+
+type indexEntries struct {
+	byKey   map[string]map[string]struct{}
+	byEntry map[string][]string
+}
+
+// Adds an index to a collection. Must be called before the database is used.
+func (db *JsonDB) CreateIndex(collection, name string, key IndexKeyFunc) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.indexes == nil {
+		db.indexes = make(map[string]map[string]*secondaryIndex)
+	}
+	if db.indexes[collection] == nil {
+		db.indexes[collection] = make(map[string]*secondaryIndex)
+	}
+	db.indexes[collection][name] = &secondaryIndex{key: key, users: make(map[string]*indexEntries)}
+}
+
+// Entries of the user's collection that have key in the named index, sorted.
+func (db *JsonDB) Lookup(collection, name, user, key string) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	index, ok := db.indexes[collection][name]
+	if !ok {
+		return nil, fmt.Errorf("no index %q on %s", name, collection)
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	entries, ok := index.users[user]
+	if !ok {
+		var err error
+		entries, err = index.build(filepath.Join(db.basePath, collection, user))
+		if err != nil {
+			return nil, err
+		}
+		index.users[user] = entries
+	}
+
+	ids := make([]string, 0, len(entries.byKey[key]))
+	for id := range entries.byKey[key] {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Called with the write lock held.
+func (db *JsonDB) updateIndexes(collection, user, entry string, data []byte) {
+	for _, index := range db.indexes[collection] {
+		index.mu.Lock()
+		// users that were never looked up get built from disk later
+		if entries, ok := index.users[user]; ok {
+			entries.remove(entry)
+			if data != nil {
+				entries.add(entry, index.key(data))
+			}
+		}
+		index.mu.Unlock()
+	}
+}
+
+// Reads every document in dir to build one user's entries.
+func (index *secondaryIndex) build(dir string) (*indexEntries, error) {
+	entries := &indexEntries{
+		byKey:   make(map[string]map[string]struct{}),
+		byEntry: make(map[string][]string),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return entries, nil
+		}
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		// remove .json extension
+		entries.add(file.Name()[:len(file.Name())-5], index.key(data))
+	}
+
+	return entries, nil
+}
+
+func (e *indexEntries) add(entry string, keys []string) {
+	for _, key := range keys {
+		if e.byKey[key] == nil {
+			e.byKey[key] = make(map[string]struct{})
+		}
+		e.byKey[key][entry] = struct{}{}
+	}
+	e.byEntry[entry] = keys
+}
+
+func (e *indexEntries) remove(entry string) {
+	for _, key := range e.byEntry[entry] {
+		delete(e.byKey[key], entry)
+		if len(e.byKey[key]) == 0 {
+			delete(e.byKey, key)
+		}
+	}
+	delete(e.byEntry, entry)
+}
+
+// Key functions //
+
+func instanceSchemaKey(data []byte) []string {
+	var instance struct {
+		SchemaID string `json:"schema_id"`
+	}
+	if err := json.Unmarshal(data, &instance); err != nil || instance.SchemaID == "" {
+		return nil
+	}
+	return []string{instance.SchemaID}
+}
+
+func historyInstanceKey(data []byte) []string {
+	var entry struct {
+		InstanceID string `json:"instance_id"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil || entry.InstanceID == "" {
+		return nil
+	}
+	return []string{entry.InstanceID}
+}
+
+// Indexes every part of the server relies on.
+func createIndexes(db *JsonDB) {
+	db.CreateIndex(CollectionInstances, IndexInstancesBySchema, instanceSchemaKey)
+	db.CreateIndex(CollectionHistory, IndexHistoryByInstance, historyInstanceKey)
+}
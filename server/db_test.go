@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestSchemaIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	// written before the index exists, so the first lookup reads from disk
+	seed := NewJsonDB(dir)
+	seed.Set(CollectionInstances, "gm", "a", lib.Instance{ID: "a", SchemaID: "dnd"})
+	seed.Set(CollectionInstances, "gm", "b", lib.Instance{ID: "b", SchemaID: "dnd"})
+	seed.Set(CollectionInstances, "gm", "c", lib.Instance{ID: "c", SchemaID: "volleyball"})
+
+	db := NewJsonDB(dir)
+	createIndexes(db)
+
+	lookup := func(schemaID string) []string {
+		t.Helper()
+		ids, err := db.Lookup(CollectionInstances, IndexInstancesBySchema, "gm", schemaID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+
+	if got := lookup("dnd"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("dnd: got %v", got)
+	}
+
+	// moving an instance to another schema updates both keys
+	db.Set(CollectionInstances, "gm", "b", lib.Instance{ID: "b", SchemaID: "volleyball"})
+	db.Delete(CollectionInstances, "gm", "c")
+	db.Set(CollectionInstances, "gm", "d", lib.Instance{ID: "d", SchemaID: "dnd"})
+
+	if got := lookup("dnd"); !reflect.DeepEqual(got, []string{"a", "d"}) {
+		t.Errorf("dnd after writes: got %v", got)
+	}
+	if got := lookup("volleyball"); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("volleyball after writes: got %v", got)
+	}
+	if got := lookup("unknown"); len(got) != 0 {
+		t.Errorf("unknown schema: got %v", got)
+	}
+
+	if _, err := db.Lookup(CollectionSchemas, IndexInstancesBySchema, "gm", "dnd"); err == nil {
+		t.Error("expected lookup on an unindexed collection to fail")
+	}
+
+	instances, err := GetMany[lib.Instance](db, CollectionInstances, "gm", []string{"a", "missing"})
+	if err != nil || len(instances) != 1 || instances["a"].SchemaID != "dnd" {
+		t.Errorf("GetMany: got %v (%v)", instances, err)
+	}
+}
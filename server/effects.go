@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Temporary effects on instances (see lib.Effect).
+
+type EffectTickRequest struct {
+	Unit   lib.DurationUnit `json:"unit"`
+	Amount int              `json:"amount"` // 1 when unset
+}
+
+type EffectTickResponse struct {
+	Expired []string     `json:"expired"`
+	Effects []lib.Effect `json:"effects"`
+}
+
+func effectList(instance *lib.Instance) []lib.Effect {
+	if instance.Effects == nil {
+		return []lib.Effect{}
+	}
+	return instance.Effects
+}
+
+func registerEffectRoutes(instances *echo.Group, db *JsonDB, hooks *WebhookDispatcher) {
+	instances.GET("/:id/effects", func(c echo.Context) error {
+		instance, _, err := getInstance(db, c.Param("user"), c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		return c.JSON(http.StatusOK, effectList(instance))
+	})
+
+	// adds an effect, replacing one with the same name
+	instances.POST("/:id/effects", func(c echo.Context) error {
+		user := c.Param("user")
+		_, schema, err := getInstance(db, user, c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		var effect lib.Effect
+		if err := c.Bind(&effect); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		instance, err := updateInstance(db, hooks, user, schema, c.Param("id"), func(instance *lib.Instance, _ lib.EvalOptions) error {
+			return schema.AddEffect(instance, effect)
+		})
+		if err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		return c.JSON(http.StatusOK, effectList(instance))
+	})
+
+	instances.POST("/:id/effects/tick", func(c echo.Context) error {
+		user := c.Param("user")
+		_, schema, err := getInstance(db, user, c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		req := EffectTickRequest{Amount: 1}
+		if err := c.Bind(&req); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		var expired []string
+		instance, err := updateInstance(db, hooks, user, schema, c.Param("id"), func(instance *lib.Instance, _ lib.EvalOptions) error {
+			var err error
+			expired, err = lib.TickEffects(instance, req.Unit, req.Amount)
+			return err
+		})
+		if err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		if expired == nil {
+			expired = []string{}
+		}
+		return c.JSON(http.StatusOK, EffectTickResponse{Expired: expired, Effects: effectList(instance)})
+	})
+
+	instances.POST("/:id/effects/:name/remove", func(c echo.Context) error {
+		user := c.Param("user")
+		_, schema, err := getInstance(db, user, c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		instance, err := updateInstance(db, hooks, user, schema, c.Param("id"), func(instance *lib.Instance, _ lib.EvalOptions) error {
+			return lib.RemoveEffect(instance, c.Param("name"))
+		})
+		if errors.Is(err, lib.ErrUnknownEffect) {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, effectList(instance))
+	})
+}
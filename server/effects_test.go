@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestEffectRoutes(t *testing.T) {
+	server := newTestServer(t)
+	db := server.db
+	hooks := NewWebhookDispatcher(db)
+	db.Set(CollectionSchemas, "gm", "dnd", lib.Schema{
+		ID:         "dnd",
+		Properties: map[string]lib.Property{"armor_class": {Formula: "10"}},
+	})
+	db.Set(CollectionInstances, "gm", "cleric", lib.Instance{ID: "cleric", SchemaID: "dnd"})
+
+	registerEffectRoutes(server.router.Group("/:user/instances"), db, hooks)
+
+	rec := server.post("/gm/instances/cleric/effects",
+		`{"name":"shield of faith","bonuses":{"armor_class":[{"value":"2"}]},"duration":"rounds","remaining":2}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := server.post("/gm/instances/cleric/effects", `{"name":"haste","duration":"rounds"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("effect without remaining rounds: got %d", rec.Code)
+	}
+
+	var stored lib.Instance
+	db.Get(CollectionInstances, "gm", "cleric", &stored)
+	var schema lib.Schema
+	db.Get(CollectionSchemas, "gm", "dnd", &schema)
+	if got := schema.Evaluate(&stored).Properties["armor_class"]; got != 12.0 {
+		t.Errorf("armor class with the effect: %v", got)
+	}
+
+	rec = server.post("/gm/instances/cleric/effects/tick", `{"unit":"rounds","amount":2}`)
+	var tick EffectTickResponse
+	json.Unmarshal(rec.Body.Bytes(), &tick)
+	if len(tick.Expired) != 1 || len(tick.Effects) != 0 {
+		t.Errorf("tick: %s", rec.Body.String())
+	}
+	if rec := server.post("/gm/instances/cleric/effects/tick", `{"unit":"minutes"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("ticking minutes: got %d", rec.Code)
+	}
+	if rec := server.post("/gm/instances/cleric/effects/bless/remove", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("removing a missing effect: got %d", rec.Code)
+	}
+}
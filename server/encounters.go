@@ -0,0 +1,334 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Encounters
+//
+// A fight (or any turn-based scene) between instances, possibly of different
+// schemas. Starting it rolls in everyone's initiative, read from the named
+// variable or property of each instance, highest first. Advancing the turn
+// ticks the turn effects of the combatant whose turn ended and, when the
+// order comes back to the top, the round effects of everyone.
+
+const defaultInitiative = "initiative"
+
+type Encounter struct {
+	ID         string       `json:"_id"`
+	Name       string       `json:"name"`
+	Initiative string       `json:"initiative"`              // variable or property holding each combatant's initiative
+	Combatants []Combatant  `json:"combatants"`              // in turn order once started
+	Round      int          `json:"round"`                   // 0 until started
+	Turn       int          `json:"turn"`                    // index of the combatant whose turn it is
+	Pending    []EffectTick `json:"pending_ticks,omitempty"` // from turns whose ticks failed, run with the next turn
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+// Effects of one instance running down by one unit.
+type EffectTick struct {
+	InstanceID string           `json:"instance_id"`
+	Unit       lib.DurationUnit `json:"unit"`
+}
+
+type Combatant struct {
+	InstanceID string  `json:"instance_id"`
+	Name       string  `json:"name"`
+	Initiative float64 `json:"initiative"`
+}
+
+type NewEncounterRequest struct {
+	Name        string   `json:"name"`
+	Initiative  string   `json:"initiative"`
+	InstanceIDs []string `json:"instance_ids"`
+}
+
+type CombatantRequest struct {
+	InstanceID string `json:"instance_id"`
+}
+
+// The encounter after a turn, and the effects that ran out, by instance.
+type TurnResponse struct {
+	Encounter Encounter           `json:"encounter"`
+	Expired   map[string][]string `json:"expired"`
+}
+
+var errNotStarted = errors.New("encounter hasn't started")
+
+// Reads the instance's current initiative, finding it with opts.Lookup.
+func rollInitiative(opts lib.EvalOptions, instanceID, field string) (Combatant, error) {
+	instance, schema, err := opts.Lookup(instanceID)
+	if err != nil {
+		// not wrapped: a missing instance isn't a missing encounter
+		return Combatant{}, fmt.Errorf("instance %s: %v", instanceID, err)
+	}
+	value, _ := schema.EvaluateWith(instance, opts).Lookup(field)
+	initiative, ok := lib.AsNumber(value)
+	if !ok {
+		return Combatant{}, fmt.Errorf("instance %s has no number %q", instanceID, field)
+	}
+	return Combatant{InstanceID: instanceID, Name: instance.Name, Initiative: initiative}, nil
+}
+
+// Highest initiative first, then by name.
+func sortCombatants(combatants []Combatant) {
+	slices.SortStableFunc(combatants, func(a, b Combatant) int {
+		return cmp.Or(cmp.Compare(b.Initiative, a.Initiative), cmp.Compare(a.Name, b.Name), cmp.Compare(a.InstanceID, b.InstanceID))
+	})
+}
+
+// Moves to the next turn. Returns the ticks it owes: the turn effects of the
+// combatant whose turn ended and, when a new round begins, the round effects
+// of everyone.
+func (e *Encounter) advance() ([]EffectTick, error) {
+	if e.Round == 0 {
+		return nil, errNotStarted
+	}
+	if len(e.Combatants) == 0 {
+		return nil, fmt.Errorf("encounter has no combatants")
+	}
+	ticks := []EffectTick{{InstanceID: e.Combatants[e.Turn].InstanceID, Unit: lib.DurationTurns}}
+	e.Turn++
+	if e.Turn < len(e.Combatants) {
+		return ticks, nil
+	}
+	e.Turn = 0
+	e.Round++
+	for _, combatant := range e.Combatants {
+		ticks = append(ticks, EffectTick{InstanceID: combatant.InstanceID, Unit: lib.DurationRounds})
+	}
+	return ticks, nil
+}
+
+// Takes the instance out of the order, keeping the turn with whoever has it
+// (or, if it was the removed combatant's turn, the one after).
+func (e *Encounter) remove(instanceID string) bool {
+	index := slices.IndexFunc(e.Combatants, func(c Combatant) bool { return c.InstanceID == instanceID })
+	if index < 0 {
+		return false
+	}
+	e.Combatants = slices.Delete(e.Combatants, index, index+1)
+	if index < e.Turn {
+		e.Turn--
+	}
+	if e.Turn >= len(e.Combatants) {
+		e.Turn = 0
+	}
+	return true
+}
+
+// Runs the ticks in order, adding the effects that ran out to expired.
+// Instances that were deleted since joining are skipped. On error the ticks
+// from the failed one on are returned.
+func runTicks(db *JsonDB, hooks *WebhookDispatcher, user string, ticks []EffectTick, expired map[string][]string) ([]EffectTick, error) {
+	for i, tick := range ticks {
+		_, schema, err := getInstance(db, user, tick.InstanceID)
+		if err != nil {
+			continue
+		}
+		_, err = updateInstance(db, hooks, user, schema, tick.InstanceID, func(instance *lib.Instance, _ lib.EvalOptions) error {
+			names, err := lib.TickEffects(instance, tick.Unit, 1)
+			if len(names) > 0 {
+				expired[tick.InstanceID] = append(expired[tick.InstanceID], names...)
+			}
+			return err
+		})
+		if err != nil {
+			return ticks[i:], fmt.Errorf("instance %s: %w", tick.InstanceID, err)
+		}
+	}
+	return nil, nil
+}
+
+func encounterError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errNotFound):
+		return httpError(c, http.StatusNotFound, err)
+	case errors.Is(err, errNotStarted):
+		return httpError(c, http.StatusConflict, err)
+	}
+	return httpError(c, http.StatusBadRequest, err)
+}
+
+func registerEncounterRoutes(u *echo.Group, db *JsonDB, hooks *WebhookDispatcher) {
+	encounters := u.Group("/encounters")
+
+	encounters.GET("", func(c echo.Context) error {
+		return listSummaries(c, db, CollectionEncounters)
+	})
+
+	encounters.GET("/:id", func(c echo.Context) error {
+		var encounter Encounter
+		if err := db.Get(CollectionEncounters, c.Param("user"), c.Param("id"), &encounter); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		return c.JSON(http.StatusOK, encounter)
+	})
+
+	encounters.POST("/new", func(c echo.Context) error {
+		user := c.Param("user")
+
+		var req NewEncounterRequest
+		if err := c.Bind(&req); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		encounter := Encounter{
+			ID:         uuid.New().String(),
+			Name:       req.Name,
+			Initiative: cmp.Or(req.Initiative, defaultInitiative),
+			Combatants: []Combatant{},
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		for i, id := range req.InstanceIDs {
+			if slices.Contains(req.InstanceIDs[:i], id) {
+				return httpError(c, http.StatusBadRequest, fmt.Errorf("instance %s is in the encounter twice", id))
+			}
+			combatant, err := rollInitiative(evalOptions(db, user), id, encounter.Initiative)
+			if err != nil {
+				return httpError(c, http.StatusBadRequest, err)
+			}
+			encounter.Combatants = append(encounter.Combatants, combatant)
+		}
+		sortCombatants(encounter.Combatants)
+
+		if err := db.Set(CollectionEncounters, user, encounter.ID, encounter); err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, encounter)
+	})
+
+	encounters.POST("/:id/delete", func(c echo.Context) error {
+		if err := db.Delete(CollectionEncounters, c.Param("user"), c.Param("id")); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		return c.String(http.StatusOK, "encounter deleted")
+	})
+
+	// joins mid-fight in initiative order, without taking the current turn
+	encounters.POST("/:id/combatants", func(c echo.Context) error {
+		user := c.Param("user")
+
+		var req CombatantRequest
+		if err := c.Bind(&req); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		opts := lib.EvalOptions{Lookup: lockedInstanceLookup(db, user)}
+		var encounter Encounter
+		err := Update(db, CollectionEncounters, user, c.Param("id"), func(e *Encounter) error {
+			if slices.ContainsFunc(e.Combatants, func(c Combatant) bool { return c.InstanceID == req.InstanceID }) {
+				return fmt.Errorf("instance %s is already in the encounter", req.InstanceID)
+			}
+			combatant, err := rollInitiative(opts, req.InstanceID, e.Initiative)
+			if err != nil {
+				return err
+			}
+			var current string
+			if e.Turn < len(e.Combatants) {
+				current = e.Combatants[e.Turn].InstanceID
+			}
+			e.Combatants = append(e.Combatants, combatant)
+			sortCombatants(e.Combatants)
+			e.Turn = max(slices.IndexFunc(e.Combatants, func(c Combatant) bool { return c.InstanceID == current }), 0)
+			e.UpdatedAt = time.Now()
+			encounter = *e
+			return nil
+		})
+		if err != nil {
+			return encounterError(c, err)
+		}
+		return c.JSON(http.StatusOK, encounter)
+	})
+
+	encounters.POST("/:id/combatants/:instance/remove", func(c echo.Context) error {
+		var encounter Encounter
+		err := Update(db, CollectionEncounters, c.Param("user"), c.Param("id"), func(e *Encounter) error {
+			if !e.remove(c.Param("instance")) {
+				return fmt.Errorf("instance %s isn't in the encounter", c.Param("instance"))
+			}
+			e.UpdatedAt = time.Now()
+			encounter = *e
+			return nil
+		})
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		return c.JSON(http.StatusOK, encounter)
+	})
+
+	// re-reads everyone's initiative and begins round 1
+	encounters.POST("/:id/start", func(c echo.Context) error {
+		user := c.Param("user")
+
+		opts := lib.EvalOptions{Lookup: lockedInstanceLookup(db, user)}
+		var encounter Encounter
+		err := Update(db, CollectionEncounters, user, c.Param("id"), func(e *Encounter) error {
+			if len(e.Combatants) == 0 {
+				return fmt.Errorf("encounter has no combatants")
+			}
+			for i, combatant := range e.Combatants {
+				rolled, err := rollInitiative(opts, combatant.InstanceID, e.Initiative)
+				if err != nil {
+					return err
+				}
+				e.Combatants[i] = rolled
+			}
+			sortCombatants(e.Combatants)
+			e.Round, e.Turn = 1, 0
+			e.UpdatedAt = time.Now()
+			encounter = *e
+			return nil
+		})
+		if err != nil {
+			return encounterError(c, err)
+		}
+		return c.JSON(http.StatusOK, encounter)
+	})
+
+	// the ticks are taken off the encounter with the turn, and put back for
+	// the next turn if they fail, so none are lost or run twice
+	encounters.POST("/:id/next", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		var encounter Encounter
+		var ticks []EffectTick
+		err := Update(db, CollectionEncounters, user, id, func(e *Encounter) error {
+			owed, err := e.advance()
+			if err != nil {
+				return err
+			}
+			ticks = append(e.Pending, owed...)
+			e.Pending = nil
+			e.UpdatedAt = time.Now()
+			encounter = *e
+			return nil
+		})
+		if err != nil {
+			return encounterError(c, err)
+		}
+
+		expired := make(map[string][]string)
+		if remaining, err := runTicks(db, hooks, user, ticks, expired); err != nil {
+			Update(db, CollectionEncounters, user, id, func(e *Encounter) error {
+				e.Pending = append(remaining, e.Pending...)
+				return nil
+			})
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, TurnResponse{Encounter: encounter, Expired: expired})
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestEncounterRoutes(t *testing.T) {
+	server := newTestServer(t)
+	db := server.db
+	hooks := NewWebhookDispatcher(db)
+	db.Set(CollectionSchemas, "gm", "dnd", lib.Schema{
+		ID:         "dnd",
+		Variables:  map[string]lib.Variable{"dexterity": {Type: lib.TypeNumber}},
+		Properties: map[string]lib.Property{"initiative": {Formula: "dexterity / 2"}},
+	})
+	db.Set(CollectionSchemas, "gm", "monsters", lib.Schema{
+		ID:        "monsters",
+		Variables: map[string]lib.Variable{"initiative": {Type: lib.TypeNumber}},
+	})
+	db.Set(CollectionInstances, "gm", "rogue", lib.Instance{ID: "rogue", Name: "Rogue", SchemaID: "dnd",
+		VariableValues: map[string]any{"dexterity": 18.0},
+		Effects: []lib.Effect{
+			{Name: "hidden", Duration: lib.DurationTurns, Remaining: 1},
+			{Name: "bless", Duration: lib.DurationRounds, Remaining: 1},
+		}})
+	db.Set(CollectionInstances, "gm", "fighter", lib.Instance{ID: "fighter", Name: "Fighter", SchemaID: "dnd",
+		VariableValues: map[string]any{"dexterity": 10.0}})
+	db.Set(CollectionInstances, "gm", "goblin", lib.Instance{ID: "goblin", Name: "Goblin", SchemaID: "monsters",
+		VariableValues: map[string]any{"initiative": 7.0}})
+
+	registerEncounterRoutes(server.router.Group("/:user"), db, hooks)
+	order := func(encounter Encounter) string {
+		names := make([]string, len(encounter.Combatants))
+		for i, combatant := range encounter.Combatants {
+			names[i] = combatant.InstanceID
+		}
+		return strings.Join(names, ",")
+	}
+
+	if rec := server.post("/gm/encounters/new", `{"instance_ids":["fighter","fighter"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("duplicate combatants: got %d", rec.Code)
+	}
+	rec := server.post("/gm/encounters/new", `{"name":"Ambush","instance_ids":["fighter","rogue"]}`)
+	var encounter Encounter
+	json.Unmarshal(rec.Body.Bytes(), &encounter)
+	if rec.Code != http.StatusOK || order(encounter) != "rogue,fighter" {
+		t.Fatalf("new: %d %s", rec.Code, rec.Body.String())
+	}
+	base := "/gm/encounters/" + encounter.ID
+
+	if rec := server.post(base+"/next", ""); rec.Code != http.StatusConflict {
+		t.Errorf("next before start: got %d", rec.Code)
+	}
+	if rec := server.post(base+"/combatants", `{"instance_id":"goblin"}`); rec.Code != http.StatusOK {
+		t.Errorf("adding the goblin: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := server.post(base+"/combatants", `{"instance_id":"goblin"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("adding the goblin twice: got %d", rec.Code)
+	}
+
+	json.Unmarshal(server.post(base+"/start", "").Body.Bytes(), &encounter)
+	if order(encounter) != "rogue,goblin,fighter" || encounter.Round != 1 || encounter.Turn != 0 {
+		t.Fatalf("start: %+v", encounter)
+	}
+
+	var turn TurnResponse
+	json.Unmarshal(server.post(base+"/next", "").Body.Bytes(), &turn)
+	if turn.Encounter.Turn != 1 || len(turn.Expired["rogue"]) != 1 || turn.Expired["rogue"][0] != "hidden" {
+		t.Errorf("after the rogue's turn: %+v", turn)
+	}
+	server.post(base+"/next", "")
+	json.Unmarshal(server.post(base+"/next", "").Body.Bytes(), &turn)
+	if turn.Encounter.Round != 2 || turn.Encounter.Turn != 0 || len(turn.Expired["rogue"]) != 1 {
+		t.Errorf("new round: %+v", turn)
+	}
+
+	var rogue lib.Instance
+	db.Get(CollectionInstances, "gm", "rogue", &rogue)
+	if len(rogue.Effects) != 0 {
+		t.Errorf("rogue's effects: %v", rogue.Effects)
+	}
+
+	json.Unmarshal(server.post(base+"/combatants/rogue/remove", "").Body.Bytes(), &encounter)
+	if order(encounter) != "goblin,fighter" || encounter.Turn != 0 {
+		t.Errorf("after removing the rogue: %+v", encounter)
+	}
+	if rec := server.post("/gm/encounters/nope/next", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown encounter: got %d", rec.Code)
+	}
+}
+
+// Ticks that fail stay on the encounter and run with the next turn.
+func TestEncounterKeepsFailedTicks(t *testing.T) {
+	server := newTestServer(t)
+	db := server.db
+	db.Set(CollectionSchemas, "gm", "dnd", lib.Schema{
+		ID:        "dnd",
+		Variables: map[string]lib.Variable{"mount": {Type: lib.TypeReference}},
+	})
+	// the knight's mount is gone, so the knight can't be saved
+	db.Set(CollectionInstances, "gm", "knight", lib.Instance{ID: "knight", SchemaID: "dnd",
+		VariableValues: map[string]any{"mount": "horse"},
+		Effects:        []lib.Effect{{Name: "bless", Duration: lib.DurationRounds, Remaining: 2}}})
+	db.Set(CollectionEncounters, "gm", "joust", Encounter{ID: "joust", Round: 1,
+		Combatants: []Combatant{{InstanceID: "knight"}}})
+	registerEncounterRoutes(server.router.Group("/:user"), db, NewWebhookDispatcher(db))
+
+	if rec := server.post("/gm/encounters/joust/next", ""); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("ticking the knight: got %d %s", rec.Code, rec.Body.String())
+	}
+	var joust Encounter
+	db.Get(CollectionEncounters, "gm", "joust", &joust)
+	if joust.Round != 2 || len(joust.Pending) != 2 {
+		t.Fatalf("after the failed ticks: %+v", joust)
+	}
+
+	db.Set(CollectionInstances, "gm", "horse", lib.Instance{ID: "horse", SchemaID: "dnd"})
+	var turn TurnResponse
+	json.Unmarshal(server.post("/gm/encounters/joust/next", "").Body.Bytes(), &turn)
+	if turn.Encounter.Round != 3 || len(turn.Expired["knight"]) != 1 || len(turn.Encounter.Pending) != 0 {
+		t.Errorf("next turn: %+v", turn)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Applying schema events (see lib.Event) to instances, and the history of
+// what they changed.
+
+type HistoryEntry struct {
+	ID         string                     `json:"_id"`
+	InstanceID string                     `json:"instance_id"`
+	SchemaID   string                     `json:"schema_id"`
+	Event      string                     `json:"event"`
+	Changes    map[string]lib.ValueChange `json:"changes"`
+	Trackers   []string                   `json:"trackers,omitempty"`
+	Expired    []string                   `json:"expired_effects,omitempty"`
+	CreatedAt  time.Time                  `json:"created_at"`
+}
+
+// Applies the event and records it. The history entry is written under the
+// same lock as the instance; on error nothing is saved or recorded.
+func applyEvent(db *JsonDB, hooks *WebhookDispatcher, user string, schema *lib.Schema, id, name string) (*HistoryEntry, error) {
+	var entry *HistoryEntry
+	_, err := updateInstance(db, hooks, user, schema, id, func(instance *lib.Instance, opts lib.EvalOptions) error {
+		result, err := schema.ApplyEvent(instance, name, opts)
+		if err != nil {
+			return err
+		}
+
+		entry = &HistoryEntry{
+			ID:         uuid.New().String(),
+			InstanceID: instance.ID,
+			SchemaID:   instance.SchemaID,
+			Event:      result.Event,
+			Changes:    result.Changes,
+			Trackers:   result.Trackers,
+			Expired:    result.Expired,
+			CreatedAt:  time.Now(),
+		}
+		_, err = db.write(CollectionHistory, user, entry.ID, entry)
+		return err
+	})
+	if err != nil {
+		if entry != nil {
+			// recorded, but the instance itself failed to save
+			db.Delete(CollectionHistory, user, entry.ID)
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Newest first.
+func instanceHistory(db *JsonDB, user, id string) ([]HistoryEntry, error) {
+	ids, err := db.Lookup(CollectionHistory, IndexHistoryByInstance, user, id)
+	if err != nil {
+		return nil, err
+	}
+	found, err := GetMany[HistoryEntry](db, CollectionHistory, user, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]HistoryEntry, 0, len(found))
+	for _, entry := range found {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b HistoryEntry) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return entries, nil
+}
+
+func registerEventRoutes(instances *echo.Group, db *JsonDB, hooks *WebhookDispatcher) {
+	instances.POST("/:id/events/:name", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+		_, schema, err := getInstance(db, user, id)
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		entry, err := applyEvent(db, hooks, user, schema, id, c.Param("name"))
+		if err != nil {
+			if errors.Is(err, lib.ErrUnknownEvent) {
+				return httpError(c, http.StatusNotFound, err)
+			}
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		return c.JSON(http.StatusOK, entry)
+	})
+
+	instances.GET("/:id/history", func(c echo.Context) error {
+		entries, err := instanceHistory(db, c.Param("user"), c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, entries)
+	})
+}
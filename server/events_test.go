@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestApplyEventRecordsHistory(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	createIndexes(db)
+	hooks := NewWebhookDispatcher(db)
+
+	schema := &lib.Schema{
+		ID:         "dnd",
+		Variables:  map[string]lib.Variable{"hp": {Type: lib.TypeNumber}},
+		Properties: map[string]lib.Property{"max_hp": {Formula: "12"}},
+		Events: map[string]lib.Event{
+			"long rest": {Updates: map[string]string{"hp": "max_hp"}},
+			"broken":    {Updates: map[string]string{"hp": "nope"}},
+		},
+	}
+	db.Set(CollectionInstances, "gm", "hero", lib.Instance{
+		ID: "hero", SchemaID: "dnd", VariableValues: map[string]any{"hp": 2.0},
+	})
+
+	for range 2 {
+		if _, err := applyEvent(db, hooks, "gm", schema, "hero", "long rest"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := applyEvent(db, hooks, "gm", schema, "hero", "broken"); err == nil {
+		t.Error("expected the broken event to fail")
+	}
+
+	var hero lib.Instance
+	db.Get(CollectionInstances, "gm", "hero", &hero)
+	if hp, _ := hero.GetNumber("hp"); hp != 12 {
+		t.Errorf("hp is %v", hp)
+	}
+
+	history, err := instanceHistory(db, "gm", "hero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(history))
+	}
+	// the second rest changed nothing; the first healed
+	oldest := history[1]
+	if oldest.Event != "long rest" || oldest.Changes["hp"].Before != 2.0 || oldest.Changes["hp"].After != 12.0 {
+		t.Errorf("unexpected first entry %+v", oldest)
+	}
+	if len(history[0].Changes) != 0 {
+		t.Errorf("unexpected second entry %+v", history[0])
+	}
+}
+
+func TestApplyEventUnrecorded(t *testing.T) {
+	dir := t.TempDir()
+	db := NewJsonDB(dir)
+	schema := &lib.Schema{
+		ID:        "dnd",
+		Variables: map[string]lib.Variable{"hp": {Type: lib.TypeNumber}},
+		Events:    map[string]lib.Event{"long rest": {Updates: map[string]string{"hp": "12"}}},
+	}
+	db.Set(CollectionInstances, "gm", "hero", lib.Instance{
+		ID: "hero", SchemaID: "dnd", VariableValues: map[string]any{"hp": 2.0},
+	})
+	// the history can't be written
+	if err := os.WriteFile(filepath.Join(dir, CollectionHistory), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := applyEvent(db, NewWebhookDispatcher(db), "gm", schema, "hero", "long rest"); err == nil {
+		t.Fatal("expected the event to fail")
+	}
+	var hero lib.Instance
+	db.Get(CollectionInstances, "gm", "hero", &hero)
+	if hp, _ := hero.GetNumber("hp"); hp != 2 {
+		t.Errorf("event applied without being recorded: hp is %v", hp)
+	}
+}
+
+func TestEventRouteWithReferences(t *testing.T) {
+	server := newTestServer(t)
+	db := server.db
+	createIndexes(db)
+	db.Set(CollectionSchemas, "a", "horse", lib.Schema{
+		ID:        "horse",
+		Variables: map[string]lib.Variable{"speed": {Type: lib.TypeNumber}},
+	})
+	db.Set(CollectionSchemas, "a", "rider", lib.Schema{
+		ID: "rider",
+		Variables: map[string]lib.Variable{
+			"mount":    {Type: lib.TypeReference, Schema: "horse"},
+			"distance": {Type: lib.TypeNumber, Default: 0.0},
+		},
+		Events: map[string]lib.Event{"ride": {Updates: map[string]string{"distance": "distance + mount.speed"}}},
+	})
+	db.Set(CollectionInstances, "a", "star", lib.Instance{ID: "star", SchemaID: "horse",
+		VariableValues: map[string]any{"speed": 40.0}})
+	db.Set(CollectionInstances, "a", "jo", lib.Instance{ID: "jo", SchemaID: "rider",
+		VariableValues: map[string]any{"mount": "star"}})
+	registerEventRoutes(server.router.Group("/:user/instances"), db, NewWebhookDispatcher(db))
+
+	done := make(chan int)
+	go func() { done <- server.post("/a/instances/jo/events/ride", "").Code }()
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Fatalf("ride: got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("applying an event to an instance with a reference hung")
+	}
+
+	var jo lib.Instance
+	db.Get(CollectionInstances, "a", "jo", &jo)
+	if distance, _ := jo.GetNumber("distance"); distance != 40 {
+		t.Errorf("distance is %v", distance)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Adding and removing an instance's features, checked against the schema's
+// requirements, exclusions and groups (see lib.Schema.CheckAddFeature).
+
+func featureError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, lib.ErrUnknownFeature):
+		return httpError(c, http.StatusNotFound, err)
+	case errors.Is(err, lib.ErrFeatureRule):
+		return httpError(c, http.StatusConflict, err)
+	}
+	return httpError(c, http.StatusBadRequest, err)
+}
+
+func registerFeatureRoutes(instances *echo.Group, db *JsonDB, hooks *WebhookDispatcher) {
+	actions := map[string]func(*lib.Instance, string, *lib.Schema, lib.EvalOptions) error{
+		"":        (*lib.Instance).AddFeature,
+		"/remove": (*lib.Instance).RemoveFeature,
+	}
+	for action, apply := range actions {
+		instances.POST("/:id/features/:name"+action, func(c echo.Context) error {
+			user := c.Param("user")
+			_, schema, err := getInstance(db, user, c.Param("id"))
+			if err != nil {
+				return httpError(c, http.StatusNotFound, err)
+			}
+
+			instance, err := updateInstance(db, hooks, user, schema, c.Param("id"), func(instance *lib.Instance, opts lib.EvalOptions) error {
+				return apply(instance, c.Param("name"), schema, opts)
+			})
+			if err != nil {
+				return featureError(c, err)
+			}
+			return c.JSON(http.StatusOK, instance)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestFeatureRoutes(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	hooks := NewWebhookDispatcher(db)
+	db.Set(CollectionSchemas, "gm", "dnd", lib.Schema{
+		ID: "dnd",
+		Features: map[string]lib.Feature{
+			"power_attack": {},
+			"cleave":       {Requires: []string{"power_attack"}},
+		},
+	})
+	db.Set(CollectionInstances, "gm", "fighter", lib.Instance{ID: "fighter", SchemaID: "dnd"})
+
+	router := echo.New()
+	registerFeatureRoutes(router.Group("/:user/instances"), db, hooks)
+	post := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec
+	}
+
+	if rec := post("/gm/instances/fighter/features/cleave"); rec.Code != http.StatusConflict {
+		t.Errorf("missing requirement: got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := post("/gm/instances/fighter/features/whirlwind"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown feature: got %d", rec.Code)
+	}
+	if rec := post("/gm/instances/nobody/features/cleave"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown instance: got %d", rec.Code)
+	}
+
+	post("/gm/instances/fighter/features/power_attack")
+	rec := post("/gm/instances/fighter/features/cleave")
+	var instance lib.Instance
+	json.Unmarshal(rec.Body.Bytes(), &instance)
+	if rec.Code != http.StatusOK || !slices.Equal(instance.ActiveFeatures, []string{"cleave", "power_attack"}) {
+		t.Errorf("add: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := post("/gm/instances/fighter/features/power_attack/remove"); rec.Code != http.StatusConflict {
+		t.Errorf("removing a required feature: got %d", rec.Code)
+	}
+	post("/gm/instances/fighter/features/cleave/remove")
+
+	var stored lib.Instance
+	db.Get(CollectionInstances, "gm", "fighter", &stored)
+	if !slices.Equal(stored.ActiveFeatures, []string{"power_attack"}) {
+		t.Errorf("stored: %v", stored.ActiveFeatures)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Content negotiation for schema endpoints (see lib.SchemaFormat).
+//
+// Requests may send a schema as JSON, YAML or TOML, picked by Content-Type.
+// Responses use ?format=yaml|toml|json when given, otherwise the first
+// matching type in the Accept header, otherwise JSON.
+
+// Largest schema document accepted in YAML or TOML.
+const maxSchemaSize = 4 << 20
+
+func bindSchema(c echo.Context, dest *lib.Schema) error {
+	format, ok := lib.SchemaFormatForMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if !ok || format == lib.SchemaJSON {
+		return c.Bind(dest)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request().Body, maxSchemaSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxSchemaSize {
+		return fmt.Errorf("schema larger than %d bytes", maxSchemaSize)
+	}
+
+	schema, err := lib.UnmarshalSchema(data, format)
+	if err != nil {
+		return err
+	}
+	*dest = *schema
+	return nil
+}
+
+func responseFormat(c echo.Context) (lib.SchemaFormat, error) {
+	if name := c.QueryParam("format"); name != "" {
+		return lib.ParseSchemaFormat(name)
+	}
+
+	for _, accepted := range strings.Split(c.Request().Header.Get(echo.HeaderAccept), ",") {
+		if format, ok := lib.SchemaFormatForMediaType(strings.TrimSpace(accepted)); ok {
+			return format, nil
+		}
+	}
+	return lib.SchemaJSON, nil
+}
+
+func respondSchema(c echo.Context, code int, schema *lib.Schema) error {
+	format, err := responseFormat(c)
+	if err != nil {
+		return httpError(c, http.StatusBadRequest, err)
+	}
+	if format == lib.SchemaJSON {
+		return c.JSON(code, schema)
+	}
+
+	data, err := lib.MarshalSchema(schema, format)
+	if err != nil {
+		return httpError(c, http.StatusInternalServerError, err)
+	}
+	return c.Blob(code, format.MediaType()+"; charset=utf-8", data)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Genealogy
+//
+// Family tree queries over the user's instances, following reference
+// variables marked as parent or child links (see lib.FamilyGraph). Results
+// are node/edge graphs for tree rendering.
+
+type CyclesResponse struct {
+	Cycles [][]string `json:"cycles"`
+}
+
+func loadFamilyGraph(db *JsonDB, user string) (*lib.FamilyGraph, error) {
+	instances, err := GetAll[lib.Instance](db, CollectionInstances, user)
+	if err != nil {
+		return nil, err
+	}
+	schemas, err := GetAll[lib.Schema](db, CollectionSchemas, user)
+	if err != nil {
+		return nil, err
+	}
+	return lib.NewFamilyGraph(slices.Collect(maps.Values(instances)), schemas), nil
+}
+
+// The depth query parameter; all generations when it's missing.
+func depthParam(c echo.Context) (int, error) {
+	value := c.QueryParam("depth")
+	if value == "" {
+		return 0, nil
+	}
+	depth, err := strconv.Atoi(value)
+	if err != nil || depth < 1 {
+		return 0, fmt.Errorf("depth must be a positive number")
+	}
+	return depth, nil
+}
+
+func registerGenealogyRoutes(u *echo.Group, instances *echo.Group, db *JsonDB) {
+	lineage := func(walk func(g *lib.FamilyGraph, id string, depth int) lib.Graph) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			depth, err := depthParam(c)
+			if err != nil {
+				return httpError(c, http.StatusBadRequest, err)
+			}
+			graph, err := loadFamilyGraph(db, c.Param("user"))
+			if err != nil {
+				return httpError(c, http.StatusInternalServerError, err)
+			}
+			if !graph.Has(c.Param("id")) {
+				return httpError(c, http.StatusNotFound, fmt.Errorf("instance %s not found", c.Param("id")))
+			}
+			return c.JSON(http.StatusOK, walk(graph, c.Param("id"), depth))
+		}
+	}
+	instances.GET("/:id/ancestors", lineage((*lib.FamilyGraph).Ancestors))
+	instances.GET("/:id/descendants", lineage((*lib.FamilyGraph).Descendants))
+
+	instances.GET("/:id/path/:other", func(c echo.Context) error {
+		graph, err := loadFamilyGraph(db, c.Param("user"))
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		for _, id := range []string{c.Param("id"), c.Param("other")} {
+			if !graph.Has(id) {
+				return httpError(c, http.StatusNotFound, fmt.Errorf("instance %s not found", id))
+			}
+		}
+		path, ok := graph.Path(c.Param("id"), c.Param("other"))
+		if !ok {
+			return httpError(c, http.StatusNotFound, fmt.Errorf("instances %s and %s aren't related", c.Param("id"), c.Param("other")))
+		}
+		return c.JSON(http.StatusOK, path)
+	})
+
+	// someone being their own ancestor is almost always a data entry mistake
+	u.GET("/genealogy/cycles", func(c echo.Context) error {
+		graph, err := loadFamilyGraph(db, c.Param("user"))
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, CyclesResponse{Cycles: graph.Cycles()})
+	})
+}
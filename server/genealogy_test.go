@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestGenealogyRoutes(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	db.Set(CollectionSchemas, "fam", "person", lib.Schema{
+		ID: "person",
+		Variables: map[string]lib.Variable{
+			"mother": {Type: lib.TypeReference, Schema: "person", Link: lib.LinkParent},
+			"father": {Type: lib.TypeReference, Schema: "person", Link: lib.LinkParent},
+			"spouse": {Type: lib.TypeReference, Schema: "person"},
+		},
+	})
+	people := map[string]map[string]any{
+		"ada":   nil,
+		"ben":   {"mother": "ada"},
+		"cara":  {"spouse": "ben"},
+		"dan":   {"father": "ben", "mother": "cara"},
+		"eve":   {"mother": "dan"},
+		"loner": nil,
+	}
+	for id, values := range people {
+		db.Set(CollectionInstances, "fam", id, lib.Instance{ID: id, Name: id, SchemaID: "person", VariableValues: values})
+	}
+
+	router := echo.New()
+	u := router.Group("/:user")
+	registerGenealogyRoutes(u, u.Group("/instances"), db)
+	get := func(path string, out any) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if out != nil {
+			json.Unmarshal(rec.Body.Bytes(), out)
+		}
+		return rec
+	}
+	ids := func(graph lib.Graph) []string {
+		var ids []string
+		for _, node := range graph.Nodes {
+			ids = append(ids, node.ID)
+		}
+		return ids
+	}
+
+	var graph lib.Graph
+	get("/fam/instances/eve/ancestors", &graph)
+	if !slices.Equal(ids(graph), []string{"eve", "dan", "ben", "cara", "ada"}) || len(graph.Edges) != 4 {
+		t.Errorf("ancestors: %v %v", ids(graph), graph.Edges)
+	}
+	get("/fam/instances/eve/ancestors?depth=2", &graph)
+	if !slices.Equal(ids(graph), []string{"eve", "dan", "ben", "cara"}) {
+		t.Errorf("ancestors to depth 2: %v", ids(graph))
+	}
+	get("/fam/instances/ada/descendants?depth=1", &graph)
+	if !slices.Equal(ids(graph), []string{"ada", "ben"}) || graph.Edges[0] != (lib.GraphEdge{From: "ada", To: "ben", Kind: "parent", Variable: "mother"}) {
+		t.Errorf("descendants: %v %v", ids(graph), graph.Edges)
+	}
+	if rec := get("/fam/instances/ada/descendants?depth=0", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("depth 0: got %d", rec.Code)
+	}
+	if rec := get("/fam/instances/nobody/ancestors", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown instance: got %d", rec.Code)
+	}
+
+	get("/fam/instances/cara/path/ada", &graph)
+	if !slices.Equal(ids(graph), []string{"cara", "ben", "ada"}) || graph.Edges[0].Variable != "spouse" {
+		t.Errorf("path: %v %v", ids(graph), graph.Edges)
+	}
+	if rec := get("/fam/instances/cara/path/loner", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("path to an unrelated instance: got %d", rec.Code)
+	}
+
+	var cycles CyclesResponse
+	get("/fam/genealogy/cycles", &cycles)
+	if len(cycles.Cycles) != 0 {
+		t.Errorf("cycles: %v", cycles.Cycles)
+	}
+	db.Set(CollectionInstances, "fam", "ada", lib.Instance{ID: "ada", Name: "ada", SchemaID: "person", VariableValues: map[string]any{"mother": "eve"}})
+	get("/fam/genealogy/cycles", &cycles)
+	if len(cycles.Cycles) != 1 || !slices.Equal(cycles.Cycles[0], []string{"ada", "ben", "dan", "eve"}) {
+		t.Errorf("cycles: %v", cycles.Cycles)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Groups of instances with shared variables and properties computed over
+// their members (see lib.Group).
+
+type NewGroupRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Members     []string `json:"members"`
+}
+
+type GroupMemberRequest struct {
+	InstanceID string `json:"instance_id"`
+}
+
+type GroupMember struct {
+	ID       string `json:"_id"`
+	Name     string `json:"name"`
+	SchemaID string `json:"schema_id"`
+}
+
+type GroupEvaluation struct {
+	Variables  map[string]any    `json:"variables"`
+	Properties map[string]any    `json:"properties"`
+	Errors     map[string]string `json:"errors,omitempty"`
+	Members    []GroupMember     `json:"members"`
+	Missing    []string          `json:"missing,omitempty"` // members that were deleted
+}
+
+func checkMembers(db *JsonDB, user string, ids []string) error {
+	for i, id := range ids {
+		if slices.Contains(ids[:i], id) {
+			return fmt.Errorf("instance %s is listed twice", id)
+		}
+		var instance lib.Instance
+		if err := db.Get(CollectionInstances, user, id, &instance); err != nil {
+			return fmt.Errorf("instance %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func evaluateGroup(db *JsonDB, user string, group *lib.Group) *GroupEvaluation {
+	response := &GroupEvaluation{Members: []GroupMember{}}
+	var evaluations []*lib.Evaluation
+	for _, id := range group.Members {
+		instance, schema, err := getInstance(db, user, id)
+		if err != nil {
+			response.Missing = append(response.Missing, id)
+			continue
+		}
+		evaluations = append(evaluations, schema.EvaluateWith(instance, evalOptions(db, user)))
+		response.Members = append(response.Members, GroupMember{ID: id, Name: instance.Name, SchemaID: instance.SchemaID})
+	}
+
+	evaluation := group.Evaluate(evaluations)
+	response.Variables = evaluation.Variables
+	response.Properties = evaluation.Properties
+	response.Errors = evaluation.Errors
+	return response
+}
+
+func registerGroupRoutes(u *echo.Group, db *JsonDB) {
+	groups := u.Group("/groups")
+
+	groups.GET("", func(c echo.Context) error {
+		return listSummaries(c, db, CollectionGroups)
+	})
+
+	groups.GET("/:id", func(c echo.Context) error {
+		var group lib.Group
+		if err := db.Get(CollectionGroups, c.Param("user"), c.Param("id"), &group); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		return c.JSON(http.StatusOK, group)
+	})
+
+	groups.POST("/new", func(c echo.Context) error {
+		user := c.Param("user")
+
+		var req NewGroupRequest
+		if err := c.Bind(&req); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		if req.Members == nil {
+			req.Members = []string{}
+		}
+		if err := checkMembers(db, user, req.Members); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		group := lib.Group{
+			ID:          uuid.New().String(),
+			Name:        req.Name,
+			Description: req.Description,
+			Members:     req.Members,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
+		if err := db.Set(CollectionGroups, user, group.ID, group); err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, group)
+	})
+
+	// replaces the whole group, e.g. to change its variables or properties
+	groups.POST("/save", func(c echo.Context) error {
+		user := c.Param("user")
+
+		var group lib.Group
+		if err := c.Bind(&group); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		if group.ID == "" {
+			return httpError(c, http.StatusBadRequest, fmt.Errorf("group needs an _id"))
+		}
+		if err := group.Validate(); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		if group.Members == nil {
+			group.Members = []string{}
+		}
+		if err := checkMembers(db, user, group.Members); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		group.UpdatedAt = time.Now()
+		if err := db.Set(CollectionGroups, user, group.ID, group); err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, group)
+	})
+
+	groups.POST("/:id/delete", func(c echo.Context) error {
+		if err := db.Delete(CollectionGroups, c.Param("user"), c.Param("id")); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		return c.String(http.StatusOK, "group deleted")
+	})
+
+	groups.POST("/:id/members", func(c echo.Context) error {
+		user := c.Param("user")
+
+		var req GroupMemberRequest
+		if err := c.Bind(&req); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		if err := checkMembers(db, user, []string{req.InstanceID}); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		var group lib.Group
+		err := Update(db, CollectionGroups, user, c.Param("id"), func(g *lib.Group) error {
+			if !slices.Contains(g.Members, req.InstanceID) {
+				g.Members = append(g.Members, req.InstanceID)
+			}
+			g.UpdatedAt = time.Now()
+			group = *g
+			return nil
+		})
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		return c.JSON(http.StatusOK, group)
+	})
+
+	groups.POST("/:id/members/:instance/remove", func(c echo.Context) error {
+		var group lib.Group
+		err := Update(db, CollectionGroups, c.Param("user"), c.Param("id"), func(g *lib.Group) error {
+			index := slices.Index(g.Members, c.Param("instance"))
+			if index < 0 {
+				return fmt.Errorf("instance %s isn't in the group", c.Param("instance"))
+			}
+			g.Members = slices.Delete(g.Members, index, index+1)
+			g.UpdatedAt = time.Now()
+			group = *g
+			return nil
+		})
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		return c.JSON(http.StatusOK, group)
+	})
+
+	// sets shared variables: {"gold": 120}
+	groups.POST("/:id/variables", func(c echo.Context) error {
+		user := c.Param("user")
+
+		// decoded directly: Bind would mix the path parameters into the map
+		var values map[string]any
+		if err := json.NewDecoder(c.Request().Body).Decode(&values); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		var group lib.Group
+		err := Update(db, CollectionGroups, user, c.Param("id"), func(g *lib.Group) error {
+			for _, name := range slices.Sorted(maps.Keys(values)) {
+				if err := g.SetVariable(name, values[name]); err != nil {
+					return err
+				}
+			}
+			g.UpdatedAt = time.Now()
+			group = *g
+			return nil
+		})
+		if errors.Is(err, errNotFound) {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		if err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		return c.JSON(http.StatusOK, group)
+	})
+
+	groups.GET("/:id/evaluated", func(c echo.Context) error {
+		user := c.Param("user")
+
+		var group lib.Group
+		if err := db.Get(CollectionGroups, user, c.Param("id"), &group); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+		return c.JSON(http.StatusOK, evaluateGroup(db, user, &group))
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestGroupRoutes(t *testing.T) {
+	server := newTestServer(t)
+	db := server.db
+	db.Set(CollectionSchemas, "gm", "dnd", lib.Schema{
+		ID:        "dnd",
+		Variables: map[string]lib.Variable{"level": {Type: lib.TypeNumber, Default: 1.0}},
+	})
+	db.Set(CollectionSchemas, "gm", "pets", lib.Schema{
+		ID:         "pets",
+		Properties: map[string]lib.Property{"level": {Formula: "2"}},
+	})
+	db.Set(CollectionInstances, "gm", "wizard", lib.Instance{ID: "wizard", SchemaID: "dnd",
+		VariableValues: map[string]any{"level": 5.0}})
+	db.Set(CollectionInstances, "gm", "owl", lib.Instance{ID: "owl", SchemaID: "pets"})
+
+	registerGroupRoutes(server.router.Group("/:user"), db)
+
+	if rec := server.post("/gm/groups/new", `{"name":"Party","members":["wizard","ghost"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown member: got %d", rec.Code)
+	}
+	rec := server.post("/gm/groups/new", `{"name":"Party","members":["wizard"]}`)
+	var group lib.Group
+	json.Unmarshal(rec.Body.Bytes(), &group)
+	base := "/gm/groups/" + group.ID
+
+	group.Variables = map[string]lib.Variable{"gold": {Type: lib.TypeNumber}}
+	group.Properties = map[string]lib.Property{
+		"total_level": {Formula: "sum(members.level)"},
+		"share":       {Formula: "gold / len(members.level)"},
+	}
+	body, _ := json.Marshal(group)
+	if rec := server.post("/gm/groups/save", string(body)); rec.Code != http.StatusOK {
+		t.Fatalf("save: %d %s", rec.Code, rec.Body.String())
+	}
+	server.post(base+"/members", `{"instance_id":"owl"}`)
+	if rec := server.post(base+"/variables", `{"gold":"70"}`); rec.Code != http.StatusOK {
+		t.Errorf("set gold: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := server.post(base+"/variables", `{"silver":3}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown variable: got %d", rec.Code)
+	}
+	if rec := server.post("/gm/groups/nope/variables", `{"gold":1}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown group: got %d", rec.Code)
+	}
+
+	rec = server.get(base + "/evaluated")
+	var evaluation GroupEvaluation
+	json.Unmarshal(rec.Body.Bytes(), &evaluation)
+	if evaluation.Properties["total_level"] != 7.0 || evaluation.Properties["share"] != 35.0 || len(evaluation.Members) != 2 {
+		t.Errorf("evaluated: %s", rec.Body.String())
+	}
+
+	server.post(base+"/members/owl/remove", "")
+	if rec := server.post(base+"/members/owl/remove", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("removing a non-member: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// An empty database and a router for route tests. Register the routes under
+// test on router, then call them with post and get.
+type testServer struct {
+	db     *JsonDB
+	router *echo.Echo
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return &testServer{db: NewJsonDB(t.TempDir()), router: echo.New()}
+}
+
+// Sends body as JSON.
+func (s *testServer) post(path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *testServer) get(path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"maps"
+	"slices"
+)
+
+// Attachments //
+
+// An attachment variable holds the SHA-256 (lowercase hex) of a file kept in
+// the server's blob store: a portrait, a scanned letter. Files with the same
+// content share one hash, so the same photo on two instances is stored once.
+// In formulas an attachment is just its hash.
+
+func IsAttachmentHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, c := range hash {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// The hashes of the instance's attachments, by variable name and then in the
+// order stored. The variables of its active modules count too.
+func (s *Schema) Attachments(instance *Instance) []string {
+	variables := s.GetAllVariables(instance.ActiveModules)
+	var hashes []string
+	for _, name := range slices.Sorted(maps.Keys(variables)) {
+		variable := variables[name]
+		if variable.Type == TypeAttachment || variable.Type == TypeArray && variable.Items != nil && variable.Items.Type == TypeAttachment {
+			hashes = append(hashes, referenceIDs(instance.VariableValues[name])...)
+		}
+	}
+	return hashes
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAttachments(t *testing.T) {
+	portrait := strings.Repeat("ab", 32)
+	letter := strings.Repeat("0f", 32)
+	schema := &Schema{Variables: map[string]Variable{
+		"portrait": {Type: TypeAttachment},
+		"letters":  {Type: TypeArray, Items: &Variable{Type: TypeAttachment}},
+		"name":     {Type: TypeString},
+	}}
+
+	variable := schema.Variables["portrait"]
+	if value, err := variable.Coerce(" " + strings.ToUpper(portrait) + " "); err != nil || value != portrait {
+		t.Errorf("coerce: %v %v", value, err)
+	}
+	if value, err := variable.Coerce(""); err != nil || value != nil {
+		t.Errorf("empty attachment: %v %v", value, err)
+	}
+	for _, bad := range []any{"portrait.png", strings.Repeat("g", 64), 12.0} {
+		if _, err := variable.Coerce(bad); err == nil {
+			t.Errorf("%v accepted as an attachment", bad)
+		}
+	}
+
+	instance := &Instance{VariableValues: map[string]any{
+		"portrait": portrait,
+		"letters":  []any{letter, portrait},
+		"name":     portrait,
+	}}
+	if got := schema.Attachments(instance); !slices.Equal(got, []string{letter, portrait, portrait}) {
+		t.Errorf("attachments: %v", got)
+	}
+}
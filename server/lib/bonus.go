@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Bonuses //
+
+// A number added to a property, like "+1 enhancement" or "-2 circumstance".
+// Bonuses of the same type don't stack: only the biggest bonus and the
+// biggest penalty of each type count, unless they're stackable. Untyped
+// bonuses always stack.
+type Bonus struct {
+	Type      string `json:"type,omitempty"`
+	Value     string `json:"value"` // formula
+	Stackable bool   `json:"stackable,omitempty"`
+}
+
+// One part of a property's value. The first is the property's own formula
+// (source "base"); bonuses that lost to a bigger one of their type aren't
+// applied.
+type Contribution struct {
+	Source  string  `json:"source"` // e.g. "module plate", "feature rage", "instance", "effect bless"
+	Type    string  `json:"type,omitempty"`
+	Value   float64 `json:"value"`
+	Applied bool    `json:"applied"`
+}
+
+type sourcedBonus struct {
+	Bonus
+	Source string
+}
+
+// Bonuses from the active modules and features, the instance itself and
+// its effects that haven't run out, by property, in that order.
+func (s *Schema) activeBonuses(features, modules []string, instance *Instance) map[string][]sourcedBonus {
+	bonuses := make(map[string][]sourcedBonus)
+	add := func(source string, from map[string][]Bonus) {
+		for property, list := range from {
+			for _, bonus := range list {
+				bonuses[property] = append(bonuses[property], sourcedBonus{bonus, source})
+			}
+		}
+	}
+
+	for _, name := range s.moduleOrder(modules) {
+		add("module "+name, s.Modules[name].Bonuses)
+	}
+	for _, name := range features {
+		add("feature "+name, s.Features[name].Bonuses)
+	}
+	add("instance", instance.Bonuses)
+	for _, effect := range instance.Effects {
+		if effect.active() {
+			add("effect "+effect.Name, effect.Bonuses)
+		}
+	}
+	return bonuses
+}
+
+// Property order must also account for what the bonuses use, so they're
+// appended to a copy of the formulas for sorting only.
+func withBonusDependencies(properties map[string]Property, bonuses map[string][]sourcedBonus) map[string]Property {
+	if len(bonuses) == 0 {
+		return properties
+	}
+	ordering := maps.Clone(properties)
+	for name, list := range bonuses {
+		property, ok := ordering[name]
+		if !ok {
+			continue
+		}
+		for _, bonus := range list {
+			property.Formula = fmt.Sprintf("(%s) + (%s)", property.Formula, bonus.Value)
+		}
+		ordering[name] = property
+	}
+	return ordering
+}
+
+// Adds the bonuses that count to the base value and explains the total.
+func stackBonuses(base any, bonuses []sourcedBonus, env map[string]any) (float64, []Contribution, error) {
+	total, ok := AsNumber(base)
+	if !ok {
+		return 0, nil, fmt.Errorf("bonuses need a number, got %v", base)
+	}
+	breakdown := []Contribution{{Source: "base", Value: total, Applied: true}}
+
+	// index of the bonus and penalty that count so far, per type
+	best := make(map[string]int)
+	worst := make(map[string]int)
+	for _, bonus := range bonuses {
+		result, err := EvaluateFormula(bonus.Value, env)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s bonus: %w", bonus.Source, err)
+		}
+		value, ok := AsNumber(result)
+		if !ok {
+			return 0, nil, fmt.Errorf("%s bonus: expected a number, got %v", bonus.Source, result)
+		}
+
+		contribution := Contribution{Source: bonus.Source, Type: bonus.Type, Value: value, Applied: true}
+		index := len(breakdown)
+		breakdown = append(breakdown, contribution)
+		if bonus.Type == "" || bonus.Stackable {
+			continue
+		}
+
+		winners := best
+		better := func(a, b float64) bool { return a > b }
+		if value < 0 {
+			winners = worst
+			better = func(a, b float64) bool { return a < b }
+		}
+		previous, seen := winners[bonus.Type]
+		switch {
+		case !seen:
+			winners[bonus.Type] = index
+		case better(value, breakdown[previous].Value):
+			breakdown[previous].Applied = false
+			winners[bonus.Type] = index
+		default:
+			breakdown[index].Applied = false
+		}
+	}
+
+	for _, contribution := range breakdown[1:] {
+		if contribution.Applied {
+			total += contribution.Value
+		}
+	}
+	return total, breakdown, nil
+}
+
+// Bonuses must target properties and use names that exist. Properties and
+// variables of any module count, active or not.
+func (s *Schema) checkBonuses(bonuses map[string][]Bonus) error {
+	variables, properties := s.declaredFields()
+	known := func(name string) bool {
+		_, isVariable := variables[name]
+		_, isProperty := properties[name]
+		return isVariable || isProperty
+	}
+
+	for _, property := range slices.Sorted(maps.Keys(bonuses)) {
+		if _, ok := properties[property]; !ok {
+			return fmt.Errorf("bonus to unknown property %q", property)
+		}
+		for _, bonus := range bonuses[property] {
+			if err := checkFormulaNames(bonus.Value, known); err != nil {
+				return fmt.Errorf("bonus to %q: %w", property, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Checks the instance's own bonuses like those of modules and features.
+func (s *Schema) CheckInstanceBonuses(instance *Instance) error {
+	if err := s.checkBonuses(instance.Bonuses); err != nil {
+		return fmt.Errorf("instance bonuses: %w", err)
+	}
+	return nil
+}
+
+func (s *Schema) validateBonuses() error {
+	for _, name := range slices.Sorted(maps.Keys(s.Modules)) {
+		if err := s.checkBonuses(s.Modules[name].Bonuses); err != nil {
+			return fmt.Errorf("module %q: %w", name, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Features)) {
+		if err := s.checkBonuses(s.Features[name].Bonuses); err != nil {
+			return fmt.Errorf("feature %q: %w", name, err)
+		}
+	}
+	return nil
+}
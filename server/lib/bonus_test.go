@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBonusStacking(t *testing.T) {
+	schema := &Schema{
+		Variables: map[string]Variable{"dexterity": {Type: TypeNumber, Default: 2.0}},
+		Properties: map[string]Property{
+			"armor_class": {Formula: "10 + dexterity"},
+			"defense":     {Formula: "armor_class"},
+		},
+		Features: map[string]Feature{
+			"shield_spell": {Bonuses: map[string][]Bonus{"armor_class": {{Type: "shield", Value: "5"}}}},
+			"dodge":        {Bonuses: map[string][]Bonus{"armor_class": {{Type: "dodge", Value: "1", Stackable: true}}}},
+		},
+		Modules: map[string]Module{
+			"ring": {Bonuses: map[string][]Bonus{"armor_class": {{Type: "deflection", Value: "1"}}}},
+		},
+	}
+	schema.Features["ring"] = Feature{AddsModules: []string{"ring"}}
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &Instance{
+		ActiveFeatures: []string{"ring", "shield_spell", "dodge"},
+		Bonuses: map[string][]Bonus{"armor_class": {
+			{Type: "deflection", Value: "2"}, // beats the ring
+			{Type: "dodge", Value: "1", Stackable: true},
+			{Type: "deflection", Value: "-1"}, // penalties count separately
+			{Value: "dexterity"},              // untyped
+		}},
+	}
+	evaluation := schema.Evaluate(instance)
+	// 12 base + 2 deflection + 5 shield + 2 dodge - 1 + 2 untyped
+	if evaluation.Properties["armor_class"] != 22.0 || evaluation.Properties["defense"] != 22.0 {
+		t.Fatalf("armor class %v, defense %v: %v", evaluation.Properties["armor_class"],
+			evaluation.Properties["defense"], evaluation.Errors)
+	}
+
+	var applied, ignored []string
+	for _, part := range evaluation.Breakdown["armor_class"] {
+		if part.Applied {
+			applied = append(applied, part.Source)
+		} else {
+			ignored = append(ignored, part.Source)
+		}
+	}
+	if !slices.Equal(ignored, []string{"module ring"}) {
+		t.Errorf("ignored: %v", ignored)
+	}
+	if len(applied) != 7 || applied[0] != "base" {
+		t.Errorf("applied: %v", applied)
+	}
+
+	schema.Features["dodge"] = Feature{Bonuses: map[string][]Bonus{"speed": {{Value: "10"}}}}
+	if err := schema.Validate(); err == nil {
+		t.Error("a bonus to an unknown property should be rejected")
+	}
+}
+
+// Bonuses may target module properties when the schema has none of its own.
+func TestBonusesWithoutBaseFields(t *testing.T) {
+	schema := &Schema{Modules: map[string]Module{
+		"stealth": {AddsProperties: map[string]Property{"sneak": {Formula: "3"}}},
+	}}
+	effect := Effect{Name: "shadowed", Bonuses: map[string][]Bonus{"sneak": {{Value: "2"}}}}
+	if err := schema.AddEffect(&Instance{}, effect); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &Instance{Bonuses: map[string][]Bonus{"sneak": {{Value: "1"}}}}
+	if err := schema.CheckInstanceBonuses(instance); err != nil {
+		t.Error(err)
+	}
+	instance.Bonuses["sneak"] = []Bonus{{Value: "luck"}}
+	if err := schema.CheckInstanceBonuses(instance); err == nil {
+		t.Error("an unknown name in an instance bonus should be rejected")
+	}
+	instance.Bonuses = map[string][]Bonus{"speed": {{Value: "1"}}}
+	if err := schema.CheckInstanceBonuses(instance); err == nil {
+		t.Error("an instance bonus to an unknown property should be rejected")
+	}
+}
@@ -0,0 +1,333 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Bundles //
+
+// A portable zip archive holding a schema and optionally its instances, for
+// moving data between servers. Layout:
+//
+//	manifest.json         format version, contents and checksums
+//	schema.json           the schema, including its visualization
+//	instances/<id>.json   one file per instance, with their visualizations
+//
+// Every file except the manifest is listed in the manifest with its SHA-256.
+
+const (
+	BundleFormat        = "gardi-bundle"
+	BundleFormatVersion = 1
+
+	bundleManifestPath = "manifest.json"
+	bundleSchemaPath   = "schema.json"
+	bundleInstanceDir  = "instances/"
+)
+
+// Largest file accepted inside a bundle, to keep a crafted archive from
+// exhausting memory.
+const maxBundleFileSize = 16 << 20
+
+type BundleManifest struct {
+	Format        string       `json:"format"`
+	FormatVersion int          `json:"format_version"`
+	ExportedAt    time.Time    `json:"exported_at"`
+	SchemaID      string       `json:"schema_id"`
+	Instances     int          `json:"instances"`
+	Files         []BundleFile `json:"files"`
+}
+
+type BundleFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+type Bundle struct {
+	Manifest  BundleManifest
+	Schema    Schema
+	Instances []Instance
+}
+
+// Why an ID in a bundle changed on import.
+type BundleConflict struct {
+	Kind   string `json:"kind"` // schema or instance
+	ID     string `json:"id"`
+	NewID  string `json:"new_id"`
+	Reason string `json:"reason"`
+}
+
+// Writes a zip bundle of the schema and instances to w.
+func WriteBundle(w io.Writer, schema Schema, instances []Instance, exportedAt time.Time) error {
+	files := []struct {
+		path string
+		data any
+	}{{bundleSchemaPath, schema}}
+
+	slices.SortFunc(instances, func(a, b Instance) int { return strings.Compare(a.ID, b.ID) })
+	for _, instance := range instances {
+		files = append(files, struct {
+			path string
+			data any
+		}{bundleInstanceDir + instance.ID + ".json", instance})
+	}
+
+	manifest := BundleManifest{
+		Format:        BundleFormat,
+		FormatVersion: BundleFormatVersion,
+		ExportedAt:    exportedAt,
+		SchemaID:      schema.ID,
+		Instances:     len(instances),
+	}
+
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.data, "", " ")
+		if err != nil {
+			return fmt.Errorf("marshal %s: %w", file.path, err)
+		}
+		if err := writeZipFile(archive, file.path, data, exportedAt); err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, BundleFile{
+			Path:   file.path,
+			SHA256: hex.EncodeToString(sum[:]),
+			Size:   len(data),
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", " ")
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	if err := writeZipFile(archive, bundleManifestPath, data, exportedAt); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+func writeZipFile(archive *zip.Writer, name string, data []byte, modified time.Time) error {
+	writer, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return fmt.Errorf("create %s: %w", name, err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Reads and verifies a bundle: format version, checksums, that nothing is
+// missing or unlisted, and that every instance belongs to the schema.
+func ReadBundle(data []byte) (*Bundle, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not a zip archive: %w", err)
+	}
+
+	contents := make(map[string][]byte)
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		name := path.Clean(file.Name)
+		if name != file.Name || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return nil, fmt.Errorf("invalid file name %q", file.Name)
+		}
+		if file.UncompressedSize64 > maxBundleFileSize {
+			return nil, fmt.Errorf("%s is larger than %d bytes", name, maxBundleFileSize)
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", name, err)
+		}
+		content, err := io.ReadAll(io.LimitReader(reader, maxBundleFileSize+1))
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", name, err)
+		}
+		if len(content) > maxBundleFileSize {
+			return nil, fmt.Errorf("%s is larger than %d bytes", name, maxBundleFileSize)
+		}
+		contents[name] = content
+	}
+
+	bundle := &Bundle{}
+
+	manifestData, ok := contents[bundleManifestPath]
+	if !ok {
+		return nil, fmt.Errorf("bundle has no %s", bundleManifestPath)
+	}
+	if err := json.Unmarshal(manifestData, &bundle.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if bundle.Manifest.Format != BundleFormat {
+		return nil, fmt.Errorf("unknown bundle format %q", bundle.Manifest.Format)
+	}
+	if bundle.Manifest.FormatVersion < 1 || bundle.Manifest.FormatVersion > BundleFormatVersion {
+		return nil, fmt.Errorf("unsupported bundle format version %d", bundle.Manifest.FormatVersion)
+	}
+
+	listed := make(map[string]bool)
+	for _, file := range bundle.Manifest.Files {
+		content, ok := contents[file.Path]
+		if !ok {
+			return nil, fmt.Errorf("%s is listed in the manifest but missing", file.Path)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != file.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch for %s", file.Path)
+		}
+		listed[file.Path] = true
+	}
+	for name := range contents {
+		if name != bundleManifestPath && !listed[name] {
+			return nil, fmt.Errorf("%s is not listed in the manifest", name)
+		}
+	}
+
+	schemaData, ok := contents[bundleSchemaPath]
+	if !ok {
+		return nil, fmt.Errorf("bundle has no %s", bundleSchemaPath)
+	}
+	if err := json.Unmarshal(schemaData, &bundle.Schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if !IsValidID(bundle.Schema.ID) {
+		return nil, fmt.Errorf("schema has a missing or invalid _id %q", bundle.Schema.ID)
+	}
+	if err := bundle.Schema.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		if strings.HasPrefix(name, bundleInstanceDir) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		var instance Instance
+		if err := json.Unmarshal(contents[name], &instance); err != nil {
+			return nil, fmt.Errorf("invalid instance %s: %w", name, err)
+		}
+		if !IsValidID(instance.ID) || seen[instance.ID] {
+			return nil, fmt.Errorf("instance %s has a missing, invalid or duplicate _id", name)
+		}
+		if instance.SchemaID != bundle.Schema.ID {
+			return nil, fmt.Errorf("instance %s belongs to schema %q, not the bundled one", instance.ID, instance.SchemaID)
+		}
+		seen[instance.ID] = true
+		bundle.Instances = append(bundle.Instances, instance)
+	}
+
+	return bundle, nil
+}
+
+// Gives every ID that is already taken a new one and points the instances at
+// the schema's final ID, and references between them at their final IDs.
+// taken reports whether an ID of that kind exists. Returns old -> new for
+// every ID (unchanged ones map to themselves).
+func (b *Bundle) Remap(taken func(kind, id string) bool, newID func() string) (map[string]string, []BundleConflict) {
+	ids := make(map[string]string)
+	var conflicts []BundleConflict
+
+	remap := func(kind, id string) string {
+		if !taken(kind, id) {
+			ids[id] = id
+			return id
+		}
+		replacement := newID()
+		ids[id] = replacement
+		conflicts = append(conflicts, BundleConflict{
+			Kind:   kind,
+			ID:     id,
+			NewID:  replacement,
+			Reason: kind + " id already exists",
+		})
+		return replacement
+	}
+
+	schemaID := b.Schema.ID
+	b.Schema.ID = remap("schema", schemaID)
+	b.Schema.remapSchemaID(schemaID, b.Schema.ID)
+
+	instanceIDs := make(map[string]string, len(b.Instances))
+	for i := range b.Instances {
+		instanceIDs[b.Instances[i].ID] = remap("instance", b.Instances[i].ID)
+	}
+	for i := range b.Instances {
+		instance := &b.Instances[i]
+		instance.ID = instanceIDs[instance.ID]
+		instance.SchemaID = b.Schema.ID
+		b.Schema.remapReferences(instance, instanceIDs)
+	}
+
+	return ids, conflicts
+}
+
+// Points reference variables that name the schema itself at its new ID.
+func (s *Schema) remapSchemaID(old, new string) {
+	remap := func(variables map[string]Variable) {
+		for name, variable := range variables {
+			if variable.Schema == old {
+				variable.Schema = new
+			}
+			if variable.Items != nil && variable.Items.Schema == old {
+				items := *variable.Items
+				items.Schema = new
+				variable.Items = &items
+			}
+			variables[name] = variable
+		}
+	}
+	remap(s.Variables)
+	for _, module := range s.Modules {
+		remap(module.AddsVariables)
+	}
+}
+
+// Replaces the IDs in the instance's reference values, old -> new. IDs that
+// aren't in ids are kept.
+func (s *Schema) remapReferences(instance *Instance, ids map[string]string) {
+	for name, variable := range s.GetAllVariables(instance.ActiveModules) {
+		if _, ok := variable.referenceSchema(); !ok {
+			continue
+		}
+		switch value := instance.VariableValues[name].(type) {
+		case string:
+			if id, ok := ids[value]; ok {
+				instance.VariableValues[name] = id
+			}
+		case []any:
+			remapped := slices.Clone(value)
+			for i, item := range remapped {
+				if id, ok := item.(string); ok && ids[id] != "" {
+					remapped[i] = ids[id]
+				}
+			}
+			instance.VariableValues[name] = remapped
+		}
+	}
+}
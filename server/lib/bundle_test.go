@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testBundle(t *testing.T) []byte {
+	t.Helper()
+	schema := Schema{
+		ID:   "recipes",
+		Name: "Recipes",
+		Variables: map[string]Variable{
+			"servings":  {Type: TypeNumber},
+			"goes_with": {Type: TypeReference, Schema: "recipes"},
+		},
+		Visualization: Visualization{
+			Name: "Card",
+			Type: VisCard,
+		},
+	}
+	instances := []Instance{
+		{ID: "soup", SchemaID: "recipes", Name: "Soup", VariableValues: map[string]any{"servings": 4.0}},
+		{ID: "bread", SchemaID: "recipes", Name: "Bread", VariableValues: map[string]any{"goes_with": "soup"}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBundle(&buf, schema, instances, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	bundle, err := ReadBundle(testBundle(t))
+	if err != nil {
+		t.Fatalf("failed to read bundle: %v", err)
+	}
+
+	if bundle.Manifest.FormatVersion != BundleFormatVersion || bundle.Manifest.Instances != 2 {
+		t.Errorf("unexpected manifest %+v", bundle.Manifest)
+	}
+	if bundle.Schema.Name != "Recipes" || bundle.Schema.Visualization.Type != VisCard {
+		t.Errorf("schema not preserved: %+v", bundle.Schema)
+	}
+	if len(bundle.Instances) != 2 || bundle.Instances[0].ID != "bread" {
+		t.Fatalf("unexpected instances %+v", bundle.Instances)
+	}
+	if servings, _ := bundle.Instances[1].GetNumber("servings"); servings != 4 {
+		t.Errorf("instance values not preserved: %v", bundle.Instances[1].VariableValues)
+	}
+}
+
+// Rewrites one file of the archive, keeping the original manifest.
+func tamper(t *testing.T, data []byte, name string, edit func([]byte) []byte) []byte {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, file := range reader.File {
+		rc, _ := file.Open()
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if file.Name == name {
+			content = edit(content)
+		}
+		w, _ := writer.Create(file.Name)
+		w.Write(content)
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+func TestReadBundleRejectsInvalid(t *testing.T) {
+	data := testBundle(t)
+
+	tests := map[string][]byte{
+		"not a zip": []byte("hello"),
+		"checksum": tamper(t, data, "instances/soup.json", func(b []byte) []byte {
+			return bytes.Replace(b, []byte("Soup"), []byte("Stew"), 1)
+		}),
+		"future version": tamper(t, data, "manifest.json", func(b []byte) []byte {
+			return bytes.Replace(b, []byte(`"format_version": 1`), []byte(`"format_version": 99`), 1)
+		}),
+	}
+
+	for name, bundle := range tests {
+		if _, err := ReadBundle(bundle); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// IDs become file names when the bundle is imported
+	for _, ids := range [][2]string{{"../../x", "soup"}, {"recipes", "a/../b"}, {"recipes", "soup pot"}} {
+		var buf bytes.Buffer
+		schema := Schema{ID: ids[0]}
+		WriteBundle(&buf, schema, []Instance{{ID: ids[1], SchemaID: ids[0]}}, time.Now())
+		if _, err := ReadBundle(buf.Bytes()); err == nil {
+			t.Errorf("ids %q: expected an error", ids)
+		}
+	}
+}
+
+func TestBundleRemap(t *testing.T) {
+	bundle, err := ReadBundle(testBundle(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := map[string]bool{"schema/recipes": true, "instance/soup": true}
+	counter := 0
+	ids, conflicts := bundle.Remap(
+		func(kind, id string) bool { return existing[kind+"/"+id] },
+		func() string { counter++; return fmt.Sprintf("new-%d", counter) },
+	)
+
+	if len(conflicts) != 2 {
+		t.Fatalf("expected 2 conflicts, got %+v", conflicts)
+	}
+	if ids["recipes"] != "new-1" || ids["bread"] != "bread" || ids["soup"] == "soup" {
+		t.Errorf("unexpected id map %v", ids)
+	}
+	for _, instance := range bundle.Instances {
+		if instance.SchemaID != "new-1" {
+			t.Errorf("instance %s still points at %s", instance.ID, instance.SchemaID)
+		}
+		if strings.HasPrefix(instance.ID, "soup") {
+			t.Errorf("colliding instance id was kept")
+		}
+	}
+	if bread := bundle.Instances[0]; bread.VariableValues["goes_with"] != ids["soup"] {
+		t.Errorf("reference not remapped: %v", bread.VariableValues)
+	}
+	if schema := bundle.Schema.Variables["goes_with"].Schema; schema != "new-1" {
+		t.Errorf("reference schema not remapped: %s", schema)
+	}
+}
@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CSV //
+
+// Instances of one schema as a spreadsheet: one row per instance, with the
+// columns _id, name, description, then the variables and the computed
+// properties, each sorted by name. Arrays are written as JSON.
+//
+// On import property columns are ignored, empty cells leave the value as it
+// is, and array cells may be JSON or a list separated by semicolons.
+
+var csvMetaColumns = []string{"_id", "name", "description"}
+
+func (s *Schema) csvColumns() (variables, properties []string) {
+	return slices.Sorted(maps.Keys(s.Variables)), slices.Sorted(maps.Keys(s.Properties))
+}
+
+func WriteInstancesCSV(w io.Writer, schema *Schema, instances []Instance, opts EvalOptions) error {
+	variables, properties := schema.csvColumns()
+
+	writer := csv.NewWriter(w)
+	header := slices.Concat(csvMetaColumns, variables, properties)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, instance := range instances {
+		evaluation := schema.EvaluateWith(&instance, opts)
+
+		record := []string{instance.ID, instance.Name, instance.Description}
+		for _, name := range variables {
+			cell, err := formatCSVCell(evaluation.Variables[name])
+			if err != nil {
+				return fmt.Errorf("instance %s, %s: %w", instance.ID, name, err)
+			}
+			record = append(record, cell)
+		}
+		for _, name := range properties {
+			cell, err := formatCSVCell(evaluation.Properties[name])
+			if err != nil {
+				return fmt.Errorf("instance %s, %s: %w", instance.ID, name, err)
+			}
+			record = append(record, cell)
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatCSVCell(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	if number, ok := AsNumber(value); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64), nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// A parsed data row. Line is the row's line in the file (the header is 1).
+// ID is empty for rows that should create a new instance.
+type CSVRow struct {
+	Line        int
+	ID          string
+	Name        string
+	Description string
+	Values      map[string]any // only the cells that weren't empty
+}
+
+type CSVError struct {
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Error  string `json:"error"`
+}
+
+// Reads rows written by WriteInstancesCSV (or by hand). Every cell is checked
+// against its variable; rows with a bad cell are reported in the errors and
+// left out of the rows. The error return is for files that can't be read at
+// all, like a bad header.
+func ParseInstancesCSV(r io.Reader, schema *Schema) ([]CSVRow, []CSVError, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("csv file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid csv header: %w", err)
+	}
+	header[0] = strings.TrimPrefix(header[0], "\ufeff") // spreadsheet byte order mark
+
+	seen := make(map[string]bool, len(header))
+	for _, column := range header {
+		if seen[column] {
+			return nil, nil, fmt.Errorf("column %q appears twice", column)
+		}
+		seen[column] = true
+
+		_, isVariable := schema.Variables[column]
+		_, isProperty := schema.Properties[column]
+		if !isVariable && !isProperty && !slices.Contains(csvMetaColumns, column) {
+			return nil, nil, fmt.Errorf("unknown column %q", column)
+		}
+	}
+
+	rows := []CSVRow{}
+	problems := []CSVError{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if errors.Is(err, csv.ErrFieldCount) {
+			problems = append(problems, CSVError{Row: line, Error: fmt.Sprintf(
+				"expected %d cells, got %d", len(header), len(record))})
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid csv: %w", err)
+		}
+
+		row := CSVRow{Line: line, Values: make(map[string]any)}
+		valid := true
+		for index, cell := range record {
+			column := header[index]
+			switch column {
+			case "_id":
+				row.ID = strings.TrimSpace(cell)
+				if row.ID != "" && !IsValidID(row.ID) {
+					problems = append(problems, CSVError{Row: line, Column: column, Error: fmt.Sprintf("invalid id %q", row.ID)})
+					valid = false
+				}
+				continue
+			case "name":
+				row.Name = cell
+				continue
+			case "description":
+				row.Description = cell
+				continue
+			}
+
+			variable, ok := schema.Variables[column]
+			if !ok || strings.TrimSpace(cell) == "" {
+				continue
+			}
+			value, err := parseCSVCell(&variable, cell)
+			if err != nil {
+				problems = append(problems, CSVError{Row: line, Column: column, Error: err.Error()})
+				valid = false
+				continue
+			}
+			row.Values[column] = value
+		}
+
+		if valid {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, problems, nil
+}
+
+func parseCSVCell(variable *Variable, cell string) (any, error) {
+	if variable.Type != TypeArray {
+		return variable.Coerce(cell)
+	}
+
+	trimmed := strings.TrimSpace(cell)
+	var list []any
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &list); err != nil {
+			return nil, fmt.Errorf("invalid array: %w", err)
+		}
+	} else {
+		for _, item := range strings.Split(trimmed, ";") {
+			list = append(list, strings.TrimSpace(item))
+		}
+	}
+	return variable.Coerce(list)
+}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func csvTestSchema() *Schema {
+	min := 1.0
+	return &Schema{
+		ID: "dnd",
+		Variables: map[string]Variable{
+			"level": {Type: TypeNumber, Default: 1.0, Min: &min},
+			"class": {Type: TypeEnum, Options: []string{"fighter", "wizard"}},
+			"alive": {Type: TypeBoolean},
+			"tags":  {Type: TypeArray, Items: &Variable{Type: TypeString}},
+		},
+		Properties: map[string]Property{
+			"double": {Formula: "level * 2"},
+		},
+	}
+}
+
+func TestInstancesCSVRoundTrip(t *testing.T) {
+	schema := csvTestSchema()
+	instances := []Instance{{
+		ID:          "a",
+		Name:        "Aria, the \"Bold\"",
+		Description: "two\nlines",
+		VariableValues: map[string]any{
+			"level": 3.0, "class": "wizard", "alive": true, "tags": []any{"elf", "sage"},
+		},
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteInstancesCSV(&buf, schema, instances, EvalOptions{}); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	header, _, _ := strings.Cut(buf.String(), "\n")
+	if header != "_id,name,description,alive,class,level,tags,double" {
+		t.Errorf("unexpected header %q", header)
+	}
+	if !strings.Contains(buf.String(), ",6\n") {
+		t.Errorf("computed property missing:\n%s", buf.String())
+	}
+
+	rows, problems, err := ParseInstancesCSV(&buf, schema)
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("parse failed: %v %v", err, problems)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(rows))
+	}
+	row := rows[0]
+	if row.ID != "a" || row.Name != instances[0].Name || row.Description != instances[0].Description {
+		t.Errorf("unexpected row %+v", row)
+	}
+	if row.Values["level"] != 3.0 || row.Values["class"] != "wizard" || row.Values["alive"] != true {
+		t.Errorf("unexpected values %v", row.Values)
+	}
+	if tags, _ := row.Values["tags"].([]any); len(tags) != 2 || tags[1] != "sage" {
+		t.Errorf("unexpected tags %v", row.Values["tags"])
+	}
+}
+
+func TestParseInstancesCSVErrors(t *testing.T) {
+	schema := csvTestSchema()
+	input := "name,level,class,tags,double\n" +
+		"Ok,2,fighter,a; b,99\n" +
+		"Low,0,fighter,,\n" +
+		"Bad,x,rogue,,\n" +
+		"Short,1\n" +
+		"Blank,,,,\n"
+
+	rows, problems, err := ParseInstancesCSV(strings.NewReader(input), schema)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if len(rows) != 2 || rows[0].Name != "Ok" || rows[1].Name != "Blank" {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+	if tags, _ := rows[0].Values["tags"].([]any); len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("semicolon list not split: %v", rows[0].Values["tags"])
+	}
+	if _, ok := rows[0].Values["double"]; ok {
+		t.Error("property column should be ignored")
+	}
+	if len(rows[1].Values) != 0 {
+		t.Errorf("empty cells should be skipped, got %v", rows[1].Values)
+	}
+
+	want := []CSVError{
+		{Row: 3, Column: "level"},
+		{Row: 4, Column: "level"},
+		{Row: 4, Column: "class"},
+		{Row: 5},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("got problems %+v", problems)
+	}
+	for i, problem := range problems {
+		if problem.Row != want[i].Row || problem.Column != want[i].Column || problem.Error == "" {
+			t.Errorf("problem %d: got %+v, want row %d column %q", i, problem, want[i].Row, want[i].Column)
+		}
+	}
+
+	if _, _, err := ParseInstancesCSV(strings.NewReader("name,strength\n"), schema); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
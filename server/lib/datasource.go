@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Data sources //
+
+// A REST endpoint that fills in instance variables.
+//
+// The response body must be JSON. Mapping goes from variable name to a path
+// inside the response, e.g. "kills": "data.players[0].stats.kills".
+// URL, header values and Body can reference instance variables with
+// {{variable_name}} so one schema can point each instance at its own record.
+type DataSource struct {
+	URL            string            `json:"url"`
+	Method         string            `json:"method,omitempty"` // defaults to GET
+	Headers        map[string]string `json:"headers,omitempty"`
+	Body           string            `json:"body,omitempty"`
+	Mapping        map[string]string `json:"mapping"`
+	RefreshSeconds int               `json:"refresh_seconds,omitempty"` // 0 means manual refresh only
+}
+
+var templatePattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+func (ds *DataSource) Validate(schema *Schema) error {
+	if ds.URL == "" {
+		return fmt.Errorf("data source url is required")
+	}
+	switch strings.ToUpper(ds.Method) {
+	case "", http.MethodGet, http.MethodPost, http.MethodPut:
+	default:
+		return fmt.Errorf("unsupported data source method %q", ds.Method)
+	}
+	if len(ds.Mapping) == 0 {
+		return fmt.Errorf("data source needs at least one mapping")
+	}
+	if ds.RefreshSeconds < 0 {
+		return fmt.Errorf("refresh_seconds can't be negative")
+	}
+	for variable, path := range ds.Mapping {
+		if _, ok := schema.Variables[variable]; !ok {
+			return fmt.Errorf("mapping references unknown variable %q", variable)
+		}
+		if _, err := parseJSONPath(path); err != nil {
+			return fmt.Errorf("mapping for %q: %w", variable, err)
+		}
+	}
+	return nil
+}
+
+func (ds *DataSource) HTTPMethod() string {
+	if ds.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(ds.Method)
+}
+
+// Replaces {{variable}} placeholders with the instance's values, as they
+// are. Unknown variables become empty strings.
+func ExpandTemplate(template string, instance *Instance) string {
+	return expandTemplate(template, instance, func(value string, _ int) string { return value })
+}
+
+// ExpandTemplate for URLs. Values are escaped for where they land: as a path
+// segment before the "?", as a query value after it. A value can't add path
+// segments or query parameters of its own.
+func ExpandURL(template string, instance *Instance) string {
+	query := strings.IndexByte(template, '?')
+	return expandTemplate(template, instance, func(value string, at int) string {
+		if query >= 0 && at > query {
+			return url.QueryEscape(value)
+		}
+		return url.PathEscape(value)
+	})
+}
+
+// escape gets each value and the placeholder's offset in the template.
+func expandTemplate(template string, instance *Instance, escape func(value string, at int) string) string {
+	var expanded strings.Builder
+	last := 0
+	for _, match := range templatePattern.FindAllStringSubmatchIndex(template, -1) {
+		expanded.WriteString(template[last:match[0]])
+		expanded.WriteString(escape(templateValue(instance, template[match[2]:match[3]]), match[0]))
+		last = match[1]
+	}
+	expanded.WriteString(template[last:])
+	return expanded.String()
+}
+
+func templateValue(instance *Instance, name string) string {
+	value, ok := instance.GetVariable(name)
+	if !ok || value == nil {
+		return ""
+	}
+	if number, ok := AsNumber(value); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// Writes the mapped fields of a decoded response into the instance.
+// Every mapping is attempted; the returned map holds what was updated and
+// the errors slice describes the mappings that were skipped.
+func (ds *DataSource) Apply(schema *Schema, instance *Instance, response any) (map[string]any, []error) {
+	updated := make(map[string]any)
+	var errs []error
+
+	for variable, path := range ds.Mapping {
+		def, ok := schema.Variables[variable]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown variable", variable))
+			continue
+		}
+
+		raw, err := LookupJSONPath(response, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", variable, err))
+			continue
+		}
+
+		value, err := def.Coerce(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", variable, err))
+			continue
+		}
+
+		instance.SetVariable(variable, value)
+		updated[variable] = value
+	}
+
+	return updated, errs
+}
+
+// JSON paths //
+
+// A path segment is either an object key or an array index.
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// Parses paths like "$.data.players[2].name", "data.players.2.name" or "$".
+func parseJSONPath(path string) ([]pathSegment, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+
+	var segments []pathSegment
+	if path == "" {
+		return segments, nil
+	}
+
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			return nil, fmt.Errorf("empty segment in path %q", path)
+		}
+
+		key, rest, _ := strings.Cut(part, "[")
+		if key != "" {
+			if index, err := strconv.Atoi(key); err == nil {
+				segments = append(segments, pathSegment{index: index, isIndex: true})
+			} else {
+				segments = append(segments, pathSegment{key: key})
+			}
+		}
+
+		for rest != "" {
+			number, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("unclosed [ in path %q", path)
+			}
+			index, err := strconv.Atoi(number)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q in path %q", number, path)
+			}
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+
+			if after == "" {
+				break
+			}
+			if !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("unexpected %q in path %q", after, path)
+			}
+			rest = after[1:]
+		}
+	}
+
+	return segments, nil
+}
+
+// Walks a value decoded by encoding/json (maps, slices and scalars).
+func LookupJSONPath(doc any, path string) (any, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := doc
+	for _, segment := range segments {
+		if segment.isIndex {
+			list, ok := current.([]any)
+			if !ok {
+				return nil, fmt.Errorf("path %q: expected array at [%d]", path, segment.index)
+			}
+			if segment.index < 0 || segment.index >= len(list) {
+				return nil, fmt.Errorf("path %q: index %d out of range", path, segment.index)
+			}
+			current = list[segment.index]
+			continue
+		}
+
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("path %q: expected object at %q", path, segment.key)
+		}
+		current, ok = object[segment.key]
+		if !ok {
+			return nil, fmt.Errorf("path %q: key %q not found", path, segment.key)
+		}
+	}
+
+	return current, nil
+}
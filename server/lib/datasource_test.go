@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLookupJSONPath(t *testing.T) {
+	var doc any
+	err := json.Unmarshal([]byte(`{
+		"team": {"name": "Spikers"},
+		"players": [
+			{"name": "Ana", "stats": {"kills": 12}},
+			{"name": "Bo", "stats": {"kills": 7}}
+		],
+		"matrix": [[1, 2], [3, 4]]
+	}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		want    any
+		wantErr bool
+	}{
+		{path: "team.name", want: "Spikers"},
+		{path: "$.team.name", want: "Spikers"},
+		{path: "players[1].stats.kills", want: 7.0},
+		{path: "players.0.name", want: "Ana"},
+		{path: "matrix[1][0]", want: 3.0},
+		{path: "players[5].name", wantErr: true},
+		{path: "team.missing", wantErr: true},
+		{path: "team.name.first", wantErr: true},
+		{path: "players[x]", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := LookupJSONPath(doc, tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDataSourceApply(t *testing.T) {
+	max := 100.0
+	schema := &Schema{
+		Variables: map[string]Variable{
+			"kills":    {Type: TypeNumber, Max: &max},
+			"team":     {Type: TypeString},
+			"position": {Type: TypeEnum, Options: []string{"setter", "libero"}},
+		},
+	}
+	source := DataSource{
+		URL: "http://example.test/players/{{player_id}}",
+		Mapping: map[string]string{
+			"kills":    "stats.kills",
+			"team":     "team",
+			"position": "position",
+		},
+	}
+	if err := source.Validate(schema); err != nil {
+		t.Fatalf("valid source failed validation: %v", err)
+	}
+
+	instance := &Instance{VariableValues: map[string]any{"player_id": 42.0}}
+	if got := ExpandURL(source.URL, instance); got != "http://example.test/players/42" {
+		t.Errorf("unexpected expanded url %q", got)
+	}
+	tricky := &Instance{VariableValues: map[string]any{"player_id": "../admin?x=1", "team": "A&B c"}}
+	if got := ExpandURL("http://example.test/players/{{player_id}}?team={{team}}", tricky); got != "http://example.test/players/..%2Fadmin%3Fx=1?team=A%26B+c" {
+		t.Errorf("values should be escaped: %q", got)
+	}
+	if got := ExpandTemplate(`{"team": "{{ team }}"}`, tricky); got != `{"team": "A&B c"}` {
+		t.Errorf("unexpected expanded body %q", got)
+	}
+
+	var response any
+	json.Unmarshal([]byte(`{"stats": {"kills": "17"}, "team": "Spikers", "position": "outside"}`), &response)
+
+	updated, errs := source.Apply(schema, instance, response)
+	if len(updated) != 2 {
+		t.Errorf("expected 2 updated variables, got %v", updated)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected the enum mismatch to be reported, got %v", errs)
+	}
+	if kills, _ := instance.GetNumber("kills"); kills != 17 {
+		t.Errorf("expected kills parsed to 17, got %v", instance.VariableValues["kills"])
+	}
+
+	bad := DataSource{URL: "http://example.test", Mapping: map[string]string{"missing": "a"}}
+	if err := bad.Validate(schema); err == nil {
+		t.Error("expected mapping to an unknown variable to fail validation")
+	}
+}
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Effects //
+
+// A temporary set of bonuses on an instance, like a buff, a condition such as
+// "poisoned", or a concentration spell. Effects lasting rounds or turns count
+// down when those are ticked and end at zero; effects lasting until an event
+// end when it happens; effects without a duration last until removed. Ended
+// effects are dropped from the instance.
+type Effect struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Bonuses     map[string][]Bonus `json:"bonuses,omitempty"` // property -> bonuses
+	Duration    DurationUnit       `json:"duration,omitempty"`
+	Remaining   int                `json:"remaining,omitempty"` // rounds or turns left
+	Until       string             `json:"until,omitempty"`     // event that ends it
+}
+
+type DurationUnit string
+
+const (
+	DurationRounds DurationUnit = "rounds"
+	DurationTurns  DurationUnit = "turns"
+	DurationEvent  DurationUnit = "event"
+)
+
+var ErrUnknownEffect = errors.New("unknown effect")
+
+func (e *Effect) active() bool {
+	switch e.Duration {
+	case DurationRounds, DurationTurns:
+		return e.Remaining > 0
+	}
+	return true
+}
+
+func (e *Effect) validate(s *Schema) error {
+	if strings.TrimSpace(e.Name) == "" {
+		return fmt.Errorf("effect needs a name")
+	}
+	switch e.Duration {
+	case "":
+	case DurationRounds, DurationTurns:
+		if e.Remaining <= 0 {
+			return fmt.Errorf("effect %q: %s remaining must be positive", e.Name, e.Duration)
+		}
+	case DurationEvent:
+		if e.Until == "" {
+			return fmt.Errorf("effect %q: no event to last until", e.Name)
+		}
+		// otherwise it could never end
+		if _, ok := s.EventName(e.Until); !ok {
+			return fmt.Errorf("effect %q: %w %q", e.Name, ErrUnknownEvent, e.Until)
+		}
+	default:
+		return fmt.Errorf("effect %q: unknown duration %q", e.Name, e.Duration)
+	}
+	if e.Until != "" && e.Duration != DurationEvent {
+		return fmt.Errorf("effect %q: until is only for event durations", e.Name)
+	}
+	if err := s.checkBonuses(e.Bonuses); err != nil {
+		return fmt.Errorf("effect %q: %w", e.Name, err)
+	}
+	return nil
+}
+
+// Adds the effect to the instance, replacing (and so refreshing) one with
+// the same name.
+func (s *Schema) AddEffect(instance *Instance, effect Effect) error {
+	if err := effect.validate(s); err != nil {
+		return err
+	}
+	index := slices.IndexFunc(instance.Effects, func(e Effect) bool { return e.Name == effect.Name })
+	if index >= 0 {
+		instance.Effects[index] = effect
+	} else {
+		instance.Effects = append(instance.Effects, effect)
+	}
+	return nil
+}
+
+// Ends the named effect early.
+func RemoveEffect(instance *Instance, name string) error {
+	index := slices.IndexFunc(instance.Effects, func(e Effect) bool { return e.Name == name })
+	if index < 0 {
+		return fmt.Errorf("%w %q", ErrUnknownEffect, name)
+	}
+	instance.Effects = slices.Delete(instance.Effects, index, index+1)
+	return nil
+}
+
+// Counts down effects lasting the given unit (rounds or turns) and drops the
+// ones that run out. Returns the names of the dropped effects.
+func TickEffects(instance *Instance, unit DurationUnit, amount int) ([]string, error) {
+	if unit != DurationRounds && unit != DurationTurns {
+		return nil, fmt.Errorf("can't tick %q, only rounds or turns", unit)
+	}
+	if amount < 0 {
+		return nil, fmt.Errorf("can't tick back %d %s", -amount, unit)
+	}
+	for i := range instance.Effects {
+		if instance.Effects[i].Duration == unit {
+			instance.Effects[i].Remaining -= amount
+		}
+	}
+	return dropEffects(instance, func(e *Effect) bool { return !e.active() }), nil
+}
+
+// Drops the effects lasting until the event (matched ignoring case) and
+// returns their names.
+func ExpireEffectsOn(instance *Instance, event string) []string {
+	return dropEffects(instance, func(e *Effect) bool {
+		return e.Duration == DurationEvent && strings.EqualFold(e.Until, event)
+	})
+}
+
+func dropEffects(instance *Instance, ended func(*Effect) bool) []string {
+	var dropped []string
+	instance.Effects = slices.DeleteFunc(instance.Effects, func(e Effect) bool {
+		if ended(&e) {
+			dropped = append(dropped, e.Name)
+			return true
+		}
+		return false
+	})
+	return dropped
+}
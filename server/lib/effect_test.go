@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEffects(t *testing.T) {
+	schema := &Schema{
+		Variables:  map[string]Variable{"strength": {Type: TypeNumber, Default: 10.0}},
+		Properties: map[string]Property{"attack": {Formula: "strength / 2"}},
+		Events:     map[string]Event{"Long Rest": {}},
+	}
+	instance := &Instance{}
+
+	effects := []Effect{
+		{Name: "bless", Bonuses: map[string][]Bonus{"attack": {{Type: "luck", Value: "2"}}}, Duration: DurationRounds, Remaining: 2},
+		{Name: "poisoned", Bonuses: map[string][]Bonus{"attack": {{Value: "-2"}}}, Duration: DurationEvent, Until: "long rest"},
+		{Name: "rage", Bonuses: map[string][]Bonus{"attack": {{Value: "strength / 5"}}}, Duration: DurationTurns, Remaining: 1},
+	}
+	for _, effect := range effects {
+		if err := schema.AddEffect(instance, effect); err != nil {
+			t.Fatalf("adding %s: %v", effect.Name, err)
+		}
+	}
+	if got := schema.Evaluate(instance).Properties["attack"]; got != 7.0 {
+		t.Errorf("with every effect: %v", got)
+	}
+
+	expired, err := TickEffects(instance, DurationRounds, 1)
+	if err != nil || len(expired) != 0 {
+		t.Errorf("first round: %v %v", expired, err)
+	}
+	expired, _ = TickEffects(instance, DurationRounds, 1)
+	if !slices.Equal(expired, []string{"bless"}) {
+		t.Errorf("second round: %v", expired)
+	}
+	if got := schema.Evaluate(instance).Properties["attack"]; got != 5.0 {
+		t.Errorf("after bless ends: %v", got)
+	}
+
+	result, err := schema.ApplyEvent(instance, "long rest", EvalOptions{})
+	if err != nil || !slices.Equal(result.Expired, []string{"poisoned"}) {
+		t.Errorf("long rest: %v %v", result, err)
+	}
+	if err := RemoveEffect(instance, "rage"); err != nil || len(instance.Effects) != 0 {
+		t.Errorf("removing rage: %v %v", err, instance.Effects)
+	}
+
+	invalid := []Effect{
+		{},
+		{Name: "haste", Duration: DurationRounds},
+		{Name: "haste", Duration: DurationEvent},
+		{Name: "haste", Duration: DurationEvent, Until: "short rest"},
+		{Name: "haste", Duration: "minutes", Remaining: 10},
+		{Name: "haste", Bonuses: map[string][]Bonus{"speed": {{Value: "30"}}}},
+	}
+	for _, effect := range invalid {
+		if err := schema.AddEffect(instance, effect); err == nil {
+			t.Errorf("%+v should be rejected", effect)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Events //
+
+// Named moments in play, like a short or long rest, that change several
+// variables at once. Each update is a formula for a variable's new value,
+// e.g. {"hp": "max_hp"}. Every formula sees the values from before the event,
+// so the order of the updates doesn't matter. Trackers that reset on the
+// event are refilled afterwards, and effects lasting until it end.
+type Event struct {
+	Description string            `json:"description,omitempty"`
+	Updates     map[string]string `json:"updates,omitempty"` // variable -> formula
+}
+
+type ValueChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// What applying an event changed.
+type EventResult struct {
+	Event    string                 `json:"event"`
+	Changes  map[string]ValueChange `json:"changes"`
+	Trackers []string               `json:"trackers,omitempty"` // trackers that were reset
+	Expired  []string               `json:"expired_effects,omitempty"`
+}
+
+var ErrUnknownEvent = errors.New("unknown event")
+
+func (e *Event) validate(s *Schema) error {
+	variables, _ := s.declaredFields()
+	for _, name := range slices.Sorted(maps.Keys(e.Updates)) {
+		if _, ok := variables[name]; !ok {
+			return fmt.Errorf("update of %q: not a variable", name)
+		}
+		identifiers, err := FormulaIdentifiers(e.Updates[name])
+		if err != nil {
+			return fmt.Errorf("update of %q: %w", name, err)
+		}
+		for _, identifier := range identifiers {
+			if !s.hasField(identifier) {
+				return fmt.Errorf("update of %q: unknown name %q in formula", name, identifier)
+			}
+		}
+	}
+	return nil
+}
+
+// The declared name of an event, matched ignoring case. Events that are only
+// named in a tracker's reset_on exist too, with no updates.
+func (s *Schema) EventName(name string) (string, bool) {
+	for declared := range s.Events {
+		if strings.EqualFold(declared, name) {
+			return declared, true
+		}
+	}
+	for _, tracker := range s.Trackers {
+		for _, event := range tracker.ResetOn {
+			if strings.EqualFold(event, name) {
+				return event, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Applies the event to the instance. On error the instance is unchanged.
+func (s *Schema) ApplyEvent(instance *Instance, name string, opts EvalOptions) (*EventResult, error) {
+	declared, ok := s.EventName(name)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownEvent, name)
+	}
+	event := s.Events[declared]
+
+	// formulas see the stored values: module modifiers are applied on every
+	// evaluation, so saving a modified value would apply them twice
+	evaluation := s.EvaluateWith(instance, opts)
+	variables := s.GetAllVariables(evaluation.ActiveModules)
+	stored := resolveValues(variables, instance)
+	env := opts.env(stored, variables)
+	maps.Copy(env, evaluation.Properties)
+
+	updates := make(map[string]any, len(event.Updates))
+	for variableName, formula := range event.Updates {
+		variable, ok := variables[variableName]
+		if !ok {
+			continue // a variable of a module that isn't active
+		}
+		value, err := EvaluateFormula(formula, env)
+		if err != nil {
+			return nil, fmt.Errorf("update of %q: %w", variableName, err)
+		}
+		if value == nil {
+			return nil, fmt.Errorf("update of %q: formula %q has no value", variableName, formula)
+		}
+		value, err = variable.Coerce(value)
+		if err != nil {
+			return nil, fmt.Errorf("update of %q: %w", variableName, err)
+		}
+		updates[variableName] = value
+	}
+
+	// trackers are checked before anything changes so a failure leaves the
+	// instance as it was
+	updated := *instance
+	updated.VariableValues = maps.Clone(instance.VariableValues)
+	updated.Effects = slices.Clone(instance.Effects)
+	for variableName, value := range updates {
+		updated.SetVariable(variableName, value)
+	}
+	trackers := s.TrackersResetOn(declared)
+	for _, tracker := range trackers {
+		if _, err := s.ResetSlots(&updated, tracker, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	expired := ExpireEffectsOn(&updated, declared)
+
+	result := &EventResult{Event: declared, Changes: make(map[string]ValueChange), Trackers: trackers, Expired: expired}
+	for variableName, value := range updated.VariableValues {
+		if previous, ok := stored[variableName]; !ok || !reflect.DeepEqual(previous, value) {
+			result.Changes[variableName] = ValueChange{Before: previous, After: value}
+		}
+	}
+
+	*instance = updated
+	return result, nil
+}
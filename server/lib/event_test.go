@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+)
+
+func eventTestSchema() *Schema {
+	schema := trackerTestSchema()
+	schema.Variables["hp"] = Variable{Type: TypeNumber}
+	schema.Variables["hit_dice"] = Variable{Type: TypeNumber, Default: 0.0}
+	schema.Properties["max_hp"] = Property{Formula: "level * 8"}
+	schema.Events = map[string]Event{
+		"long rest": {Updates: map[string]string{
+			"hp":       "max_hp",
+			"hit_dice": "min(hit_dice + level / 2, level)",
+			"level":    "hp", // sees hp from before the rest
+		}},
+		"bad": {Updates: map[string]string{"hp": `"full"`}},
+	}
+	return schema
+}
+
+func TestApplyEvent(t *testing.T) {
+	schema := eventTestSchema()
+	if err := schema.Validate(); err != nil {
+		t.Fatalf("valid schema rejected: %v", err)
+	}
+
+	instance := &Instance{VariableValues: map[string]any{"hp": 3.0, "hit_dice": 0.0, "spell_slots": 0.0}}
+	result, err := schema.ApplyEvent(instance, "Long Rest", EvalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Event != "long rest" {
+		t.Errorf("event name %q", result.Event)
+	}
+	want := map[string]float64{"hp": 24, "hit_dice": 1.5, "level": 3, "spell_slots": 4}
+	for name, value := range want {
+		if got, _ := instance.GetNumber(name); got != value {
+			t.Errorf("%s: got %v, want %v", name, got, value)
+		}
+	}
+	if change := result.Changes["hp"]; change.Before != 3.0 || change.After != 24.0 {
+		t.Errorf("hp change %+v", change)
+	}
+	if _, ok := result.Changes["level"]; ok {
+		t.Error("level kept its value and shouldn't be listed as a change")
+	}
+	if len(result.Trackers) != 2 {
+		t.Errorf("trackers reset %v", result.Trackers)
+	}
+
+	// events only named by trackers reset them
+	instance.SetVariable("ki", 0.0)
+	if result, err := schema.ApplyEvent(instance, "short rest", EvalOptions{}); err != nil || result.Changes["ki"].After != 3.0 {
+		t.Errorf("short rest: %+v %v", result, err)
+	}
+
+	// failures change nothing
+	before, _ := instance.GetNumber("hp")
+	if _, err := schema.ApplyEvent(instance, "bad", EvalOptions{}); err == nil {
+		t.Error("a string for a number variable should fail")
+	}
+	if after, _ := instance.GetNumber("hp"); after != before {
+		t.Error("failed event changed the instance")
+	}
+	if _, err := schema.ApplyEvent(instance, "nap", EvalOptions{}); !errors.Is(err, ErrUnknownEvent) {
+		t.Errorf("expected unknown event, got %v", err)
+	}
+}
+
+// Module modifiers apply on evaluation, not to what events store.
+func TestApplyEventWithModifiers(t *testing.T) {
+	schema := &Schema{
+		Variables: map[string]Variable{"gold": {Type: TypeNumber, Default: 0.0}},
+		Features:  map[string]Feature{"rich": {AddsModules: []string{"treasury"}}},
+		Modules:   map[string]Module{"treasury": {Modifies: map[string]Modifier{"gold": {Formula: "100"}}}},
+		Events:    map[string]Event{"payday": {Updates: map[string]string{"gold": "gold + 10"}}},
+	}
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &Instance{ActiveFeatures: []string{"rich"}}
+	for i, want := range []float64{10, 20, 30} {
+		result, err := schema.ApplyEvent(instance, "payday", EvalOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change := result.Changes["gold"]; change.Before != want-10 || change.After != want {
+			t.Errorf("payday %d: %+v", i+1, change)
+		}
+	}
+	if gold := schema.Evaluate(instance).Variables["gold"]; gold != 130.0 {
+		t.Errorf("evaluated gold: %v", gold)
+	}
+}
+
+// Events may update module variables, while their module is active.
+func TestApplyEventToModuleVariables(t *testing.T) {
+	schema := &Schema{
+		Features: map[string]Feature{"monk": {AddsModules: []string{"ki"}}},
+		Modules:  map[string]Module{"ki": {AddsVariables: map[string]Variable{"ki": {Type: TypeNumber}}}},
+		Events:   map[string]Event{"meditate": {Updates: map[string]string{"ki": `"5"`}}},
+	}
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &Instance{}
+	if result, err := schema.ApplyEvent(instance, "meditate", EvalOptions{}); err != nil || len(result.Changes) != 0 {
+		t.Errorf("inactive module: %+v %v", result, err)
+	}
+	instance.ActiveFeatures = []string{"monk"}
+	if _, err := schema.ApplyEvent(instance, "meditate", EvalOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if ki, _ := instance.GetNumber("ki"); ki != 5 {
+		t.Errorf("ki: %v", ki)
+	}
+}
+
+func TestValidateEvents(t *testing.T) {
+	for name, update := range map[string]map[string]string{
+		"property": {"max_hp": "1"},
+		"unknown":  {"hp": "constitution"},
+		"syntax":   {"hp": "max_hp +"},
+	} {
+		schema := eventTestSchema()
+		schema.Events["broken"] = Event{Updates: update}
+		if err := schema.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Features //
+
+// A feature without a condition is active when it's listed in the instance's
+// active_features. A feature with one is active exactly while its condition,
+// e.g. `level >= 5 && class == "fighter"`, is true; listing it changes
+// nothing. Conditions see the schema's own variables and properties, not
+// the ones modules add, since modules depend on the active features.
+
+// Active features for the instance's current values, sorted, and the
+// conditions that couldn't be evaluated (feature -> error). Features with a
+// failing condition are inactive.
+func (s *Schema) ActiveFeatures(instance *Instance) ([]string, map[string]string) {
+	return s.activeFeatures(instance, EvalOptions{})
+}
+
+func (s *Schema) activeFeatures(instance *Instance, opts EvalOptions) ([]string, map[string]string) {
+	base := evaluateProperties(opts.env(s.ResolveVariables(instance), s.Variables), s.Properties, nil)
+	env := maps.Clone(base.Variables)
+	maps.Copy(env, base.Properties)
+
+	var active []string
+	failures := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(s.Features)) {
+		feature := s.Features[name]
+		if feature.Condition == "" {
+			if slices.Contains(instance.ActiveFeatures, name) {
+				active = append(active, name)
+			}
+			continue
+		}
+
+		result, err := EvaluateFormula(feature.Condition, env)
+		if err != nil {
+			failures[name] = err.Error()
+			continue
+		}
+		holds, ok := result.(bool)
+		if !ok {
+			failures[name] = fmt.Sprintf("condition %q gave %v, not true or false", feature.Condition, result)
+			continue
+		}
+		if holds {
+			active = append(active, name)
+		}
+	}
+
+	if len(failures) == 0 {
+		failures = nil
+	}
+	return active, failures
+}
+
+// Recalculates the instance's active features and modules from its values.
+func (i *Instance) UpdateActiveFeatures(schema *Schema, opts EvalOptions) map[string]string {
+	active, failures := schema.activeFeatures(i, opts)
+	if active == nil {
+		active = []string{}
+	}
+	i.ActiveFeatures = active
+	i.UpdateActiveModules(schema)
+	return failures
+}
+
+func (f *Feature) validate(s *Schema) error {
+	for _, module := range f.AddsModules {
+		if _, ok := s.Modules[module]; !ok {
+			return fmt.Errorf("unknown module %q", module)
+		}
+	}
+	for _, other := range slices.Concat(f.Requires, f.Excludes) {
+		if _, ok := s.Features[other]; !ok {
+			return fmt.Errorf("unknown feature %q", other)
+		}
+	}
+
+	for kind, formula := range map[string]string{"condition": f.Condition, "prerequisite": f.Prerequisite} {
+		if formula == "" {
+			continue
+		}
+		identifiers, err := FormulaIdentifiers(formula)
+		if err != nil {
+			return fmt.Errorf("%s: %w", kind, err)
+		}
+		for _, identifier := range identifiers {
+			if !s.hasField(identifier) {
+				return fmt.Errorf("%s: unknown name %q", kind, identifier)
+			}
+		}
+	}
+	return nil
+}
+
+// Feature rules //
+
+var (
+	ErrUnknownFeature = errors.New("unknown feature")
+	ErrFeatureRule    = errors.New("feature rule")
+)
+
+func featureRuleError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrFeatureRule, fmt.Sprintf(format, args...))
+}
+
+func (s *Schema) excludes(a, b string) bool {
+	return slices.Contains(s.Features[a].Excludes, b) || slices.Contains(s.Features[b].Excludes, a)
+}
+
+// Checks that the feature can be added by hand to the instance as it is now.
+// Errors wrap ErrUnknownFeature or ErrFeatureRule.
+func (s *Schema) CheckAddFeature(instance *Instance, name string, opts EvalOptions) error {
+	feature, ok := s.Features[name]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownFeature, name)
+	}
+	if feature.Condition != "" {
+		return featureRuleError("%s turns on by itself when %s", name, feature.Condition)
+	}
+
+	active, _ := s.activeFeatures(instance, opts)
+	var missing []string
+	for _, required := range feature.Requires {
+		if !slices.Contains(active, required) {
+			missing = append(missing, required)
+		}
+	}
+	if len(missing) > 0 {
+		return featureRuleError("%s requires %s", name, strings.Join(missing, ", "))
+	}
+
+	if feature.Prerequisite != "" {
+		evaluation := s.EvaluateWith(instance, opts)
+		env := opts.env(evaluation.Variables, s.GetAllVariables(evaluation.ActiveModules))
+		maps.Copy(env, evaluation.Properties)
+		result, err := EvaluateFormula(feature.Prerequisite, env)
+		if err != nil {
+			return fmt.Errorf("%s prerequisite: %w", name, err)
+		}
+		if holds, _ := result.(bool); !holds {
+			return featureRuleError("%s requires %s", name, feature.Prerequisite)
+		}
+	}
+
+	for _, other := range active {
+		if s.excludes(name, other) {
+			return featureRuleError("%s can't be taken with %s", name, other)
+		}
+	}
+
+	for _, groupName := range slices.Sorted(maps.Keys(s.Groups)) {
+		group := s.Groups[groupName]
+		if !slices.Contains(group.Features, name) {
+			continue
+		}
+		var taken []string
+		for _, member := range group.Features {
+			if slices.Contains(active, member) {
+				taken = append(taken, member)
+			}
+		}
+		if len(taken) >= group.Choose {
+			return featureRuleError("%s allows %d, already have %s", groupName, group.Choose, strings.Join(taken, ", "))
+		}
+	}
+	return nil
+}
+
+// Checks that the feature can be removed: it isn't conditional and no other
+// active feature requires it.
+func (s *Schema) CheckRemoveFeature(instance *Instance, name string, opts EvalOptions) error {
+	feature, ok := s.Features[name]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownFeature, name)
+	}
+	if feature.Condition != "" {
+		return featureRuleError("%s turns off by itself unless %s", name, feature.Condition)
+	}
+
+	active, _ := s.activeFeatures(instance, opts)
+	if !slices.Contains(active, name) {
+		return nil
+	}
+	for _, other := range active {
+		if slices.Contains(s.Features[other].Requires, name) {
+			return featureRuleError("%s is required by %s", name, other)
+		}
+	}
+	return nil
+}
+
+// Finds rules no instance can satisfy: requirement cycles, features that
+// (through their requirements) exclude themselves, and requirements that
+// take more of a group than it allows.
+func (s *Schema) validateFeatureRules() error {
+	for _, groupName := range slices.Sorted(maps.Keys(s.Groups)) {
+		group := s.Groups[groupName]
+		if group.Choose < 1 {
+			return fmt.Errorf("feature group %q: choose must be at least 1", groupName)
+		}
+		for _, member := range group.Features {
+			if _, ok := s.Features[member]; !ok {
+				return fmt.Errorf("feature group %q: unknown feature %q", groupName, member)
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(s.Features)) {
+		needed, err := s.requiredFeatures(name, nil)
+		if err != nil {
+			return fmt.Errorf("feature %q: %w", name, err)
+		}
+
+		for i, a := range needed {
+			for _, b := range needed[i:] {
+				if s.excludes(a, b) {
+					if a == b {
+						return fmt.Errorf("feature %q: %s excludes itself", name, a)
+					}
+					return fmt.Errorf("feature %q: needs both %s and %s, which exclude each other", name, a, b)
+				}
+			}
+		}
+
+		for _, groupName := range slices.Sorted(maps.Keys(s.Groups)) {
+			group := s.Groups[groupName]
+			var taken []string
+			for _, member := range needed {
+				if slices.Contains(group.Features, member) {
+					taken = append(taken, member)
+				}
+			}
+			if len(taken) > group.Choose {
+				return fmt.Errorf("feature %q: needs %s but group %q allows %d",
+					name, strings.Join(taken, ", "), groupName, group.Choose)
+			}
+		}
+	}
+	return nil
+}
+
+// The feature and everything it requires, directly or not, sorted.
+func (s *Schema) requiredFeatures(name string, path []string) ([]string, error) {
+	if i := slices.Index(path, name); i >= 0 {
+		return nil, fmt.Errorf("requirements loop: %s", strings.Join(append(path[i:], name), " -> "))
+	}
+	path = append(path, name)
+
+	needed := []string{name}
+	for _, required := range s.Features[name].Requires {
+		more, err := s.requiredFeatures(required, path)
+		if err != nil {
+			return nil, err
+		}
+		needed = append(needed, more...)
+	}
+	slices.Sort(needed)
+	return slices.Compact(needed), nil
+}
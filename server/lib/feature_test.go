@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func featureTestSchema() *Schema {
+	return &Schema{
+		Variables: map[string]Variable{
+			"level": {Type: TypeNumber, Default: 1.0},
+			"class": {Type: TypeString, Default: "wizard"},
+		},
+		Properties: map[string]Property{
+			"tier": {Formula: "level >= 5 ? 2 : 1"},
+		},
+		Features: map[string]Feature{
+			"extra_attack": {Condition: `tier >= 2 && class == "fighter"`, AddsModules: []string{"martial"}},
+			"feat_tough":   {AddsModules: []string{"tough"}},
+			"broken":       {Condition: "level + 1"},
+		},
+		Modules: map[string]Module{
+			"martial": {
+				AddsVariables:  map[string]Variable{"attacks": {Type: TypeNumber, Default: 2.0}},
+				AddsProperties: map[string]Property{"attack_damage": {Formula: "attacks * 6"}},
+			},
+			"tough": {AddsProperties: map[string]Property{"bonus_hp": {Formula: "level * 2"}}},
+		},
+	}
+}
+
+func TestActiveFeatures(t *testing.T) {
+	schema := featureTestSchema()
+	schema.Features["broken"] = Feature{Condition: "level >"}
+	if err := schema.Validate(); err == nil {
+		t.Error("a condition that doesn't parse should be rejected")
+	}
+	schema = featureTestSchema()
+
+	instance := &Instance{
+		ActiveFeatures: []string{"feat_tough", "extra_attack"}, // listing a conditional feature does nothing
+		VariableValues: map[string]any{"level": 3.0, "class": "fighter"},
+	}
+	evaluation := schema.Evaluate(instance)
+	if !slices.Equal(evaluation.ActiveFeatures, []string{"feat_tough"}) {
+		t.Errorf("at level 3: %v", evaluation.ActiveFeatures)
+	}
+	if evaluation.FeatureErrors["broken"] == "" {
+		t.Error("a non-boolean condition should be reported")
+	}
+	if evaluation.Properties["bonus_hp"] != 6.0 {
+		t.Errorf("module property: %v", evaluation.Properties["bonus_hp"])
+	}
+	if _, ok := evaluation.Properties["attack_damage"]; ok {
+		t.Error("inactive module's property evaluated")
+	}
+
+	instance.SetVariable("level", 5.0)
+	evaluation = schema.Evaluate(instance)
+	if !slices.Equal(evaluation.ActiveModules, []string{"martial", "tough"}) {
+		t.Errorf("at level 5: modules %v", evaluation.ActiveModules)
+	}
+	if evaluation.Properties["attack_damage"] != 12.0 {
+		t.Errorf("module default not used: %v", evaluation.Properties["attack_damage"])
+	}
+
+	instance.UpdateActiveFeatures(schema, EvalOptions{})
+	if !slices.Equal(instance.ActiveFeatures, []string{"extra_attack", "feat_tough"}) {
+		t.Errorf("stored features %v", instance.ActiveFeatures)
+	}
+
+	// dropping below the condition deactivates it again
+	instance.SetVariable("level", 4.0)
+	instance.UpdateActiveFeatures(schema, EvalOptions{})
+	if !slices.Equal(instance.ActiveFeatures, []string{"feat_tough"}) || !slices.Equal(instance.ActiveModules, []string{"tough"}) {
+		t.Errorf("after level drop: %v %v", instance.ActiveFeatures, instance.ActiveModules)
+	}
+}
+
+func ruleTestSchema() *Schema {
+	return &Schema{
+		Variables: map[string]Variable{
+			"level":    {Type: TypeNumber, Default: 1.0},
+			"strength": {Type: TypeNumber, Default: 10.0},
+		},
+		Features: map[string]Feature{
+			"power_attack": {Prerequisite: "strength >= 13"},
+			"cleave":       {Requires: []string{"power_attack"}},
+			"archery":      {},
+			"dueling":      {},
+			"defense":      {},
+			"rage":         {Excludes: []string{"spellcasting"}},
+			"spellcasting": {},
+		},
+		Groups: map[string]FeatureGroup{
+			"fighting_style": {Features: []string{"archery", "dueling", "defense"}, Choose: 1},
+		},
+	}
+}
+
+func TestFeatureRules(t *testing.T) {
+	schema := ruleTestSchema()
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	instance := &Instance{VariableValues: map[string]any{}}
+
+	add := func(name string) error { return instance.AddFeature(name, schema, EvalOptions{}) }
+	if err := add("cleave"); !errors.Is(err, ErrFeatureRule) {
+		t.Errorf("cleave without power attack: %v", err)
+	}
+	if err := add("power_attack"); !errors.Is(err, ErrFeatureRule) {
+		t.Errorf("power attack with 10 strength: %v", err)
+	}
+	instance.SetVariable("strength", 14.0)
+	for _, name := range []string{"power_attack", "cleave", "archery", "rage"} {
+		if err := add(name); err != nil {
+			t.Errorf("adding %s: %v", name, err)
+		}
+	}
+	if err := add("dueling"); !errors.Is(err, ErrFeatureRule) {
+		t.Errorf("second fighting style: %v", err)
+	}
+	if err := add("spellcasting"); !errors.Is(err, ErrFeatureRule) {
+		t.Errorf("spellcasting with rage: %v", err)
+	}
+	if err := add("nope"); !errors.Is(err, ErrUnknownFeature) {
+		t.Errorf("unknown feature: %v", err)
+	}
+
+	if err := instance.RemoveFeature("power_attack", schema, EvalOptions{}); !errors.Is(err, ErrFeatureRule) {
+		t.Errorf("removing a feature cleave requires: %v", err)
+	}
+	for _, name := range []string{"cleave", "power_attack", "archery"} {
+		if err := instance.RemoveFeature(name, schema, EvalOptions{}); err != nil {
+			t.Errorf("removing %s: %v", name, err)
+		}
+	}
+	if err := add("dueling"); err != nil {
+		t.Errorf("fighting style after removing archery: %v", err)
+	}
+	if !slices.Equal(instance.ActiveFeatures, []string{"dueling", "rage"}) {
+		t.Errorf("active: %v", instance.ActiveFeatures)
+	}
+}
+
+func TestUnsatisfiableFeatureRules(t *testing.T) {
+	cases := map[string]func(*Schema){
+		"requirement loop": func(s *Schema) {
+			s.Features["power_attack"] = Feature{Requires: []string{"cleave"}}
+		},
+		"requires an excluded feature": func(s *Schema) {
+			s.Features["rage"] = Feature{Requires: []string{"spellcasting"}, Excludes: []string{"spellcasting"}}
+		},
+		"requires too many of a group": func(s *Schema) {
+			s.Features["cleave"] = Feature{Requires: []string{"archery", "defense"}}
+		},
+		"unknown required feature": func(s *Schema) {
+			s.Features["cleave"] = Feature{Requires: []string{"whirlwind"}}
+		},
+		"unknown prerequisite name": func(s *Schema) {
+			s.Features["power_attack"] = Feature{Prerequisite: "dexterity > 13"}
+		},
+		"choose zero": func(s *Schema) {
+			s.Groups["fighting_style"] = FeatureGroup{Features: []string{"archery"}}
+		},
+	}
+	for name, change := range cases {
+		schema := ruleTestSchema()
+		change(schema)
+		if err := schema.Validate(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
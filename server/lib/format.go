@@ -0,0 +1,218 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Schema file formats //
+
+// Schemas can be written as JSON, YAML or TOML. YAML and TOML documents use
+// the same keys as the JSON form; conversion always goes through JSON, so a
+// schema decodes to the same Schema (and validates the same way) whatever
+// format it was written in.
+
+type SchemaFormat string
+
+const (
+	SchemaJSON SchemaFormat = "json"
+	SchemaYAML SchemaFormat = "yaml"
+	SchemaTOML SchemaFormat = "toml"
+)
+
+func (f SchemaFormat) MediaType() string {
+	switch f {
+	case SchemaYAML:
+		return "application/yaml"
+	case SchemaTOML:
+		return "application/toml"
+	default:
+		return "application/json"
+	}
+}
+
+// Parses names like "yaml", "yml", "toml" or "json".
+func ParseSchemaFormat(name string) (SchemaFormat, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "json":
+		return SchemaJSON, nil
+	case "yaml", "yml":
+		return SchemaYAML, nil
+	case "toml":
+		return SchemaTOML, nil
+	}
+	return "", fmt.Errorf("unknown schema format %q", name)
+}
+
+// Format for a file name, from its extension.
+func SchemaFormatForFile(name string) (SchemaFormat, error) {
+	return ParseSchemaFormat(filepath.Ext(name))
+}
+
+// Format for a Content-Type or Accept media type. ok is false for media
+// types that aren't one of the three formats.
+func SchemaFormatForMediaType(mediaType string) (SchemaFormat, bool) {
+	parsed, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return "", false
+	}
+	switch parsed {
+	case "application/json":
+		return SchemaJSON, true
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return SchemaYAML, true
+	case "application/toml", "text/toml":
+		return SchemaTOML, true
+	}
+	return "", false
+}
+
+func MarshalSchema(schema *Schema, format SchemaFormat) ([]byte, error) {
+	data, err := json.MarshalIndent(schema, "", " ")
+	if err != nil {
+		return nil, err
+	}
+	if format == SchemaJSON {
+		return data, nil
+	}
+
+	var doc map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	doc = toDocument(doc).(map[string]any)
+
+	switch format {
+	case SchemaYAML:
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case SchemaTOML:
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(dropNulls(doc)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown schema format %q", format)
+}
+
+func UnmarshalSchema(data []byte, format SchemaFormat) (*Schema, error) {
+	if format != SchemaJSON {
+		var doc any
+		switch format {
+		case SchemaYAML:
+			if err := yaml.Unmarshal(data, &doc); err != nil {
+				return nil, fmt.Errorf("invalid yaml: %w", err)
+			}
+		case SchemaTOML:
+			if _, err := toml.Decode(string(data), &doc); err != nil {
+				return nil, fmt.Errorf("invalid toml: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown schema format %q", format)
+		}
+
+		converted, err := json.Marshal(fromDocument(doc))
+		if err != nil {
+			return nil, err
+		}
+		data = converted
+	}
+
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &schema, nil
+}
+
+// Turns json.Number into int64 or float64 so whole numbers are written as
+// integers (min: 0 rather than min: 0.0).
+func toDocument(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = toDocument(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = toDocument(item)
+		}
+		return v
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return value
+}
+
+// Normalizes decoded YAML/TOML so encoding/json can marshal it: YAML may
+// produce maps with non-string keys.
+func fromDocument(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = fromDocument(item)
+		}
+		return v
+	case map[any]any:
+		converted := make(map[string]any, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = fromDocument(item)
+		}
+		return converted
+	case []any:
+		for i, item := range v {
+			v[i] = fromDocument(item)
+		}
+		return v
+	case []map[string]any:
+		// TOML arrays of tables
+		converted := make([]any, len(v))
+		for i, item := range v {
+			converted[i] = fromDocument(item)
+		}
+		return converted
+	}
+	return value
+}
+
+// TOML has no null; a missing key decodes to the same zero value.
+func dropNulls(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if item == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = dropNulls(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = dropNulls(item)
+		}
+		return v
+	}
+	return value
+}
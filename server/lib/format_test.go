@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func formatTestSchema() *Schema {
+	min, max := 1.0, 20.0
+	return &Schema{
+		ID:          "dnd",
+		Version:     2,
+		UserVersion: 3,
+		Name:        "D&D 5e",
+		Description: "Player characters: \"quoted\" and\nmultiline",
+		Variables: map[string]Variable{
+			"level": {Type: TypeNumber, Default: 1.0, Min: &min, Max: &max},
+			"class": {Type: TypeEnum, Options: []string{"fighter", "wizard"}, Default: "fighter"},
+			"ac":    {Type: TypeNumber, Default: 10.5},
+			"notes": {Type: TypeString, Default: "yes"},
+			"tags":  {Type: TypeArray, Items: &Variable{Type: TypeString}},
+			"alive": {Type: TypeBoolean, Default: true},
+		},
+		DataSources: map[string]DataSource{
+			"dndbeyond": {URL: "https://example.test/{{id}}", Mapping: map[string]string{"level": "character.level"}},
+		},
+		Visualization: Visualization{
+			Name: "Sheet",
+			Type: VisTabs,
+			ChildVisualizations: []Visualization{
+				{Name: "Stats", Type: VisGrid, Config: json.RawMessage(`{"columns":3}`)},
+			},
+		},
+		CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2024, 2, 1, 12, 30, 0, 0, time.UTC),
+	}
+}
+
+func TestSchemaFormatsRoundTrip(t *testing.T) {
+	original := formatTestSchema()
+	want, _ := json.Marshal(original)
+
+	for _, format := range []SchemaFormat{SchemaJSON, SchemaYAML, SchemaTOML} {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := MarshalSchema(original, format)
+			if err != nil {
+				t.Fatalf("marshal failed: %v", err)
+			}
+			t.Logf("%s:\n%s", format, data)
+
+			decoded, err := UnmarshalSchema(data, format)
+			if err != nil {
+				t.Fatalf("unmarshal failed: %v", err)
+			}
+
+			got, _ := json.Marshal(decoded)
+			if string(got) != string(want) {
+				t.Errorf("round trip changed the schema\n got: %s\nwant: %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSchemaFormatsValidateTheSame(t *testing.T) {
+	yamlSchema := `
+_id: broken
+name: Broken
+variables:
+  kills: {type: number}
+data_sources:
+  scores:
+    url: https://example.test
+    mapping:
+      missing: stats.kills
+`
+	tomlSchema := `
+_id = "broken"
+name = "Broken"
+
+[variables.kills]
+type = "number"
+
+[data_sources.scores]
+url = "https://example.test"
+
+[data_sources.scores.mapping]
+missing = "stats.kills"
+`
+
+	for format, text := range map[SchemaFormat]string{SchemaYAML: yamlSchema, SchemaTOML: tomlSchema} {
+		schema, err := UnmarshalSchema([]byte(text), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		err = schema.Validate()
+		if err == nil || !strings.Contains(err.Error(), `unknown variable "missing"`) {
+			t.Errorf("%s: expected the same validation error, got %v", format, err)
+		}
+	}
+}
+
+func TestSchemaFormatForMediaType(t *testing.T) {
+	tests := map[string]SchemaFormat{
+		"application/yaml":                SchemaYAML,
+		"text/x-yaml; charset=utf-8":      SchemaYAML,
+		"application/toml":                SchemaTOML,
+		"application/json; charset=utf-8": SchemaJSON,
+	}
+	for mediaType, want := range tests {
+		if got, ok := SchemaFormatForMediaType(mediaType); !ok || got != want {
+			t.Errorf("%s: got %q", mediaType, got)
+		}
+	}
+	if _, ok := SchemaFormatForMediaType("text/html"); ok {
+		t.Error("text/html should not be a schema format")
+	}
+}
@@ -0,0 +1,378 @@
+package lib
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Formulas //
+
+// Formulas are expr expressions (https://expr-lang.org) over variable and
+// property names, e.g. "floor((strength - 10) / 2)" or
+// "level >= 5 && class == \"fighter\"". Compiled programs are cached by
+// their source since the same schema formulas run for every instance.
+//
+// Besides expr's builtins, formulas can call the functions below.
+var formulaFunctions = []expr.Option{
+	// mean of the numbers in a list, 0 for an empty one
+	expr.Function("avg", func(params ...any) (any, error) {
+		list, ok := params[0].([]any)
+		if !ok {
+			return nil, fmt.Errorf("avg needs a list, got %T", params[0])
+		}
+		if len(list) == 0 {
+			return 0.0, nil
+		}
+		total := 0.0
+		for _, item := range list {
+			number, ok := AsNumber(item)
+			if !ok {
+				return nil, fmt.Errorf("avg needs numbers, got %v", item)
+			}
+			total += number
+		}
+		return total / float64(len(list)), nil
+	}, new(func([]any) float64)),
+
+	// the evaluation's time, replacing expr's own now(). The environment is
+	// passed in by clockPatch, here and in age().
+	expr.Function("now", func(params ...any) (any, error) {
+		return formulaNow(params[0]), nil
+	}, new(func(any) time.Time)),
+
+	// whole years since a date, as of now
+	expr.Function("age", func(params ...any) (any, error) {
+		birth, err := asTime(params[0])
+		if err != nil {
+			return nil, fmt.Errorf("age: %w", err)
+		}
+		return float64(age(birth, formulaNow(params[1]))), nil
+	}, new(func(any, any) float64)),
+
+	expr.Patch(clockPatch{}),
+
+	// calendar days from the first date to the second
+	expr.Function("days_between", func(params ...any) (any, error) {
+		a, err := asTime(params[0])
+		if err != nil {
+			return nil, fmt.Errorf("days_between: %w", err)
+		}
+		b, err := asTime(params[1])
+		if err != nil {
+			return nil, fmt.Errorf("days_between: %w", err)
+		}
+		return float64(daysBetween(a, b)), nil
+	}, new(func(any, any) float64)),
+}
+
+var programCache = struct {
+	sync.Mutex
+	programs map[string]*vm.Program
+}{programs: make(map[string]*vm.Program)}
+
+func CompileFormula(formula string) (*vm.Program, error) {
+	programCache.Lock()
+	program, ok := programCache.programs[formula]
+	programCache.Unlock()
+	if ok {
+		return program, nil
+	}
+
+	program, err := expr.Compile(formula, formulaFunctions...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid formula %q: %w", formula, err)
+	}
+
+	programCache.Lock()
+	programCache.programs[formula] = program
+	programCache.Unlock()
+	return program, nil
+}
+
+func EvaluateFormula(formula string, env map[string]any) (any, error) {
+	program, err := CompileFormula(formula)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := expr.Run(program, env)
+	if err != nil {
+		return nil, fmt.Errorf("formula %q: %w", formula, err)
+	}
+	return result, nil
+}
+
+// Names the formula reads from its environment, sorted. Function names and
+// names declared with let are left out.
+func FormulaIdentifiers(formula string) ([]string, error) {
+	tree, err := parser.Parse(formula)
+	if err != nil {
+		return nil, fmt.Errorf("invalid formula %q: %w", formula, err)
+	}
+
+	collector := &identifierCollector{
+		callees:  make(map[*ast.IdentifierNode]bool),
+		declared: make(map[string]bool),
+	}
+	ast.Walk(&tree.Node, collector)
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, identifier := range collector.identifiers {
+		name := identifier.Value
+		if collector.callees[identifier] || collector.declared[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+type identifierCollector struct {
+	identifiers []*ast.IdentifierNode
+	callees     map[*ast.IdentifierNode]bool
+	declared    map[string]bool
+}
+
+func (c *identifierCollector) Visit(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.IdentifierNode:
+		c.identifiers = append(c.identifiers, n)
+	case *ast.CallNode:
+		if callee, ok := n.Callee.(*ast.IdentifierNode); ok {
+			c.callees[callee] = true
+		}
+	case *ast.VariableDeclaratorNode:
+		c.declared[n.Name] = true
+	}
+}
+
+// Applies the property's rounding to a numeric result.
+func (f FormatType) Apply(value any) any {
+	number, ok := AsNumber(value)
+	if !ok {
+		return value
+	}
+	switch f {
+	case FormatFloor:
+		return math.Floor(number)
+	case FormatCeil:
+		return math.Ceil(number)
+	case FormatRound:
+		return math.Round(number)
+	}
+	return number
+}
+
+// Evaluation //
+
+// An instance's values with every property computed, including the
+// variables and properties of its active modules.
+type Evaluation struct {
+	Variables      map[string]any            `json:"variables"`
+	Properties     map[string]any            `json:"properties"`
+	Errors         map[string]string         `json:"errors,omitempty"` // property (or modified variable) -> error
+	ActiveFeatures []string                  `json:"active_features"`
+	ActiveModules  []string                  `json:"active_modules"`
+	FeatureErrors  map[string]string         `json:"feature_errors,omitempty"` // feature -> condition error
+	Breakdown      map[string][]Contribution `json:"breakdown,omitempty"`      // property -> where its value came from
+	Rendered       map[string]string         `json:"rendered,omitempty"`       // markdown variable -> sanitized HTML
+}
+
+// Variable values with schema defaults filled in for anything unset.
+// Values the schema doesn't declare are kept.
+func (s *Schema) ResolveVariables(instance *Instance) map[string]any {
+	return resolveValues(s.Variables, instance)
+}
+
+func resolveValues(variables map[string]Variable, instance *Instance) map[string]any {
+	values := make(map[string]any, len(variables)+len(instance.VariableValues))
+	for name, variable := range variables {
+		values[name] = variable.Default
+	}
+	for name, value := range instance.VariableValues {
+		if value != nil || values[name] == nil {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// Computes every property of the instance. A property that fails (bad
+// formula, missing value, dependency cycle) is reported in Errors and its
+// value is nil; it doesn't stop the others.
+func (s *Schema) Evaluate(instance *Instance) *Evaluation {
+	return s.EvaluateWith(instance, EvalOptions{})
+}
+
+// What evaluation needs from outside the instance. The zero value evaluates
+// the instance on its own.
+type EvalOptions struct {
+	// Finds referenced instances so formulas can read their values (see
+	// linkReferences). Without it references stay plain IDs.
+	Lookup InstanceLookup
+	// The current time for now() and age(). time.Now when unset.
+	Now func() time.Time
+}
+
+// The values formulas see: temporal values parsed, references linked when
+// there is a lookup, and the current time.
+func (o EvalOptions) env(values map[string]any, variables map[string]Variable) map[string]any {
+	env := temporalValues(values, variables)
+	if o.Lookup != nil {
+		env = linkReferences(env, variables, o)
+	}
+	env[clockKey] = o.now()
+	return env
+}
+
+func (o EvalOptions) now() time.Time {
+	if o.Now == nil {
+		return time.Now()
+	}
+	return o.Now()
+}
+
+// Evaluate, with the given options.
+func (s *Schema) EvaluateWith(instance *Instance, opts EvalOptions) *Evaluation {
+	features, featureErrors := s.activeFeatures(instance, opts)
+	modules := s.GetActiveModules(features)
+	if features == nil {
+		features = []string{}
+	}
+
+	variables := s.GetAllVariables(modules)
+	values := resolveValues(variables, instance)
+	modifierErrors := s.modifyVariables(values, modules)
+
+	env := opts.env(values, variables)
+	bonuses := s.activeBonuses(features, modules, instance)
+	evaluation := evaluateProperties(env, s.GetAllProperties(modules), bonuses)
+	evaluation.Variables = values
+	if len(modifierErrors) > 0 {
+		if evaluation.Errors == nil {
+			evaluation.Errors = make(map[string]string)
+		}
+		maps.Copy(evaluation.Errors, modifierErrors)
+	}
+	evaluation.ActiveFeatures = features
+	evaluation.ActiveModules = modules
+	evaluation.FeatureErrors = featureErrors
+	evaluation.Rendered = renderMarkdownValues(values, variables, opts.Lookup)
+	return evaluation
+}
+
+// Bonuses are added to the value of their property before it's formatted
+// (see stackBonuses). They may use other properties, which are computed
+// first.
+func evaluateProperties(variables map[string]any, properties map[string]Property, bonuses map[string][]sourcedBonus) *Evaluation {
+	evaluation := &Evaluation{
+		Variables:  variables,
+		Properties: make(map[string]any, len(properties)),
+		Errors:     make(map[string]string),
+		Breakdown:  make(map[string][]Contribution),
+	}
+
+	env := maps.Clone(variables)
+	order, cyclic := propertyOrder(withBonusDependencies(properties, bonuses))
+	for _, name := range cyclic {
+		evaluation.Properties[name] = nil
+		evaluation.Errors[name] = "property depends on itself"
+	}
+
+	for _, name := range order {
+		property := properties[name]
+		value, err := EvaluateFormula(property.Formula, env)
+		if err != nil {
+			evaluation.Properties[name] = nil
+			evaluation.Errors[name] = err.Error()
+			env[name] = nil
+			continue
+		}
+		if len(bonuses[name]) > 0 {
+			var breakdown []Contribution
+			value, breakdown, err = stackBonuses(value, bonuses[name], env)
+			if err != nil {
+				evaluation.Properties[name] = nil
+				evaluation.Errors[name] = err.Error()
+				env[name] = nil
+				continue
+			}
+			evaluation.Breakdown[name] = breakdown
+		}
+		value = property.Format.Apply(value)
+		evaluation.Properties[name] = exportTemporal(value)
+		env[name] = value
+	}
+
+	if len(evaluation.Errors) == 0 {
+		evaluation.Errors = nil
+	}
+	if len(evaluation.Breakdown) == 0 {
+		evaluation.Breakdown = nil
+	}
+	return evaluation
+}
+
+// Sorts properties so each comes after the properties its formula uses.
+// Properties in (or depending on) a cycle, and ones with formulas that don't
+// parse, are returned separately; unparsable ones are left in order so the
+// evaluation reports their syntax error.
+func propertyOrder(properties map[string]Property) (order []string, cyclic []string) {
+	deps := make(map[string][]string, len(properties))
+	for name, property := range properties {
+		identifiers, _ := FormulaIdentifiers(property.Formula)
+		for _, identifier := range identifiers {
+			if _, ok := properties[identifier]; ok {
+				deps[name] = append(deps[name], identifier)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+		failed
+	)
+	state := make(map[string]int, len(properties))
+
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		switch state[name] {
+		case done:
+			return true
+		case visiting, failed:
+			return false
+		}
+
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if !visit(dep) {
+				state[name] = failed
+				cyclic = append(cyclic, name)
+				return false
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+		return true
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(properties)) {
+		visit(name)
+	}
+	slices.Sort(cyclic)
+	return order, cyclic
+}
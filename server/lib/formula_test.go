@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFormulaIdentifiers(t *testing.T) {
+	tests := []struct {
+		formula string
+		want    []string
+	}{
+		{"floor((strength - 10) / 2)", []string{"strength"}},
+		{"min(hit_dice + level / 2, level)", []string{"hit_dice", "level"}},
+		{`level >= 5 && class == "fighter"`, []string{"class", "level"}},
+		{"let bonus = 2; bonus * proficiency", []string{"proficiency"}},
+		{"filter(tags, # == 'a')", []string{"tags"}},
+	}
+
+	for _, test := range tests {
+		got, err := FormulaIdentifiers(test.formula)
+		if err != nil {
+			t.Errorf("%s: %v", test.formula, err)
+			continue
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.formula, got, test.want)
+		}
+	}
+
+	if _, err := FormulaIdentifiers("1 +"); err == nil {
+		t.Error("expected an error for an incomplete formula")
+	}
+}
+
+func TestSchemaEvaluate(t *testing.T) {
+	schema := &Schema{
+		Variables: map[string]Variable{
+			"strength": {Type: TypeNumber, Default: 10.0},
+			"level":    {Type: TypeNumber, Default: 1.0},
+		},
+		Properties: map[string]Property{
+			// defined out of order on purpose
+			"attack":      {Formula: "modifier + proficiency"},
+			"modifier":    {Formula: "(strength - 10) / 2", Format: FormatFloor},
+			"proficiency": {Formula: "2 + (level - 1) / 4", Format: FormatFloor},
+			"loop_a":      {Formula: "loop_b + 1"},
+			"loop_b":      {Formula: "loop_a + 1"},
+			"broken":      {Formula: "missing * 2"},
+		},
+	}
+	instance := &Instance{VariableValues: map[string]any{"strength": 15.0, "level": 5.0}}
+
+	evaluation := schema.Evaluate(instance)
+
+	for name, want := range map[string]float64{"modifier": 2, "proficiency": 3, "attack": 5} {
+		if got := evaluation.Properties[name]; got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	for _, name := range []string{"loop_a", "loop_b", "broken"} {
+		if evaluation.Properties[name] != nil || evaluation.Errors[name] == "" {
+			t.Errorf("%s: expected an error, got %v", name, evaluation.Properties[name])
+		}
+	}
+
+	// defaults fill in unset variables
+	evaluation = schema.Evaluate(&Instance{})
+	if got := evaluation.Properties["attack"]; got != 2.0 {
+		t.Errorf("attack with defaults: got %v, want 2", got)
+	}
+}
+
+func TestSchemaValidateProperties(t *testing.T) {
+	base := func() *Schema {
+		return &Schema{
+			Variables:  map[string]Variable{"level": {Type: TypeNumber}},
+			Properties: map[string]Property{"double": {Formula: "level * 2"}},
+		}
+	}
+	if err := base().Validate(); err != nil {
+		t.Fatalf("valid schema rejected: %v", err)
+	}
+
+	tests := map[string]struct {
+		edit func(*Schema)
+		want string
+	}{
+		"syntax":  {func(s *Schema) { s.Properties["bad"] = Property{Formula: "level +"} }, "invalid formula"},
+		"unknown": {func(s *Schema) { s.Properties["bad"] = Property{Formula: "strength"} }, "unknown name"},
+		"cycle":   {func(s *Schema) { s.Properties["bad"] = Property{Formula: "bad + 1"} }, "depends on itself"},
+		"clash":   {func(s *Schema) { s.Properties["level"] = Property{Formula: "1"} }, "same name"},
+	}
+	for name, test := range tests {
+		schema := base()
+		test.edit(schema)
+		err := schema.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error containing %q", name, err, test.want)
+		}
+	}
+}
+
+func TestFormulaFunctions(t *testing.T) {
+	result, err := EvaluateFormula("avg(scores)", map[string]any{"scores": []any{2.0, 4, 9.0}})
+	if err != nil || result != 5.0 {
+		t.Errorf("avg: %v %v", result, err)
+	}
+	if _, err := EvaluateFormula("avg(name)", map[string]any{"name": "x"}); err == nil {
+		t.Error("avg of a string should fail")
+	}
+}
@@ -0,0 +1,262 @@
+package lib
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Family graphs //
+
+// Reference variables marked as links make a family tree: a "parent" link
+// points from a child to a parent (mother, father), a "child" link from a
+// parent to a child (children). Other references (spouse) are edges too but
+// only count for relationship paths.
+
+type LinkKind string
+
+const (
+	LinkParent LinkKind = "parent"
+	LinkChild  LinkKind = "child"
+)
+
+// Node/edge JSON for tree rendering. Depth is the number of generations (or,
+// for paths, steps) from the instance the query started at.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	SchemaID string `json:"schema_id"`
+	Depth    int    `json:"depth"`
+}
+
+// Parent edges always run from the parent to the child, whichever side
+// holds the reference. Other edges run from the instance holding the
+// reference.
+type GraphEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Kind     string `json:"kind"`     // "parent" or "reference"
+	Variable string `json:"variable"` // reference variable the edge comes from
+}
+
+const (
+	edgeParent    = "parent"
+	edgeReference = "reference"
+)
+
+type FamilyGraph struct {
+	nodes    map[string]GraphNode
+	edges    []GraphEdge
+	parents  map[string][]string // child -> parents
+	children map[string][]string // parent -> children
+	adjacent map[string][]int    // node -> edges touching it
+}
+
+// Builds the graph from every instance's references. References to
+// instances that aren't given are left out.
+func NewFamilyGraph(instances []Instance, schemas map[string]Schema) *FamilyGraph {
+	g := &FamilyGraph{
+		nodes:    make(map[string]GraphNode, len(instances)),
+		parents:  make(map[string][]string),
+		children: make(map[string][]string),
+		adjacent: make(map[string][]int),
+	}
+	for _, instance := range instances {
+		g.nodes[instance.ID] = GraphNode{ID: instance.ID, Name: instance.Name, SchemaID: instance.SchemaID}
+	}
+
+	instances = slices.SortedFunc(slices.Values(instances), func(a, b Instance) int { return cmp.Compare(a.ID, b.ID) })
+	seen := make(map[[2]string]bool)
+	for _, instance := range instances {
+		schema, ok := schemas[instance.SchemaID]
+		if !ok {
+			continue
+		}
+		for _, reference := range schema.References(&instance) {
+			if _, ok := g.nodes[reference.Target]; !ok {
+				continue
+			}
+			edge := GraphEdge{From: instance.ID, To: reference.Target, Kind: edgeReference, Variable: reference.Variable}
+			switch reference.Link {
+			case LinkParent:
+				edge.From, edge.To, edge.Kind = reference.Target, instance.ID, edgeParent
+			case LinkChild:
+				edge.Kind = edgeParent
+			}
+			if edge.Kind == edgeParent {
+				// recorded on both sides counts once
+				if seen[[2]string{edge.From, edge.To}] {
+					continue
+				}
+				seen[[2]string{edge.From, edge.To}] = true
+				g.parents[edge.To] = append(g.parents[edge.To], edge.From)
+				g.children[edge.From] = append(g.children[edge.From], edge.To)
+			}
+			g.adjacent[edge.From] = append(g.adjacent[edge.From], len(g.edges))
+			g.adjacent[edge.To] = append(g.adjacent[edge.To], len(g.edges))
+			g.edges = append(g.edges, edge)
+		}
+	}
+	return g
+}
+
+func (g *FamilyGraph) Has(id string) bool {
+	_, ok := g.nodes[id]
+	return ok
+}
+
+// The instance and its ancestors up to depth generations back (all of them
+// when depth is 0 or less).
+func (g *FamilyGraph) Ancestors(id string, depth int) Graph {
+	return g.walk(id, depth, g.parents)
+}
+
+// The instance and its descendants up to depth generations down.
+func (g *FamilyGraph) Descendants(id string, depth int) Graph {
+	return g.walk(id, depth, g.children)
+}
+
+func (g *FamilyGraph) walk(id string, depth int, next map[string][]string) Graph {
+	graph := Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	if !g.Has(id) {
+		return graph
+	}
+
+	depths := map[string]int{id: 0}
+	queue := []string{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if depth > 0 && depths[current] >= depth {
+			continue
+		}
+		for _, other := range next[current] {
+			if _, ok := depths[other]; !ok {
+				depths[other] = depths[current] + 1
+				queue = append(queue, other)
+			}
+		}
+	}
+
+	for _, nodeID := range slices.Sorted(maps.Keys(depths)) {
+		node := g.nodes[nodeID]
+		node.Depth = depths[nodeID]
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	slices.SortStableFunc(graph.Nodes, func(a, b GraphNode) int { return cmp.Compare(a.Depth, b.Depth) })
+	for _, edge := range g.edges {
+		_, from := depths[edge.From]
+		_, to := depths[edge.To]
+		if edge.Kind == edgeParent && from && to {
+			graph.Edges = append(graph.Edges, edge)
+		}
+	}
+	return graph
+}
+
+// The shortest chain of references between two instances, following edges
+// either way. ok is false when they aren't connected.
+func (g *FamilyGraph) Path(from, to string) (Graph, bool) {
+	graph := Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	if !g.Has(from) || !g.Has(to) {
+		return graph, false
+	}
+
+	via := map[string]int{from: -1} // node -> edge it was reached by
+	queue := []string{from}
+	for len(queue) > 0 {
+		if _, ok := via[to]; ok {
+			break
+		}
+		current := queue[0]
+		queue = queue[1:]
+		for _, index := range g.adjacent[current] {
+			edge := g.edges[index]
+			other := edge.To
+			if other == current {
+				other = edge.From
+			}
+			if _, ok := via[other]; !ok {
+				via[other] = index
+				queue = append(queue, other)
+			}
+		}
+	}
+	if _, ok := via[to]; !ok {
+		return graph, false
+	}
+
+	var steps []string
+	for current := to; ; {
+		steps = append(steps, current)
+		index := via[current]
+		if index < 0 {
+			break
+		}
+		edge := g.edges[index]
+		graph.Edges = append(graph.Edges, edge)
+		if edge.To == current {
+			current = edge.From
+		} else {
+			current = edge.To
+		}
+	}
+	slices.Reverse(steps)
+	slices.Reverse(graph.Edges)
+	for i, id := range steps {
+		node := g.nodes[id]
+		node.Depth = i
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	return graph, true
+}
+
+// Loops in the family tree, where someone ends up their own ancestor. Each
+// cycle lists the instances from parent to child, starting with the smallest
+// ID; cycles are sorted.
+func (g *FamilyGraph) Cycles() [][]string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(g.nodes))
+	var stack []string
+	found := make(map[string][]string)
+
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		stack = append(stack, id)
+		for _, child := range g.children[id] {
+			switch state[child] {
+			case unvisited:
+				visit(child)
+			case visiting:
+				cycle := slices.Clone(stack[slices.Index(stack, child):])
+				start := slices.Index(cycle, slices.Min(cycle))
+				cycle = slices.Concat(cycle[start:], cycle[:start])
+				found[strings.Join(cycle, "\x00")] = cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = done
+	}
+	for _, id := range slices.Sorted(maps.Keys(g.nodes)) {
+		if state[id] == unvisited {
+			visit(id)
+		}
+	}
+
+	cycles := [][]string{}
+	for _, key := range slices.Sorted(maps.Keys(found)) {
+		cycles = append(cycles, found[key])
+	}
+	return cycles
+}
@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"slices"
+	"testing"
+)
+
+func familyTestGraph() *FamilyGraph {
+	person := Schema{Variables: map[string]Variable{
+		"mother":   {Type: TypeReference, Link: LinkParent},
+		"father":   {Type: TypeReference, Link: LinkParent},
+		"children": {Type: TypeArray, Items: &Variable{Type: TypeReference}, Link: LinkChild},
+		"spouse":   {Type: TypeReference},
+	}}
+	instances := []Instance{
+		{ID: "grandma", VariableValues: map[string]any{"children": []any{"mum"}}},
+		{ID: "mum", VariableValues: map[string]any{"mother": "grandma", "spouse": "dad"}},
+		{ID: "dad"},
+		{ID: "kid", VariableValues: map[string]any{"mother": "mum", "father": "dad"}},
+		{ID: "inlaw", VariableValues: map[string]any{"children": []any{"dad"}}},
+	}
+	for i := range instances {
+		instances[i].SchemaID = "person"
+		instances[i].Name = instances[i].ID
+	}
+	return NewFamilyGraph(instances, map[string]Schema{"person": person})
+}
+
+func nodeIDs(graph Graph) []string {
+	var ids []string
+	for _, node := range graph.Nodes {
+		ids = append(ids, node.ID)
+	}
+	return ids
+}
+
+func TestFamilyGraph(t *testing.T) {
+	g := familyTestGraph()
+
+	ancestors := g.Ancestors("kid", 0)
+	if !slices.Equal(nodeIDs(ancestors), []string{"kid", "dad", "mum", "grandma", "inlaw"}) {
+		t.Errorf("ancestors: %v", nodeIDs(ancestors))
+	}
+	if len(ancestors.Edges) != 4 {
+		t.Errorf("ancestor edges: %v", ancestors.Edges)
+	}
+	if got := nodeIDs(g.Ancestors("kid", 1)); !slices.Equal(got, []string{"kid", "dad", "mum"}) {
+		t.Errorf("parents only: %v", got)
+	}
+	if got := nodeIDs(g.Descendants("grandma", 0)); !slices.Equal(got, []string{"grandma", "mum", "kid"}) {
+		t.Errorf("descendants: %v", got)
+	}
+
+	path, ok := g.Path("grandma", "inlaw")
+	if !ok || !slices.Equal(nodeIDs(path), []string{"grandma", "mum", "dad", "inlaw"}) {
+		t.Errorf("path: %v %v", nodeIDs(path), ok)
+	}
+	if path.Edges[1].Variable != "spouse" {
+		t.Errorf("path edges: %v", path.Edges)
+	}
+	if _, ok := g.Path("grandma", "nobody"); ok {
+		t.Error("path to an unknown instance")
+	}
+
+	if cycles := g.Cycles(); len(cycles) != 0 {
+		t.Errorf("no cycles expected: %v", cycles)
+	}
+	looped := NewFamilyGraph([]Instance{
+		{ID: "b", SchemaID: "p", VariableValues: map[string]any{"parent": "a"}},
+		{ID: "a", SchemaID: "p", VariableValues: map[string]any{"parent": "c"}},
+		{ID: "c", SchemaID: "p", VariableValues: map[string]any{"parent": "b"}},
+	}, map[string]Schema{"p": {Variables: map[string]Variable{"parent": {Type: TypeReference, Link: LinkParent}}}})
+	if cycles := looped.Cycles(); len(cycles) != 1 || !slices.Equal(cycles[0], []string{"a", "b", "c"}) {
+		t.Errorf("cycles: %v", cycles)
+	}
+}
@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Groups //
+
+// A set of instances, possibly of different schemas, like a party, a team
+// roster or a household. A group has variables of its own (party gold, team
+// score) and properties computed from them and from its members. In
+// formulas, members.<name> is the list of the members' values of a variable
+// or property, skipping members that don't have it:
+// "sum(members.level)", "avg(members.kills)", "len(members.level)".
+type Group struct {
+	ID             string              `json:"_id"`
+	Name           string              `json:"name"`
+	Description    string              `json:"description,omitempty"`
+	Members        []string            `json:"members"` // instance IDs
+	Variables      map[string]Variable `json:"variables,omitempty"`
+	VariableValues map[string]any      `json:"variable_values,omitempty"`
+	Properties     map[string]Property `json:"properties,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+}
+
+const membersName = "members"
+
+func (g *Group) Validate() error {
+	if _, ok := g.Variables[membersName]; ok {
+		return fmt.Errorf("variable %q: the name is reserved for the members", membersName)
+	}
+	for _, name := range slices.Sorted(maps.Keys(g.Properties)) {
+		if name == membersName {
+			return fmt.Errorf("property %q: the name is reserved for the members", membersName)
+		}
+		if _, ok := g.Variables[name]; ok {
+			return fmt.Errorf("property %q has the same name as a variable", name)
+		}
+		err := checkFormulaNames(g.Properties[name].Formula, func(identifier string) bool {
+			_, isVariable := g.Variables[identifier]
+			_, isProperty := g.Properties[identifier]
+			return isVariable || isProperty || identifier == membersName
+		})
+		if err != nil {
+			return fmt.Errorf("property %q: %w", name, err)
+		}
+	}
+	if _, cyclic := propertyOrder(g.Properties); len(cyclic) > 0 {
+		return fmt.Errorf("property %q depends on itself", cyclic[0])
+	}
+	for _, name := range slices.Sorted(maps.Keys(g.VariableValues)) {
+		variable, ok := g.Variables[name]
+		if !ok {
+			return fmt.Errorf("value for unknown variable %q", name)
+		}
+		if _, err := variable.Coerce(g.VariableValues[name]); err != nil {
+			return fmt.Errorf("variable %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Sets one of the group's variables, converting the value to its type.
+func (g *Group) SetVariable(name string, value any) error {
+	variable, ok := g.Variables[name]
+	if !ok {
+		return fmt.Errorf("unknown variable %q", name)
+	}
+	value, err := variable.Coerce(value)
+	if err != nil {
+		return fmt.Errorf("variable %q: %w", name, err)
+	}
+	if g.VariableValues == nil {
+		g.VariableValues = make(map[string]any)
+	}
+	g.VariableValues[name] = value
+	return nil
+}
+
+// Computes the group's properties from its variables and its members'
+// evaluations.
+func (g *Group) Evaluate(members []*Evaluation) *Evaluation {
+	lists := make(map[string]any)
+	for _, member := range members {
+		for _, values := range []map[string]any{member.Variables, member.Properties} {
+			for name, value := range values {
+				if value == nil {
+					continue
+				}
+				list, _ := lists[name].([]any)
+				lists[name] = append(list, value)
+			}
+		}
+	}
+
+	variables := resolveValues(g.Variables, &Instance{VariableValues: g.VariableValues})
+	env := temporalValues(variables, g.Variables)
+	env[membersName] = lists
+
+	evaluation := evaluateProperties(env, g.Properties, nil)
+	evaluation.Variables = variables
+	return evaluation
+}
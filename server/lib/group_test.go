@@ -0,0 +1,44 @@
+package lib
+
+import "testing"
+
+func TestGroupEvaluate(t *testing.T) {
+	group := &Group{
+		Variables: map[string]Variable{"gold": {Type: TypeNumber, Default: 0.0}},
+		Properties: map[string]Property{
+			"total_level":   {Formula: "sum(members.level)"},
+			"average_kills": {Formula: "avg(members.kills)"},
+			"share":         {Formula: "gold / len(members.level)"},
+		},
+	}
+	if err := group.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := group.SetVariable("gold", "90"); err != nil {
+		t.Fatal(err)
+	}
+
+	members := []*Evaluation{
+		{Variables: map[string]any{"level": 3.0, "kills": 4.0}},
+		{Variables: map[string]any{"level": 5.0}, Properties: map[string]any{"kills": 8.0}},
+		{Variables: map[string]any{"level": 1.0, "kills": nil}},
+	}
+	evaluation := group.Evaluate(members)
+	want := map[string]any{"total_level": 9.0, "average_kills": 6.0, "share": 30.0}
+	for name, value := range want {
+		if evaluation.Properties[name] != value {
+			t.Errorf("%s = %v, want %v (%v)", name, evaluation.Properties[name], value, evaluation.Errors)
+		}
+	}
+	if _, ok := evaluation.Variables[membersName]; ok {
+		t.Error("members leaked into the variables")
+	}
+
+	group.Properties["broken"] = Property{Formula: "sum(players.level)"}
+	if err := group.Validate(); err == nil {
+		t.Error("unknown name in a group formula should be rejected")
+	}
+	if err := group.SetVariable("silver", 1.0); err == nil {
+		t.Error("setting an unknown variable should fail")
+	}
+}
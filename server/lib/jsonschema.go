@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"maps"
+	"slices"
+)
+
+// JSON Schema //
+
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// The subset of JSON Schema (draft 2020-12) needed to describe variables.
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Default              any                    `json:"default,omitempty"`
+}
+
+// Describes a valid VariableValues object for instances of the schema.
+// id becomes the $id of the document and can be empty.
+func (s *Schema) JSONSchema(id string) *JSONSchema {
+	closed := false
+	doc := &JSONSchema{
+		Schema:               JSONSchemaDialect,
+		ID:                   id,
+		Title:                s.Name,
+		Description:          s.Description,
+		Type:                 "object",
+		Properties:           make(map[string]*JSONSchema, len(s.Variables)),
+		AdditionalProperties: &closed,
+	}
+
+	// module variables are allowed but never required, since the module
+	// may not be active
+	variables, _ := s.declaredFields()
+	for _, name := range slices.Sorted(maps.Keys(variables)) {
+		variable := variables[name]
+		doc.Properties[name] = variable.JSONSchema()
+		if _, base := s.Variables[name]; base && variable.Required {
+			doc.Required = append(doc.Required, name)
+		}
+	}
+
+	return doc
+}
+
+func (v *Variable) JSONSchema() *JSONSchema {
+	doc := &JSONSchema{Default: v.Default}
+
+	switch v.Type {
+	case TypeNumber:
+		doc.Type = "number"
+		doc.Minimum = v.Min
+		doc.Maximum = v.Max
+	case TypeString, TypeMarkdown, TypeReference, TypeAttachment:
+		doc.Type = "string"
+	case TypeDate:
+		doc.Type = "string"
+		doc.Format = "date"
+	case TypeDateTime:
+		doc.Type = "string"
+		doc.Format = "date-time"
+	case TypeDuration:
+		doc.Type = "string"
+	case TypeBoolean:
+		doc.Type = "boolean"
+	case TypeEnum:
+		doc.Type = "string"
+		doc.Enum = v.Options
+	case TypeArray:
+		doc.Type = "array"
+		if v.Items != nil {
+			doc.Items = v.Items.JSONSchema()
+		}
+	}
+
+	return doc
+}
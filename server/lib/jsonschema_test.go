@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSchemaJSONSchema(t *testing.T) {
+	min, max := 1.0, 20.0
+	schema := Schema{
+		Name: "Character",
+		Variables: map[string]Variable{
+			"level": {Type: TypeNumber, Min: &min, Max: &max, Default: 1.0, Required: true},
+			"class": {Type: TypeEnum, Options: []string{"fighter", "wizard"}, Required: true},
+			"name":  {Type: TypeString},
+			"born":  {Type: TypeDate},
+			"spells": {Type: TypeArray, Items: &Variable{
+				Type: TypeEnum, Options: []string{"fireball", "shield"},
+			}},
+		},
+		Modules: map[string]Module{
+			"barbarian": {AddsVariables: map[string]Variable{"rage": {Type: TypeNumber, Required: true}}},
+		},
+	}
+
+	doc := schema.JSONSchema("http://localhost/gm/schemas/character/json-schema")
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]any
+	json.Unmarshal(data, &got)
+
+	if got["$schema"] != JSONSchemaDialect || got["type"] != "object" || got["additionalProperties"] != false {
+		t.Errorf("unexpected document header: %s", data)
+	}
+	if !reflect.DeepEqual(got["required"], []any{"class", "level"}) {
+		t.Errorf("unexpected required list %v", got["required"])
+	}
+
+	properties := got["properties"].(map[string]any)
+	level := properties["level"].(map[string]any)
+	if level["type"] != "number" || level["minimum"] != 1.0 || level["maximum"] != 20.0 || level["default"] != 1.0 {
+		t.Errorf("unexpected level schema %v", level)
+	}
+
+	class := properties["class"].(map[string]any)
+	if class["type"] != "string" || !reflect.DeepEqual(class["enum"], []any{"fighter", "wizard"}) {
+		t.Errorf("unexpected class schema %v", class)
+	}
+
+	if born := properties["born"].(map[string]any); born["type"] != "string" || born["format"] != "date" {
+		t.Errorf("unexpected born schema %v", born)
+	}
+
+	if _, ok := properties["rage"]; !ok {
+		t.Error("module variable missing")
+	}
+	if slices.Contains(got["required"].([]any), any("rage")) {
+		t.Error("module variable required")
+	}
+
+	items := properties["spells"].(map[string]any)["items"].(map[string]any)
+	if !reflect.DeepEqual(items["enum"], []any{"fireball", "shield"}) {
+		t.Errorf("unexpected spells items %v", items)
+	}
+
+	// instances are held to the same requirements
+	instance := &Instance{VariableValues: map[string]any{"level": 3.0}}
+	if err := schema.CheckRequired(instance); err == nil {
+		t.Error("instance without a class accepted")
+	}
+	instance.VariableValues["class"] = "wizard"
+	if err := schema.CheckRequired(instance); err != nil {
+		t.Error(err)
+	}
+}
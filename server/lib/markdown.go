@@ -0,0 +1,131 @@
+package lib
+
+import (
+	"bytes"
+	"cmp"
+	"html"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Markdown //
+
+// A markdown variable holds formatted text: notes, backstories, recipes.
+// Evaluations carry it rendered to HTML that is safe to show as is: raw HTML
+// in the source is dropped and the output is sanitized against an allow list
+// of tags, so no scripts, styles or event handlers get through. [[id]] links
+// to another instance and reads as its name; links to instances that can't
+// be found are left as written.
+
+// Where the client finds an instance; linked instances are also marked with
+// a data-instance attribute.
+const instanceLinkPrefix = "#instances/"
+
+var (
+	markdown = goldmark.New(
+		goldmark.WithExtensions(extension.Table, extension.Strikethrough, extension.Linkify),
+		goldmark.WithParserOptions(parser.WithInlineParsers(util.Prioritized(instanceLinkParser{}, 199))), // before links
+		goldmark.WithRendererOptions(renderer.WithNodeRenderers(util.Prioritized(instanceLinkRenderer{}, 500))),
+	)
+	markdownPolicy = func() *bluemonday.Policy {
+		policy := bluemonday.UGCPolicy()
+		policy.AllowAttrs("data-instance").OnElements("a")
+		return policy
+	}()
+	lookupKey = parser.NewContextKey()
+)
+
+// Renders markdown to sanitized HTML, resolving [[id]] links with lookup
+// (which may be nil).
+func RenderMarkdown(source string, lookup InstanceLookup) string {
+	context := parser.NewContext()
+	if lookup != nil {
+		context.Set(lookupKey, lookup)
+	}
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf, parser.WithContext(context)); err != nil {
+		return html.EscapeString(source)
+	}
+	return markdownPolicy.Sanitize(buf.String())
+}
+
+// The rendered HTML of every markdown variable that has a value.
+func renderMarkdownValues(values map[string]any, variables map[string]Variable, lookup InstanceLookup) map[string]string {
+	var rendered map[string]string
+	for _, name := range slices.Sorted(maps.Keys(variables)) {
+		source, ok := values[name].(string)
+		if variables[name].Type != TypeMarkdown || !ok || source == "" {
+			continue
+		}
+		if rendered == nil {
+			rendered = make(map[string]string)
+		}
+		rendered[name] = RenderMarkdown(source, lookup)
+	}
+	return rendered
+}
+
+var kindInstanceLink = ast.NewNodeKind("InstanceLink")
+
+type instanceLink struct {
+	ast.BaseInline
+	ID   string
+	Name string
+}
+
+func (n *instanceLink) Kind() ast.NodeKind { return kindInstanceLink }
+
+func (n *instanceLink) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"ID": n.ID, "Name": n.Name}, nil)
+}
+
+type instanceLinkParser struct{}
+
+func (instanceLinkParser) Trigger() []byte { return []byte{'['} }
+
+func (instanceLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("[[")) {
+		return nil
+	}
+	end := bytes.Index(line[2:], []byte("]]"))
+	if end < 0 {
+		return nil
+	}
+	id := strings.TrimSpace(string(line[2 : 2+end]))
+	lookup, _ := pc.Get(lookupKey).(InstanceLookup)
+	if id == "" || strings.ContainsAny(id, "[]") || lookup == nil {
+		return nil
+	}
+	instance, _, err := lookup(id)
+	if err != nil {
+		return nil
+	}
+	block.Advance(end + 4)
+	return &instanceLink{ID: id, Name: cmp.Or(instance.Name, id)}
+}
+
+type instanceLinkRenderer struct{}
+
+func (instanceLinkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindInstanceLink, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			link := node.(*instanceLink)
+			w.WriteString(`<a href="` + html.EscapeString(instanceLinkPrefix+url.PathEscape(link.ID)) + `" data-instance="` + html.EscapeString(link.ID) + `">`)
+			w.WriteString(html.EscapeString(link.Name))
+			w.WriteString("</a>")
+		}
+		return ast.WalkContinue, nil
+	})
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	lookup := func(id string) (*Instance, *Schema, error) {
+		if id == "ada" {
+			return &Instance{ID: "ada", Name: "Ada <Lovelace>"}, &Schema{}, nil
+		}
+		return nil, nil, errors.New("entry not found")
+	}
+
+	tests := []struct {
+		source   string
+		contains []string
+		excludes []string
+	}{
+		{"**Bold** and _soft_", []string{"<strong>Bold</strong>", "<em>soft</em>"}, nil},
+		{"| a | b |\n|---|---|\n| 1 | 2 |", []string{"<table>", "<td>1</td>"}, nil},
+		{"<script>alert(1)</script>\n\nhi", []string{"hi"}, []string{"<script", "alert(1)</script>"}},
+		{`<img src=x onerror="alert(1)">`, nil, []string{"onerror", "<img"}},
+		{"[click](javascript:alert(1))", []string{"click"}, []string{"javascript:"}},
+		{"Married [[ada]] in 1835.", []string{`<a href="#instances/ada" data-instance="ada" rel="nofollow">Ada &lt;Lovelace&gt;</a>`}, nil},
+		{"Lost cousin [[bob]].", []string{"[[bob]]"}, []string{"<a"}},
+		{"`[[ada]]` in code", []string{"<code>[[ada]]</code>"}, []string{"<a"}},
+	}
+	for _, test := range tests {
+		rendered := RenderMarkdown(test.source, lookup)
+		for _, want := range test.contains {
+			if !strings.Contains(rendered, want) {
+				t.Errorf("%q: %q doesn't contain %q", test.source, rendered, want)
+			}
+		}
+		for _, unwanted := range test.excludes {
+			if strings.Contains(rendered, unwanted) {
+				t.Errorf("%q: %q contains %q", test.source, rendered, unwanted)
+			}
+		}
+	}
+
+	if rendered := RenderMarkdown("see [[ada]]", nil); strings.Contains(rendered, "<a") {
+		t.Errorf("link without a lookup: %q", rendered)
+	}
+
+	schema := &Schema{Variables: map[string]Variable{
+		"backstory": {Type: TypeMarkdown},
+		"notes":     {Type: TypeMarkdown},
+		"name":      {Type: TypeString},
+	}}
+	evaluation := schema.EvaluateWith(&Instance{VariableValues: map[string]any{"backstory": "# Early life\nRaised by [[ada]].", "name": "*x*"}}, EvalOptions{Lookup: lookup})
+	if len(evaluation.Rendered) != 1 || !strings.Contains(evaluation.Rendered["backstory"], "<h1") || !strings.Contains(evaluation.Rendered["backstory"], ">Ada &lt;Lovelace&gt;</a>") {
+		t.Errorf("rendered: %v", evaluation.Rendered)
+	}
+	if evaluation.Variables["backstory"] != "# Early life\nRaised by [[ada]]." {
+		t.Errorf("raw value: %v", evaluation.Variables["backstory"])
+	}
+}
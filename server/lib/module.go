@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Module merging //
+
+// The modules in the order they're merged: by priority, then by name. Unknown
+// names are dropped.
+func (s *Schema) moduleOrder(modules []string) []string {
+	order := make([]string, 0, len(modules))
+	for _, name := range modules {
+		if _, ok := s.Modules[name]; ok {
+			order = append(order, name)
+		}
+	}
+	slices.SortFunc(order, func(a, b string) int {
+		return cmp.Or(cmp.Compare(s.Modules[a].Priority, s.Modules[b].Priority), cmp.Compare(a, b))
+	})
+	return slices.Compact(order)
+}
+
+// Wraps the formula giving the current value in the modifier.
+func (m Modifier) apply(formula string) string {
+	switch m.Op {
+	case ModifierMultiply:
+		return fmt.Sprintf("(%s) * (%s)", formula, m.Formula)
+	case ModifierMin:
+		return fmt.Sprintf("min(%s, %s)", formula, m.Formula)
+	case ModifierMax:
+		return fmt.Sprintf("max(%s, %s)", formula, m.Formula)
+	}
+	return fmt.Sprintf("(%s) + (%s)", formula, m.Formula)
+}
+
+// Applies the active modules' modifiers on variables to the resolved values.
+// A modifier that fails leaves the value as it was and is reported by
+// variable name.
+func (s *Schema) modifyVariables(values map[string]any, activeModules []string) map[string]string {
+	failures := make(map[string]string)
+	for _, moduleName := range s.moduleOrder(activeModules) {
+		modifies := s.Modules[moduleName].Modifies
+		for _, name := range slices.Sorted(maps.Keys(modifies)) {
+			if _, ok := values[name]; !ok {
+				continue // a property, or a variable of a module that isn't active
+			}
+			value, err := EvaluateFormula(modifies[name].apply(name), values)
+			if err != nil {
+				failures[name] = fmt.Sprintf("module %s: %v", moduleName, err)
+				continue
+			}
+			values[name] = value
+		}
+	}
+	return failures
+}
+
+// Every variable and property the schema or any of its modules declares,
+// active or not. Formulas and configs are validated against these.
+func (s *Schema) declaredFields() (map[string]Variable, map[string]Property) {
+	variables := make(map[string]Variable, len(s.Variables))
+	properties := make(map[string]Property, len(s.Properties))
+	maps.Copy(variables, s.Variables)
+	maps.Copy(properties, s.Properties)
+	for _, name := range s.moduleOrder(slices.Collect(maps.Keys(s.Modules))) {
+		maps.Copy(variables, s.Modules[name].AddsVariables)
+		maps.Copy(properties, s.Modules[name].AddsProperties)
+	}
+	return variables, properties
+}
+
+// Checks that modules only replace what they say they override, that
+// overrides have a winner (a higher priority), and that modifiers target
+// something that exists.
+func (s *Schema) validateModules() error {
+	variables, properties := s.declaredFields()
+	hasName := func(name string) bool {
+		_, isVariable := variables[name]
+		_, isProperty := properties[name]
+		return isVariable || isProperty
+	}
+
+	definedBy := make(map[string]string) // name -> module, "" for the schema
+	for name := range variables {
+		if _, ok := s.Variables[name]; ok {
+			definedBy[name] = ""
+		}
+	}
+	for name := range properties {
+		if _, ok := s.Properties[name]; ok {
+			definedBy[name] = ""
+		}
+	}
+
+	for _, moduleName := range s.moduleOrder(slices.Collect(maps.Keys(s.Modules))) {
+		module := s.Modules[moduleName]
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("module %q: %s", moduleName, fmt.Sprintf(format, args...))
+		}
+
+		added := slices.Concat(slices.Collect(maps.Keys(module.AddsVariables)), slices.Collect(maps.Keys(module.AddsProperties)))
+		slices.Sort(added)
+		for _, name := range added {
+			_, addsVariable := module.AddsVariables[name]
+			_, addsProperty := module.AddsProperties[name]
+			if addsVariable && addsProperty {
+				return fail("%q is both a variable and a property", name)
+			}
+			if _, ok := properties[name]; ok && addsVariable {
+				return fail("variable %q has the same name as a property", name)
+			}
+			if _, ok := variables[name]; ok && addsProperty {
+				return fail("property %q has the same name as a variable", name)
+			}
+
+			owner, defined := definedBy[name]
+			overrides := slices.Contains(module.Overrides, name)
+			switch {
+			case defined && !overrides && owner == "":
+				return fail("%q is already defined by the schema; list it in overrides to replace it", name)
+			case defined && !overrides:
+				return fail("%q is already defined by module %q; list it in overrides to replace it", name, owner)
+			case defined && owner != "" && s.Modules[owner].Priority == module.Priority:
+				return fail("overrides %q from module %q, which has the same priority", name, owner)
+			}
+			definedBy[name] = moduleName
+		}
+
+		for _, name := range module.Overrides {
+			_, addsVariable := module.AddsVariables[name]
+			_, addsProperty := module.AddsProperties[name]
+			if !addsVariable && !addsProperty {
+				return fail("overrides %q without adding it", name)
+			}
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(module.AddsProperties)) {
+			if err := checkFormulaNames(module.AddsProperties[name].Formula, hasName); err != nil {
+				return fail("property %q: %v", name, err)
+			}
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(module.Modifies)) {
+			modifier := module.Modifies[name]
+			switch modifier.Op {
+			case "", ModifierAdd, ModifierMultiply, ModifierMin, ModifierMax:
+			default:
+				return fail("modifier on %q: unknown op %q", name, modifier.Op)
+			}
+
+			check := hasName
+			if _, ok := variables[name]; ok {
+				check = func(identifier string) bool {
+					_, ok := variables[identifier]
+					return ok
+				}
+			} else if _, ok := properties[name]; !ok {
+				return fail("modifies unknown name %q", name)
+			}
+			if err := checkFormulaNames(modifier.Formula, check); err != nil {
+				return fail("modifier on %q: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkFormulaNames(formula string, known func(string) bool) error {
+	identifiers, err := FormulaIdentifiers(formula)
+	if err != nil {
+		return err
+	}
+	for _, identifier := range identifiers {
+		if !known(identifier) {
+			return fmt.Errorf("unknown name %q in formula", identifier)
+		}
+	}
+	return nil
+}
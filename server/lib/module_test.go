@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func moduleTestSchema() *Schema {
+	return &Schema{
+		Variables: map[string]Variable{
+			"strength": {Type: TypeNumber, Default: 10.0},
+			"level":    {Type: TypeNumber, Default: 4.0},
+		},
+		Properties: map[string]Property{
+			"armor_class": {Formula: "10"},
+			"damage":      {Formula: "strength / 2"},
+		},
+		Features: map[string]Feature{
+			"equipped": {AddsModules: []string{"belt", "shield", "plate"}},
+		},
+		Modules: map[string]Module{
+			"belt": {
+				Modifies: map[string]Modifier{"strength": {Formula: "2"}},
+			},
+			"shield": {
+				Modifies: map[string]Modifier{"armor_class": {Formula: "2"}},
+			},
+			"plate": {
+				Priority:       -1,
+				AddsProperties: map[string]Property{"armor_class": {Formula: "18"}},
+				Overrides:      []string{"armor_class"},
+				Modifies:       map[string]Modifier{"damage": {Op: ModifierMultiply, Formula: "level / 2"}},
+			},
+		},
+	}
+}
+
+func TestModuleMerge(t *testing.T) {
+	schema := moduleTestSchema()
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	evaluation := schema.Evaluate(&Instance{ActiveFeatures: []string{"equipped"}})
+	if evaluation.Variables["strength"] != 12.0 {
+		t.Errorf("strength: %v", evaluation.Variables["strength"])
+	}
+	// plate replaces the base armor class, then the shield adds to it
+	if evaluation.Properties["armor_class"] != 20.0 {
+		t.Errorf("armor class: %v", evaluation.Properties["armor_class"])
+	}
+	if evaluation.Properties["damage"] != 12.0 {
+		t.Errorf("damage: %v", evaluation.Properties["damage"])
+	}
+
+	evaluation = schema.Evaluate(&Instance{})
+	if evaluation.Variables["strength"] != 10.0 || evaluation.Properties["armor_class"] != 10.0 {
+		t.Errorf("without modules: %v %v", evaluation.Variables, evaluation.Properties)
+	}
+}
+
+// A module may bring the schema's only properties.
+func TestModulesWithoutBaseFields(t *testing.T) {
+	schema := &Schema{Modules: map[string]Module{
+		"stealth": {
+			AddsVariables:  map[string]Variable{"agility": {Type: TypeNumber}},
+			AddsProperties: map[string]Property{"sneak": {Formula: "agility * 2"}},
+		},
+	}}
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModuleCollisions(t *testing.T) {
+	cases := map[string]struct {
+		change func(*Schema)
+		want   string
+	}{
+		"replacing without override": {func(s *Schema) {
+			s.Modules["plate"] = Module{AddsProperties: map[string]Property{"armor_class": {Formula: "18"}}}
+		}, "already defined by the schema"},
+		"two modules, one name": {func(s *Schema) {
+			s.Modules["belt"] = Module{AddsVariables: map[string]Variable{"might": {Type: TypeNumber}}}
+			s.Modules["shield"] = Module{AddsVariables: map[string]Variable{"might": {Type: TypeNumber}}}
+		}, `already defined by module "belt"`},
+		"override at the same priority": {func(s *Schema) {
+			s.Modules["belt"] = Module{AddsVariables: map[string]Variable{"might": {Type: TypeNumber}}}
+			s.Modules["shield"] = Module{
+				AddsVariables: map[string]Variable{"might": {Type: TypeNumber}},
+				Overrides:     []string{"might"},
+			}
+		}, "same priority"},
+		"modifying nothing": {func(s *Schema) {
+			s.Modules["belt"] = Module{Modifies: map[string]Modifier{"dexterity": {Formula: "2"}}}
+		}, "unknown name"},
+		"variable modifier using a property": {func(s *Schema) {
+			s.Modules["belt"] = Module{Modifies: map[string]Modifier{"strength": {Formula: "armor_class"}}}
+		}, "unknown name"},
+		"unknown op": {func(s *Schema) {
+			s.Modules["belt"] = Module{Modifies: map[string]Modifier{"strength": {Op: "pow", Formula: "2"}}}
+		}, "unknown op"},
+	}
+	for name, c := range cases {
+		schema := moduleTestSchema()
+		c.change(schema)
+		err := schema.Validate()
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package lib
+
+import (
+	"io"
+	"math"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// PDF //
+
+// The PDF uses the standard Helvetica font so no font files are needed.
+// Text is encoded as Windows-1252; characters outside it don't print.
+
+// Sizes in millimetres and points.
+const (
+	pdfMargin      = 12.0
+	pdfGap         = 3.0
+	pdfPadding     = 3.0
+	pdfLabelSize   = 7.0
+	pdfLabelLine   = 3.2
+	pdfValueSize   = 10.0
+	pdfValueLine   = 4.6
+	pdfHeadingSize = 12.0
+	pdfHeadingLine = 7.0
+	pdfSlotSize    = 3.5
+	pdfSlotGap     = 1.2
+)
+
+func (s *Sheet) RenderPDF(w io.Writer) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(false, pdfMargin)
+	pdf.SetTitle(s.Title, true)
+	pdf.AddPage()
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	r := &pdfRenderer{
+		pdf:    pdf,
+		tr:     pdf.UnicodeTranslatorFromDescriptor(""),
+		top:    pdfMargin,
+		bottom: pageHeight - pdfMargin,
+	}
+	width := pageWidth - 2*pdfMargin
+
+	y := r.header(s, pdfMargin, width)
+	r.node(s.Root, pdfMargin, y, width, true, true)
+
+	return pdf.Output(w)
+}
+
+type pdfRenderer struct {
+	pdf         *fpdf.Fpdf
+	tr          func(string) string
+	top, bottom float64
+}
+
+func (r *pdfRenderer) header(s *Sheet, x, width float64) float64 {
+	pdf := r.pdf
+	y := r.top
+
+	pdf.SetFont("Helvetica", "B", 20)
+	for _, line := range r.wrap(s.Title, width) {
+		pdf.Text(x, y+7, line)
+		y += 9
+	}
+	if s.Subtitle != "" {
+		pdf.SetFont("Helvetica", "", pdfValueSize)
+		pdf.SetTextColor(85, 85, 85)
+		pdf.Text(x, y+3.5, r.tr(s.Subtitle))
+		pdf.SetTextColor(0, 0, 0)
+		y += pdfValueLine + 1
+	}
+	if s.Description != "" {
+		pdf.SetFont("Helvetica", "", pdfValueSize)
+		for _, line := range r.wrap(s.Description, width) {
+			pdf.Text(x, y+3.5, line)
+			y += pdfValueLine
+		}
+	}
+
+	y += 1
+	pdf.SetLineWidth(0.6)
+	pdf.Line(x, y, x+width, y)
+	pdf.SetLineWidth(0.2)
+	return y + 4
+}
+
+// Lays out a node at (x, y) and returns the y below it. With draw unset
+// nothing is drawn and no pages are added, which gives the node's height.
+// Breakable nodes may continue on a new page; others are kept together.
+func (r *pdfRenderer) node(node ResolvedVisualization, x, y, width float64, draw, breakable bool) float64 {
+	switch node.Type {
+	case VisSingleField:
+		return r.field(node, x, y, width, draw, breakable)
+	case VisSlotTracker:
+		return r.slots(node, x, y, width, draw, breakable)
+	case VisTabs:
+		for i, child := range node.Children {
+			if i > 0 {
+				y += pdfGap
+				if draw && breakable {
+					y = r.newPage()
+				}
+			}
+			y = r.node(child, x, y, width, draw, breakable)
+		}
+		return y
+	}
+
+	boxed := node.Type == VisCard || node.Type == VisAccordion
+	if boxed && draw && breakable {
+		// keep boxes on one page when they fit on one; longer ones lose
+		// their border so they can run over pages
+		height := r.height(node, width)
+		if height <= r.bottom-r.top {
+			y = r.fit(y, height)
+			return r.node(node, x, y, width, draw, false)
+		}
+		boxed = false
+	}
+
+	start := y
+	innerX, innerWidth := x, width
+	if boxed {
+		innerX, innerWidth = x+pdfPadding, width-2*pdfPadding
+		y += pdfPadding - 1
+	}
+
+	if node.Name != "" {
+		y = r.fitIf(draw && breakable, y, pdfHeadingLine)
+		if draw {
+			r.pdf.SetFont("Helvetica", "B", pdfHeadingSize)
+			r.pdf.Text(innerX, y+5, r.tr(node.Name))
+		}
+		y += pdfHeadingLine
+	}
+	if node.Error != "" {
+		y = r.errorLine(node.Error, innerX, y, innerWidth, draw)
+	}
+
+	switch node.Type {
+	case VisGrid:
+		y = r.columns(node.Children, max(node.Columns, 1), innerX, y, innerWidth, draw, breakable)
+	case VisRow:
+		y = r.columns(node.Children, max(len(node.Children), 1), innerX, y, innerWidth, draw, breakable)
+	default:
+		for i, child := range node.Children {
+			if i > 0 {
+				y += pdfGap
+			}
+			if draw && breakable {
+				y = r.fit(y, r.height(child, innerWidth))
+			}
+			y = r.node(child, innerX, y, innerWidth, draw, breakable)
+		}
+	}
+
+	if boxed {
+		y += pdfPadding
+		if draw {
+			r.pdf.SetDrawColor(17, 17, 17)
+			r.pdf.RoundedRect(x, start, width, y-start, 2, "1234", "D")
+		}
+	}
+	return y
+}
+
+// Children in rows of the given number of columns. A row is kept together.
+func (r *pdfRenderer) columns(children []ResolvedVisualization, columns int, x, y, width float64, draw, breakable bool) float64 {
+	cellWidth := (width - float64(columns-1)*pdfGap) / float64(columns)
+	for start := 0; start < len(children); start += columns {
+		row := children[start:min(start+columns, len(children))]
+
+		height := 0.0
+		for _, child := range row {
+			height = math.Max(height, r.height(child, cellWidth))
+		}
+		if start > 0 {
+			y += pdfGap - 1
+		}
+		y = r.fitIf(draw && breakable, y, height)
+
+		if draw {
+			for i, child := range row {
+				r.node(child, x+float64(i)*(cellWidth+pdfGap), y, cellWidth, true, false)
+			}
+		}
+		y += height
+	}
+	return y
+}
+
+func (r *pdfRenderer) field(node ResolvedVisualization, x, y, width float64, draw, breakable bool) float64 {
+	r.pdf.SetFont("Helvetica", "", pdfValueSize)
+	lines := r.wrap(FormatValue(node.Value), width)
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+
+	height := 1 + pdfLabelLine + float64(len(lines))*pdfValueLine + 1.5
+	if node.Error != "" {
+		height += pdfLabelLine
+	}
+	if !draw {
+		return y + height
+	}
+	y = r.fitIf(breakable, y, height)
+
+	r.label(node.Name, x, y+1)
+	valueY := y + 1 + pdfLabelLine
+	r.pdf.SetFont("Helvetica", "", pdfValueSize)
+	for i, line := range lines {
+		r.pdf.Text(x, valueY+float64(i+1)*pdfValueLine-1.2, line)
+	}
+	lineY := valueY + float64(len(lines))*pdfValueLine
+	r.pdf.SetDrawColor(153, 153, 153)
+	r.pdf.Line(x, lineY, x+width, lineY)
+	if node.Error != "" {
+		r.errorLine(node.Error, x, lineY+0.5, width, true)
+	}
+	return y + height
+}
+
+func (r *pdfRenderer) slots(node ResolvedVisualization, x, y, width float64, draw, breakable bool) float64 {
+	boxes := slotBoxes(node)
+	perRow := max(int((width+pdfSlotGap)/(pdfSlotSize+pdfSlotGap)), 1)
+	rows := (len(boxes) + perRow - 1) / perRow
+
+	label := node.Name + " (" + FormatValue(node.Value) + " / " + FormatValue(node.Max) + ")"
+	height := 1 + pdfLabelLine + float64(rows)*(pdfSlotSize+pdfSlotGap) + 1.5
+	if rows == 0 {
+		height += pdfValueLine
+	}
+	if node.Error != "" {
+		height += pdfLabelLine
+	}
+	if !draw {
+		return y + height
+	}
+	y = r.fitIf(breakable, y, height)
+
+	r.label(label, x, y+1)
+	boxY := y + 1 + pdfLabelLine + 0.5
+	r.pdf.SetDrawColor(17, 17, 17)
+	r.pdf.SetFillColor(17, 17, 17)
+	for i, spent := range boxes {
+		bx := x + float64(i%perRow)*(pdfSlotSize+pdfSlotGap)
+		by := boxY + float64(i/perRow)*(pdfSlotSize+pdfSlotGap)
+		style := "D"
+		if spent {
+			style = "FD"
+		}
+		r.pdf.Rect(bx, by, pdfSlotSize, pdfSlotSize, style)
+	}
+	if node.Error != "" {
+		r.errorLine(node.Error, x, y+height-1.5-pdfLabelLine, width, true)
+	}
+	return y + height
+}
+
+func (r *pdfRenderer) label(text string, x, y float64) {
+	r.pdf.SetFont("Helvetica", "", pdfLabelSize)
+	r.pdf.SetTextColor(85, 85, 85)
+	r.pdf.Text(x, y+2.5, r.tr(strings.ToUpper(text)))
+	r.pdf.SetTextColor(0, 0, 0)
+}
+
+func (r *pdfRenderer) errorLine(text string, x, y, width float64, draw bool) float64 {
+	if draw {
+		r.pdf.SetFont("Helvetica", "", pdfLabelSize)
+		r.pdf.SetTextColor(187, 0, 0)
+		r.pdf.Text(x, y+2.5, r.tr(text))
+		r.pdf.SetTextColor(0, 0, 0)
+	}
+	return y + pdfLabelLine
+}
+
+func (r *pdfRenderer) height(node ResolvedVisualization, width float64) float64 {
+	return r.node(node, 0, 0, width, false, false)
+}
+
+// Moves to a new page when height doesn't fit below y. Something taller
+// than a whole page is started where it is.
+func (r *pdfRenderer) fit(y, height float64) float64 {
+	if y+height > r.bottom && y > r.top {
+		return r.newPage()
+	}
+	return y
+}
+
+func (r *pdfRenderer) fitIf(enabled bool, y, height float64) float64 {
+	if !enabled {
+		return y
+	}
+	return r.fit(y, height)
+}
+
+func (r *pdfRenderer) newPage() float64 {
+	r.pdf.AddPage()
+	return r.top
+}
+
+// Wraps text to the width in the current font and returns the lines
+// encoded for the PDF. Newlines in the text are kept.
+func (r *pdfRenderer) wrap(text string, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if r.pdf.GetStringWidth(r.tr(candidate)) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, r.tr(line))
+			}
+			// words wider than the line are cut
+			line = ""
+			for _, char := range word {
+				if line != "" && r.pdf.GetStringWidth(r.tr(line+string(char))) > width {
+					lines = append(lines, r.tr(line))
+					line = ""
+				}
+				line += string(char)
+			}
+		}
+		if line != "" || paragraph == "" {
+			lines = append(lines, r.tr(line))
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
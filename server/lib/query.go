@@ -0,0 +1,188 @@
+package lib
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Instance queries //
+
+// A search over instances: free text terms plus structured filters on
+// variable values. Written as one string, e.g.
+//
+//	gandalf level>=5 class=wizard
+//
+// Terms that aren't a variable name, an operator and a value are text
+// terms, so "wow!" or "<3" are searched for rather than rejected; all of
+// them must match the name, description or a string variable (prefix match,
+// case insensitive).
+type Query struct {
+	Terms   []string `json:"terms,omitempty"`
+	Filters []Filter `json:"filters,omitempty"`
+}
+
+type FilterOp string
+
+const (
+	OpEq  FilterOp = "="
+	OpNe  FilterOp = "!="
+	OpGt  FilterOp = ">"
+	OpGte FilterOp = ">="
+	OpLt  FilterOp = "<"
+	OpLte FilterOp = "<="
+)
+
+// Longest operators first so ">=" isn't read as ">".
+var filterOps = []FilterOp{OpGte, OpLte, OpNe, OpEq, OpGt, OpLt}
+
+type Filter struct {
+	Variable string   `json:"variable"`
+	Op       FilterOp `json:"op"`
+	Value    string   `json:"value"`
+}
+
+// Splits on whitespace; double quotes keep a phrase or filter value together
+// (class="dark knight").
+func ParseQuery(q string) Query {
+	var query Query
+	for _, part := range splitQuery(q) {
+		if filter, ok := parseFilter(part); ok {
+			query.Filters = append(query.Filters, filter)
+			continue
+		}
+		query.Terms = append(query.Terms, Tokenize(part)...)
+	}
+	return query
+}
+
+// Reads name<op>value. The name is letters, digits and underscores.
+func parseFilter(text string) (Filter, bool) {
+	index := strings.IndexAny(text, "=!<>")
+	if index <= 0 {
+		return Filter{}, false
+	}
+	variable := text[:index]
+	if strings.ContainsFunc(variable, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		return Filter{}, false
+	}
+
+	rest := text[index:]
+	for _, op := range filterOps {
+		if strings.HasPrefix(rest, string(op)) {
+			value := strings.Trim(strings.TrimSpace(rest[len(op):]), `"`)
+			return Filter{Variable: variable, Op: op, Value: value}, true
+		}
+	}
+	return Filter{}, false
+}
+
+func splitQuery(q string) []string {
+	var parts []string
+	var current strings.Builder
+	quoted := false
+
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+
+	return parts
+}
+
+// Lower cased words made of letters and digits.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Text that full text search looks at: name, description and every string
+// value (including strings inside arrays).
+func SearchableText(instance *Instance) []string {
+	texts := []string{instance.Name, instance.Description}
+	for _, value := range instance.VariableValues {
+		texts = appendStrings(texts, value)
+	}
+	return texts
+}
+
+func appendStrings(texts []string, value any) []string {
+	switch v := value.(type) {
+	case string:
+		texts = append(texts, v)
+	case []any:
+		for _, item := range v {
+			texts = appendStrings(texts, item)
+		}
+	}
+	return texts
+}
+
+// Whether the instance's variables satisfy the filter. Numbers are compared
+// numerically when both sides are numeric, everything else as case
+// insensitive text. A missing variable only matches !=.
+func (f Filter) Matches(instance *Instance) bool {
+	value, ok := instance.GetVariable(f.Variable)
+	if !ok || value == nil {
+		return f.Op == OpNe
+	}
+
+	return f.matchValue(value)
+}
+
+func (f Filter) matchValue(value any) bool {
+	// arrays match when any item does
+	if list, ok := value.([]any); ok {
+		for _, item := range list {
+			if f.matchValue(item) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if number, ok := AsNumber(value); ok {
+		if target, err := strconv.ParseFloat(f.Value, 64); err == nil {
+			return compare(f.Op, cmp.Compare(number, target))
+		}
+	}
+
+	text := fmt.Sprint(value)
+	return compare(f.Op, strings.Compare(strings.ToLower(text), strings.ToLower(f.Value)))
+}
+
+func compare(op FilterOp, result int) bool {
+	switch op {
+	case OpEq:
+		return result == 0
+	case OpNe:
+		return result != 0
+	case OpGt:
+		return result > 0
+	case OpGte:
+		return result >= 0
+	case OpLt:
+		return result < 0
+	case OpLte:
+		return result <= 0
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	query := ParseQuery(`Old Smith level>=5 class="dark knight" alive!=false`)
+
+	if !reflect.DeepEqual(query.Terms, []string{"old", "smith"}) {
+		t.Errorf("unexpected terms %v", query.Terms)
+	}
+
+	want := []Filter{
+		{Variable: "level", Op: OpGte, Value: "5"},
+		{Variable: "class", Op: OpEq, Value: "dark knight"},
+		{Variable: "alive", Op: OpNe, Value: "false"},
+	}
+	if !reflect.DeepEqual(query.Filters, want) {
+		t.Errorf("unexpected filters %+v", query.Filters)
+	}
+
+	// anything that isn't name<op>value is searched for
+	query = ParseQuery("wow! <3 love >=5 a!b")
+	if !reflect.DeepEqual(query.Terms, []string{"wow", "3", "love", "5", "a", "b"}) || len(query.Filters) != 0 {
+		t.Errorf("unexpected query %+v", query)
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	instance := &Instance{VariableValues: map[string]any{
+		"level": 7.0,
+		"class": "Wizard",
+		"tags":  []any{"npc", "villain"},
+		"alive": true,
+	}}
+
+	tests := []struct {
+		filter Filter
+		want   bool
+	}{
+		{Filter{"level", OpGte, "5"}, true},
+		{Filter{"level", OpLt, "5"}, false},
+		{Filter{"level", OpEq, "7"}, true},
+		{Filter{"class", OpEq, "wizard"}, true},
+		{Filter{"class", OpNe, "wizard"}, false},
+		{Filter{"tags", OpEq, "villain"}, true},
+		{Filter{"alive", OpEq, "true"}, true},
+		{Filter{"missing", OpEq, "x"}, false},
+		{Filter{"missing", OpNe, "x"}, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Matches(instance); got != tt.want {
+			t.Errorf("%s%s%s: got %v, want %v", tt.filter.Variable, tt.filter.Op, tt.filter.Value, got, tt.want)
+		}
+	}
+}
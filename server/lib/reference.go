@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// References //
+
+// A reference variable holds the ID of another instance, optionally of a
+// given schema; an array of references holds several. In formulas a
+// reference reads as the referenced instance's values, so "spouse.name" or
+// "mount.speed" work, as does "map(children, .age)" for arrays. Only one
+// level is followed: "spouse.spouse" is an ID again.
+
+// Finds an instance and its schema by ID.
+type InstanceLookup func(id string) (*Instance, *Schema, error)
+
+func (v *Variable) validate() error {
+	if v.Schema != "" && v.Type != TypeReference {
+		return fmt.Errorf("only references can name a schema")
+	}
+	if _, ok := v.referenceSchema(); v.Link != "" && !ok {
+		return fmt.Errorf("only references can be links")
+	}
+	switch v.Link {
+	case "", LinkParent, LinkChild:
+	default:
+		return fmt.Errorf("unknown link %q", v.Link)
+	}
+	if err := v.validateTimezone(); err != nil {
+		return err
+	}
+	if v.Items != nil {
+		if err := v.Items.validate(); err != nil {
+			return fmt.Errorf("items: %w", err)
+		}
+	}
+	return nil
+}
+
+// The variable's referenced schema, and whether it holds references at all
+// (directly or as array items).
+func (v *Variable) referenceSchema() (string, bool) {
+	switch {
+	case v.Type == TypeReference:
+		return v.Schema, true
+	case v.Type == TypeArray && v.Items != nil && v.Items.Type == TypeReference:
+		return v.Items.Schema, true
+	}
+	return "", false
+}
+
+func referenceIDs(value any) []string {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []any:
+		var ids []string
+		for _, item := range v {
+			if id, ok := item.(string); ok && id != "" {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	return nil
+}
+
+// A link from one instance to another through a reference variable.
+type Reference struct {
+	Variable string   `json:"variable"`
+	Target   string   `json:"target"`           // instance ID
+	Schema   string   `json:"schema,omitempty"` // schema the target must have
+	Link     LinkKind `json:"link,omitempty"`
+}
+
+// The instance's references, by variable name and then in the order stored.
+// The variables of its active modules count too.
+func (s *Schema) References(instance *Instance) []Reference {
+	variables := s.GetAllVariables(instance.ActiveModules)
+	var references []Reference
+	for _, name := range slices.Sorted(maps.Keys(variables)) {
+		variable := variables[name]
+		schema, ok := variable.referenceSchema()
+		if !ok {
+			continue
+		}
+		for _, id := range referenceIDs(instance.VariableValues[name]) {
+			references = append(references, Reference{Variable: name, Target: id, Schema: schema, Link: variable.Link})
+		}
+	}
+	return references
+}
+
+// Checks that every reference points at an instance of the right schema.
+func (s *Schema) CheckReferences(instance *Instance, lookup InstanceLookup) error {
+	for _, reference := range s.References(instance) {
+		target, _, err := lookup(reference.Target)
+		if err != nil {
+			return fmt.Errorf("%s: instance %s: %w", reference.Variable, reference.Target, err)
+		}
+		if reference.Schema != "" && target.SchemaID != reference.Schema {
+			return fmt.Errorf("%s: instance %s isn't a %s", reference.Variable, reference.Target, reference.Schema)
+		}
+	}
+	return nil
+}
+
+// A copy of the values with references replaced by the referenced
+// instances' values, evaluated at the same time. References that can't be
+// found become nil.
+func linkReferences(values map[string]any, variables map[string]Variable, opts EvalOptions) map[string]any {
+	linked := maps.Clone(values)
+	resolve := func(id string) any {
+		instance, schema, err := opts.Lookup(id)
+		if err != nil {
+			return nil
+		}
+		evaluation := schema.EvaluateWith(instance, EvalOptions{Now: opts.Now})
+		target := maps.Clone(evaluation.Variables)
+		maps.Copy(target, evaluation.Properties)
+		target["_id"] = instance.ID
+		if _, ok := target["name"]; !ok {
+			target["name"] = instance.Name
+		}
+		return target
+	}
+
+	for name, variable := range variables {
+		if _, ok := variable.referenceSchema(); !ok {
+			continue
+		}
+		switch value := values[name].(type) {
+		case string:
+			linked[name] = resolve(value)
+		case []any:
+			targets := make([]any, len(value))
+			for i, item := range value {
+				if id, ok := item.(string); ok {
+					targets[i] = resolve(id)
+				}
+			}
+			linked[name] = targets
+		}
+	}
+	return linked
+}
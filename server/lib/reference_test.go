@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReferences(t *testing.T) {
+	person := &Schema{
+		ID: "person",
+		Variables: map[string]Variable{
+			"age":      {Type: TypeNumber},
+			"spouse":   {Type: TypeReference, Schema: "person"},
+			"children": {Type: TypeArray, Items: &Variable{Type: TypeReference}},
+			"pet":      {Type: TypeReference},
+		},
+		Properties: map[string]Property{
+			"spouse_name":    {Formula: "spouse.name"},
+			"oldest_child":   {Formula: "max(map(children, .age))"},
+			"spouse_partner": {Formula: "spouse.spouse"},
+		},
+	}
+	if err := person.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	dog := &Schema{ID: "dog"}
+
+	instances := map[string]*Instance{
+		"ada":    {ID: "ada", Name: "Ada", SchemaID: "person", VariableValues: map[string]any{"spouse": "bob", "children": []any{"cy", "di"}}},
+		"bob":    {ID: "bob", Name: "Bob", SchemaID: "person", VariableValues: map[string]any{"spouse": "ada"}},
+		"cy":     {ID: "cy", Name: "Cy", SchemaID: "person", VariableValues: map[string]any{"age": 12.0}},
+		"di":     {ID: "di", Name: "Di", SchemaID: "person", VariableValues: map[string]any{"age": 9.0}},
+		"rex":    {ID: "rex", Name: "Rex", SchemaID: "dog"},
+		"broken": {ID: "broken", SchemaID: "person", VariableValues: map[string]any{"spouse": "rex"}},
+	}
+	lookup := func(id string) (*Instance, *Schema, error) {
+		instance, ok := instances[id]
+		if !ok {
+			return nil, nil, errors.New("entry not found")
+		}
+		if instance.SchemaID == "dog" {
+			return instance, dog, nil
+		}
+		return instance, person, nil
+	}
+
+	ada := instances["ada"]
+	evaluation := person.EvaluateWith(ada, EvalOptions{Lookup: lookup})
+	if evaluation.Properties["spouse_name"] != "Bob" || evaluation.Properties["oldest_child"] != 12.0 {
+		t.Errorf("dereferenced: %v %v", evaluation.Properties, evaluation.Errors)
+	}
+	if evaluation.Properties["spouse_partner"] != "ada" {
+		t.Errorf("only one level should be followed: %v", evaluation.Properties["spouse_partner"])
+	}
+	if evaluation.Variables["spouse"] != "bob" {
+		t.Errorf("variables should keep the id: %v", evaluation.Variables["spouse"])
+	}
+
+	if got := len(person.References(ada)); got != 3 {
+		t.Errorf("ada has %d references", got)
+	}
+	if err := person.CheckReferences(ada, lookup); err != nil {
+		t.Error(err)
+	}
+	if err := person.CheckReferences(instances["broken"], lookup); err == nil {
+		t.Error("a spouse of the wrong schema should be rejected")
+	}
+	ada.SetVariable("pet", "nobody")
+	if err := person.CheckReferences(ada, lookup); err == nil {
+		t.Error("a missing instance should be rejected")
+	}
+
+	person.Variables["age"] = Variable{Type: TypeNumber, Schema: "person"}
+	if err := person.Validate(); err == nil {
+		t.Error("a schema on a number variable should be rejected")
+	}
+}
+
+// Events, trackers, feature prerequisites and CSV exports evaluate with the
+// same lookup as the evaluation itself.
+func TestReferencesOutsideEvaluate(t *testing.T) {
+	horse := &Schema{ID: "horse", Variables: map[string]Variable{"speed": {Type: TypeNumber}}}
+	rider := &Schema{
+		ID: "rider",
+		Variables: map[string]Variable{
+			"mount":    {Type: TypeReference, Schema: "horse"},
+			"traveled": {Type: TypeNumber, Default: 0.0},
+			"gallops":  {Type: TypeNumber},
+		},
+		Properties: map[string]Property{"max_gallops": {Formula: "mount.speed / 10"}},
+		Trackers:   map[string]SlotTracker{"gallops": {Current: "gallops", Max: "max_gallops"}},
+		Events:     map[string]Event{"ride": {Updates: map[string]string{"traveled": "traveled + mount.speed"}}},
+		Features: map[string]Feature{
+			"charge":  {Prerequisite: "mount.speed >= 40"},
+			"mounted": {Condition: "mount.speed > 10"},
+		},
+	}
+	if err := rider.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	opts := EvalOptions{Lookup: func(id string) (*Instance, *Schema, error) {
+		if id != "dobbin" {
+			return nil, nil, errors.New("entry not found")
+		}
+		return &Instance{ID: id, SchemaID: "horse", VariableValues: map[string]any{"speed": 40.0}}, horse, nil
+	}}
+	instance := &Instance{ID: "jo", SchemaID: "rider", VariableValues: map[string]any{"mount": "dobbin"}}
+
+	if result, err := rider.ApplyEvent(instance, "ride", opts); err != nil || result.Changes["traveled"].After != 40.0 {
+		t.Errorf("event: %v %v", result, err)
+	}
+	if state, err := rider.SpendSlots(instance, "gallops", 1, opts); err != nil || state.Max != 4 || state.Current != 3 {
+		t.Errorf("tracker: %v %v", state, err)
+	}
+	if err := instance.AddFeature("charge", rider, opts); err != nil {
+		t.Errorf("prerequisite: %v", err)
+	}
+	if failures := instance.UpdateActiveFeatures(rider, opts); failures != nil || !slices.Equal(instance.ActiveFeatures, []string{"charge", "mounted"}) {
+		t.Errorf("condition: %v %v", instance.ActiveFeatures, failures)
+	}
+	var buf bytes.Buffer
+	if err := WriteInstancesCSV(&buf, rider, []Instance{*instance}, opts); err != nil || !strings.Contains(buf.String(), ",4\n") {
+		t.Errorf("csv: %q %v", buf.String(), err)
+	}
+}
@@ -4,6 +4,9 @@ package lib
 // maps, slices for later when adding features
 import (
 	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
 	"time"
 )
 
@@ -21,11 +24,17 @@ import (
 type VariableType string
 
 const (
-	TypeNumber  VariableType = "number"
-	TypeString  VariableType = "string"
-	TypeBoolean VariableType = "boolean"
-	TypeEnum    VariableType = "enum"
-	TypeArray   VariableType = "array"
+	TypeNumber     VariableType = "number"
+	TypeString     VariableType = "string"
+	TypeMarkdown   VariableType = "markdown" // text rendered to HTML in evaluations
+	TypeBoolean    VariableType = "boolean"
+	TypeEnum       VariableType = "enum"
+	TypeArray      VariableType = "array"
+	TypeReference  VariableType = "reference"  // ID of another instance
+	TypeAttachment VariableType = "attachment" // SHA-256 of a stored file
+	TypeDate       VariableType = "date"       // 2006-01-02
+	TypeDateTime   VariableType = "datetime"   // RFC 3339
+	TypeDuration   VariableType = "duration"   // 1h30m0s
 )
 
 type FormatType string
@@ -59,28 +68,36 @@ const (
 // of variables, features for defining application logic, and modules for
 // grouping.
 type Schema struct {
-	ID          string `json:"_id"`
-	Version     int    `json:"version"`
-	UserVersion int    `json:"user_version"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	//	Variables      map[string]Variable `json:"variables"`
-	//	Properties     map[string]Property `json:"display_values"`
-	//	Features       map[string]Feature  `json:"features"`
-	//	Modules        map[string]Module   `json:"modules"`
+	ID          string                  `json:"_id"`
+	Version     int                     `json:"version"`
+	UserVersion int                     `json:"user_version"`
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Variables   map[string]Variable     `json:"variables"`
+	DataSources map[string]DataSource   `json:"data_sources,omitempty"`
+	Properties  map[string]Property     `json:"display_values"`
+	Trackers    map[string]SlotTracker  `json:"trackers,omitempty"`
+	Events      map[string]Event        `json:"events,omitempty"`
+	Features    map[string]Feature      `json:"features"`
+	Modules     map[string]Module       `json:"modules"`
+	Groups      map[string]FeatureGroup `json:"feature_groups,omitempty"`
 	//	Initialization Initialization      `json:"initialization"`
-	//	Visualization  Visualization       `json:"visualization"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Visualization Visualization `json:"visualization"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
 }
 
 type Variable struct {
-	Type    VariableType `json:"type"`
-	Default any          `json:"default,omitempty"`
-	Min     *float64     `json:"min,omitempty"`
-	Max     *float64     `json:"max,omitempty"`
-	Options []string     `json:"options,omitempty"` // for enum
-	Items   *Variable    `json:"items,omitempty"`   // for array type
+	Type     VariableType `json:"type"`
+	Default  any          `json:"default,omitempty"`
+	Min      *float64     `json:"min,omitempty"`
+	Max      *float64     `json:"max,omitempty"`
+	Options  []string     `json:"options,omitempty"`  // for enum
+	Items    *Variable    `json:"items,omitempty"`    // for array type
+	Schema   string       `json:"schema,omitempty"`   // for reference: the referenced instance's schema
+	Link     LinkKind     `json:"link,omitempty"`     // for references: family relation, see graph.go
+	Timezone string       `json:"timezone,omitempty"` // for datetimes: IANA zone for values without an offset
+	Required bool         `json:"required,omitempty"` // instances must set a value
 }
 
 type Property struct {
@@ -89,16 +106,52 @@ type Property struct {
 }
 
 type Feature struct {
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	AddsModules []string `json:"adds_modules"`
+	Name         string             `json:"name"`
+	Description  string             `json:"description"`
+	AddsModules  []string           `json:"adds_modules"`
+	Condition    string             `json:"condition,omitempty"`    // formula; the feature is active while it's true
+	Requires     []string           `json:"requires,omitempty"`     // features that must be active first
+	Prerequisite string             `json:"prerequisite,omitempty"` // formula that must hold to add it
+	Excludes     []string           `json:"excludes,omitempty"`     // features it can't be taken with
+	Bonuses      map[string][]Bonus `json:"bonuses,omitempty"`      // property -> bonuses while active
 }
 
+// At most Choose of the features may be active at once.
+type FeatureGroup struct {
+	Description string   `json:"description,omitempty"`
+	Features    []string `json:"features"`
+	Choose      int      `json:"choose"`
+}
+
+// Modules are merged onto the schema in priority order (lowest first, ties
+// by name). Adding a variable or property that already exists is an error
+// unless the module lists it in Overrides; Modifies changes an existing
+// value instead of replacing it (see module.go).
 type Module struct {
 	Name           string              `json:"name"`
 	Description    string              `json:"description,omitempty"`
+	Priority       int                 `json:"priority,omitempty"`
 	AddsVariables  map[string]Variable `json:"adds_variables,omitempty"`
 	AddsProperties map[string]Property `json:"adds_display_values,omitempty"`
+	Overrides      []string            `json:"overrides,omitempty"`
+	Modifies       map[string]Modifier `json:"modifies,omitempty"`
+	Bonuses        map[string][]Bonus  `json:"bonuses,omitempty"` // property -> bonuses
+}
+
+type ModifierOp string
+
+const (
+	ModifierAdd      ModifierOp = "add"
+	ModifierMultiply ModifierOp = "multiply"
+	ModifierMin      ModifierOp = "min" // caps the value
+	ModifierMax      ModifierOp = "max" // raises the value to at least this
+)
+
+// Combines an existing variable or property with the result of Formula.
+// Modifiers on variables may only use variables.
+type Modifier struct {
+	Op      ModifierOp `json:"op,omitempty"` // add when unset
+	Formula string     `json:"formula"`
 }
 
 // Initialization
@@ -129,17 +182,36 @@ type Field struct {
 // An instance of data based off a schema.
 // All values from the schema are processed at runtime.
 type Instance struct {
-	ID       string `json:"_id"`
-	SchemaID string `json:"schema_id"`
-	//	Visualization  Visualization  `json:"visualization"`
-	UserID      string `json:"user_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	//	VariableValues map[string]any `json:"variable_values"`
-	//	ActiveFeatures []string       `json:"active_features"`
-	//	ActiveModules  []string       `json:"active_modules"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID             string             `json:"_id"`
+	SchemaID       string             `json:"schema_id"`
+	Visualization  Visualization      `json:"visualization"`
+	UserID         string             `json:"user_id"`
+	Name           string             `json:"name"`
+	Description    string             `json:"description"`
+	VariableValues map[string]any     `json:"variable_values"`
+	ActiveFeatures []string           `json:"active_features"`
+	ActiveModules  []string           `json:"active_modules"`
+	Bonuses        map[string][]Bonus `json:"bonuses,omitempty"` // property -> bonuses of this instance alone
+	Effects        []Effect           `json:"effects,omitempty"`
+	CreatedAt      time.Time          `json:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+}
+
+const maxIDLength = 128
+
+// Whether the ID can name a stored schema or instance: letters, digits, '-'
+// and '_'. IDs end up in file names, so those read from uploads (bundles,
+// CSV files) are checked against this.
+func IsValidID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
 }
 
 // Visualization
@@ -155,54 +227,64 @@ type Visualization struct {
 
 // Helper methods //
 
-//func (schema *Schema) GetAllVariables(activeModules []string) map[string]Variable {
-//	allVars := make(map[string]Variable)
-//
-//	// initial variables
-//	maps.Copy(allVars, schema.Variables)
-//
-//	// add variables from features
-//	for _, moduleName := range activeModules {
-//		if module, ok := schema.Modules[moduleName]; ok {
-//			maps.Copy(allVars, module.AddsVariables)
-//		}
-//	}
-//
-//	return allVars
-//}
-//
-//func (schema *Schema) GetAllProperties(activeModules []string) map[string]Property {
-//	allProperties := make(map[string]Property)
-//
-//	maps.Copy(allProperties, schema.Properties)
-//
-//	for _, moduleName := range activeModules {
-//		if module, ok := schema.Modules[moduleName]; ok {
-//			maps.Copy(allProperties, module.AddsProperties)
-//		}
-//	}
-//
-//	return allProperties
-//}
-//
-//func (schema *Schema) GetActiveModules(activeFeatures []string) []string {
-//	moduleSet := make(map[string]bool)
-//
-//	for _, featureName := range activeFeatures {
-//		if feature, ok := schema.Features[featureName]; ok {
-//			for _, moduleName := range feature.AddsModules {
-//				moduleSet[moduleName] = true
-//			}
-//		}
-//	}
-//
-//	modules := make([]string, 0, len(moduleSet))
-//	for moduleName := range moduleSet {
-//		modules = append(modules, moduleName)
-//	}
-//
-//	return modules
-//}
+// The schema's variables with the active modules' merged on, in priority
+// order.
+func (schema *Schema) GetAllVariables(activeModules []string) map[string]Variable {
+	allVars := make(map[string]Variable)
+
+	// initial variables
+	maps.Copy(allVars, schema.Variables)
+
+	// add variables from features
+	for _, moduleName := range schema.moduleOrder(activeModules) {
+		maps.Copy(allVars, schema.Modules[moduleName].AddsVariables)
+	}
+
+	return allVars
+}
+
+// The schema's properties with the active modules' merged on in priority
+// order, and their property modifiers folded into the formulas.
+func (schema *Schema) GetAllProperties(activeModules []string) map[string]Property {
+	allProperties := make(map[string]Property)
+
+	maps.Copy(allProperties, schema.Properties)
+
+	order := schema.moduleOrder(activeModules)
+	for _, moduleName := range order {
+		maps.Copy(allProperties, schema.Modules[moduleName].AddsProperties)
+	}
+	for _, moduleName := range order {
+		for name, modifier := range schema.Modules[moduleName].Modifies {
+			if property, ok := allProperties[name]; ok {
+				property.Formula = modifier.apply(property.Formula)
+				allProperties[name] = property
+			}
+		}
+	}
+
+	return allProperties
+}
+
+func (schema *Schema) GetActiveModules(activeFeatures []string) []string {
+	moduleSet := make(map[string]bool)
+
+	for _, featureName := range activeFeatures {
+		if feature, ok := schema.Features[featureName]; ok {
+			for _, moduleName := range feature.AddsModules {
+				moduleSet[moduleName] = true
+			}
+		}
+	}
+
+	modules := make([]string, 0, len(moduleSet))
+	for moduleName := range moduleSet {
+		modules = append(modules, moduleName)
+	}
+	slices.Sort(modules)
+
+	return modules
+}
 
 // Basic schema validation
 func (s *Schema) Validate() error {
@@ -211,42 +293,125 @@ func (s *Schema) Validate() error {
 	// - Check that variables from formulas in properties exist
 	// - Check for proper Initialization (fields exist, etc.)
 	// - Verify enum types
+	for _, name := range slices.Sorted(maps.Keys(s.Variables)) {
+		variable := s.Variables[name]
+		if err := variable.validate(); err != nil {
+			return fmt.Errorf("variable %q: %w", name, err)
+		}
+	}
+	for name, source := range s.DataSources {
+		if err := source.Validate(s); err != nil {
+			return fmt.Errorf("data source %q: %w", name, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Properties)) {
+		if _, ok := s.Variables[name]; ok {
+			return fmt.Errorf("property %q has the same name as a variable", name)
+		}
+		identifiers, err := FormulaIdentifiers(s.Properties[name].Formula)
+		if err != nil {
+			return fmt.Errorf("property %q: %w", name, err)
+		}
+		for _, identifier := range identifiers {
+			_, isVariable := s.Variables[identifier]
+			_, isProperty := s.Properties[identifier]
+			if !isVariable && !isProperty {
+				return fmt.Errorf("property %q: unknown name %q in formula", name, identifier)
+			}
+		}
+	}
+	if _, cyclic := propertyOrder(s.Properties); len(cyclic) > 0 {
+		return fmt.Errorf("property %q depends on itself", cyclic[0])
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Trackers)) {
+		if err := s.validateSlots(s.Trackers[name].Current, s.Trackers[name].Max); err != nil {
+			return fmt.Errorf("tracker %q: %w", name, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Events)) {
+		event := s.Events[name]
+		if err := event.validate(s); err != nil {
+			return fmt.Errorf("event %q: %w", name, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Features)) {
+		feature := s.Features[name]
+		if err := feature.validate(s); err != nil {
+			return fmt.Errorf("feature %q: %w", name, err)
+		}
+	}
+	if err := s.validateModules(); err != nil {
+		return err
+	}
+	if err := s.validateBonuses(); err != nil {
+		return err
+	}
+	if err := s.validateFeatureRules(); err != nil {
+		return err
+	}
+	if err := s.validateVisualization(&s.Visualization, "visualization"); err != nil {
+		return err
+	}
 	return nil
 }
 
 // Update active modules
-//func (i *Instance) UpdateActiveModules(schema *Schema) {
-//	i.ActiveModules = schema.GetActiveModules(i.ActiveFeatures)
-//}
-//
-//func (i *Instance) SetVariable(key string, value any) {
-//	i.VariableValues[key] = value
-//	i.UpdatedAt = time.Now()
-//}
-//
-//func (i *Instance) GetVariable(key string) (any, bool) {
-//	val, ok := i.VariableValues[key]
-//	return val, ok
-//}
-//
-//func (i *Instance) AddFeature(featureName string, schema *Schema) {
-//	if slices.Contains(i.ActiveFeatures, featureName) {
-//		return
-//	}
-//
-//	i.ActiveFeatures = append(i.ActiveFeatures, featureName)
-//	i.UpdateActiveModules(schema)
-//	i.UpdatedAt = time.Now()
-//}
-//
-//func (i *Instance) RemoveFeature(featureName string, schema *Schema) {
-//	for index, feature := range i.ActiveFeatures {
-//		if feature == featureName {
-//			i.ActiveFeatures = append(i.ActiveFeatures[:index], i.ActiveFeatures[index+1:]...)
-//			break
-//		}
-//	}
-//
-//	i.UpdateActiveModules(schema)
-//	i.UpdatedAt = time.Now()
-//}
+func (i *Instance) UpdateActiveModules(schema *Schema) {
+	i.ActiveModules = schema.GetActiveModules(i.ActiveFeatures)
+}
+
+func (i *Instance) SetVariable(key string, value any) {
+	if i.VariableValues == nil {
+		i.VariableValues = make(map[string]any)
+	}
+	i.VariableValues[key] = value
+	i.UpdatedAt = time.Now()
+}
+
+func (i *Instance) GetVariable(key string) (any, bool) {
+	val, ok := i.VariableValues[key]
+	return val, ok
+}
+
+// Numeric value of a variable, converting from the types JSON decoding and
+// the Go side may produce.
+func (i *Instance) GetNumber(key string) (float64, bool) {
+	val, ok := i.VariableValues[key]
+	if !ok {
+		return 0, false
+	}
+	return AsNumber(val)
+}
+
+// Adds a feature by hand, enforcing its requirements, exclusions and
+// groups (see Schema.CheckAddFeature).
+func (i *Instance) AddFeature(featureName string, schema *Schema, opts EvalOptions) error {
+	if slices.Contains(i.ActiveFeatures, featureName) {
+		return nil
+	}
+	if err := schema.CheckAddFeature(i, featureName, opts); err != nil {
+		return err
+	}
+
+	i.ActiveFeatures = append(i.ActiveFeatures, featureName)
+	i.UpdateActiveFeatures(schema, opts)
+	i.UpdatedAt = time.Now()
+	return nil
+}
+
+func (i *Instance) RemoveFeature(featureName string, schema *Schema, opts EvalOptions) error {
+	if err := schema.CheckRemoveFeature(i, featureName, opts); err != nil {
+		return err
+	}
+
+	for index, feature := range i.ActiveFeatures {
+		if feature == featureName {
+			i.ActiveFeatures = append(i.ActiveFeatures[:index], i.ActiveFeatures[index+1:]...)
+			break
+		}
+	}
+
+	i.UpdateActiveFeatures(schema, opts)
+	i.UpdatedAt = time.Now()
+	return nil
+}
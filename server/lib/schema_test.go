@@ -121,20 +121,20 @@ func TestSchemaUnmarshalFromJSON(t *testing.T) {
 
 func TestInstanceJSONSerialization(t *testing.T) {
 	instance := Instance{
-		ID:     "instance-456",
+		ID:       "instance-456",
 		SchemaID: "schema-12",
-//		Visualization: Visualization{
-//			Name: "Main",
-//			Type: VisDefault,
-//		},
+		Visualization: Visualization{
+			Name: "Main",
+			Type: VisDefault,
+		},
 		UserID: "user-789",
 		Name:   "My Character",
-//		VariableValues: map[string]any{
-//			"health": 100.0,
-//			"name":   "Hero",
-//		},
-//		ActiveFeatures: []string{"combat"},
-//		ActiveModules:  []string{"weapons"},
+		VariableValues: map[string]any{
+			"health": 100.0,
+			"name":   "Hero",
+		},
+		ActiveFeatures: []string{"combat"},
+		ActiveModules:  []string{"weapons"},
 		CreatedAt:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 		UpdatedAt:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 	}
@@ -238,9 +238,12 @@ func TestInstanceUnmarshalFromJSON(t *testing.T) {
 	if instance.Name != "Test Instance" {
 		t.Errorf("Expected Name 'Test Instance', got '%s'", instance.Name)
 	}
-//	if len(instance.ActiveFeatures) != 1 || instance.ActiveFeatures[0] != "feature1" {
-//		t.Errorf("ActiveFeatures not correctly unmarshaled: %v", instance.ActiveFeatures)
-//	}
+	if len(instance.ActiveFeatures) != 1 || instance.ActiveFeatures[0] != "feature1" {
+		t.Errorf("ActiveFeatures not correctly unmarshaled: %v", instance.ActiveFeatures)
+	}
+	if health, ok := instance.GetNumber("health"); !ok || health != 50 {
+		t.Errorf("Expected health 50, got %v", instance.VariableValues["health"])
+	}
 }
 
 //func TestVariableTypes(t *testing.T) {
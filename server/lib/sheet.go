@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"html/template"
+	"io"
+	"math"
+)
+
+// Printable sheets //
+
+// An instance laid out for paper, rendered as HTML (RenderHTML) or PDF
+// (RenderPDF). Layouts translate to paper like this:
+//   - grid: a fixed number of columns
+//   - row: children side by side in equal widths
+//   - card, accordion: a bordered box, always expanded
+//   - tabs: one section per tab, each starting on a new page
+//   - slot tracker: a box per slot, spent ones filled in
+//
+// Empty values are left blank to be filled in by hand.
+type Sheet struct {
+	Title       string
+	Subtitle    string
+	Description string
+	Root        ResolvedVisualization
+}
+
+// Slot trackers with more slots than this only print the count.
+const maxSlotBoxes = 30
+
+func NewSheet(schema *Schema, instance *Instance, opts EvalOptions) *Sheet {
+	evaluation := schema.EvaluateWith(instance, opts)
+	return &Sheet{
+		Title:       instance.Name,
+		Subtitle:    schema.Name,
+		Description: instance.Description,
+		Root:        schema.ResolveVisualization(instance, evaluation),
+	}
+}
+
+// One entry per slot, true for spent slots. nil when the tracker has no
+// usable maximum or too many slots to draw.
+func slotBoxes(node ResolvedVisualization) []bool {
+	max, ok := AsNumber(node.Max)
+	if !ok || max <= 0 || max > maxSlotBoxes {
+		return nil
+	}
+	current, _ := AsNumber(node.Value)
+	current = math.Min(math.Max(current, 0), max)
+
+	boxes := make([]bool, int(max))
+	for i := int(current); i < len(boxes); i++ {
+		boxes[i] = true
+	}
+	return boxes
+}
+
+// HTML //
+
+var sheetTemplate = template.Must(template.New("sheet").Funcs(template.FuncMap{
+	"text":  FormatValue,
+	"slots": slotBoxes,
+	"is":    func(node ResolvedVisualization, t string) bool { return string(node.Type) == t },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+@page { size: A4; margin: 12mm; }
+body { font: 11pt/1.35 Helvetica, Arial, sans-serif; color: #111; margin: 0 auto; max-width: 186mm; }
+header { border-bottom: 2px solid #111; margin-bottom: 4mm; }
+h1 { font-size: 20pt; margin: 0; }
+h2 { font-size: 13pt; margin: 3mm 0 2mm; }
+.subtitle { color: #555; margin: 0 0 1mm; }
+.description { margin: 1mm 0 2mm; white-space: pre-wrap; }
+.items.grid { display: grid; gap: 2mm 4mm; }
+.items.row { display: flex; gap: 4mm; }
+.items.row > * { flex: 1; min-width: 0; }
+.card, .accordion { border: 1px solid #111; border-radius: 2mm; padding: 1mm 3mm 3mm; margin: 2mm 0; break-inside: avoid; }
+.tab + .tab { break-before: page; }
+.field { display: flex; flex-direction: column; padding: 1mm 0; break-inside: avoid; }
+.label { font-size: 8pt; text-transform: uppercase; color: #555; }
+.value { min-height: 1.35em; border-bottom: 1px solid #999; white-space: pre-wrap; }
+.slot { display: inline-block; width: 3.5mm; height: 3.5mm; border: 1px solid #111; margin: 1mm 1mm 0 0; }
+.slot.spent { background: #111; }
+.error { color: #b00; font-size: 8pt; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+{{with .Subtitle}}<p class="subtitle">{{.}}</p>{{end}}
+{{with .Description}}<p class="description">{{.}}</p>{{end}}
+</header>
+{{template "node" .Root}}
+</body>
+</html>
+{{define "node"}}
+{{- if is . "single_field"}}
+<div class="field"><span class="label">{{.Name}}</span><span class="value">{{text .Value}}</span>{{with .Error}}<span class="error">{{.}}</span>{{end}}</div>
+{{- else if is . "slot_tracker"}}
+<div class="field slots"><span class="label">{{.Name}}</span><span>{{range slots .}}<span class="slot{{if .}} spent{{end}}"></span>{{end}} {{text .Value}} / {{text .Max}}</span>{{with .Error}}<span class="error">{{.}}</span>{{end}}</div>
+{{- else if is . "tabs"}}
+{{range .Children}}<section class="tab">{{template "node" .}}</section>
+{{end}}
+{{- else}}
+<div class="block {{.Type}}">{{with .Name}}<h2>{{.}}</h2>{{end}}{{with .Error}}<span class="error">{{.}}</span>{{end}}
+{{if is . "grid"}}<div class="items grid" style="grid-template-columns: repeat({{.Columns}}, 1fr)">{{else}}<div class="items{{if is . "row"}} row{{end}}">{{end}}
+{{range .Children}}{{template "node" .}}
+{{end}}</div>
+</div>
+{{- end}}
+{{end}}`))
+
+func (s *Sheet) RenderHTML(w io.Writer) error {
+	return sheetTemplate.Execute(w, s)
+}
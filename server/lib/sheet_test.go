@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func sheetTestSchema() *Schema {
+	field := func(name string) Visualization {
+		config, _ := json.Marshal(map[string]string{"field": name})
+		return Visualization{Type: VisSingleField, Config: config}
+	}
+	return &Schema{
+		Name: "D&D 5e",
+		Variables: map[string]Variable{
+			"strength": {Type: TypeNumber, Default: 10.0},
+			"class":    {Type: TypeString},
+			"slots":    {Type: TypeNumber},
+			"notes":    {Type: TypeString},
+		},
+		Properties: map[string]Property{
+			"modifier":  {Formula: "(strength - 10) / 2", Format: FormatFloor},
+			"max_slots": {Formula: "4"},
+		},
+		Visualization: Visualization{
+			Type: VisTabs,
+			ChildVisualizations: []Visualization{
+				{Name: "Stats", Type: VisGrid, Config: json.RawMessage(`{"columns":3}`), ChildVisualizations: []Visualization{
+					field("strength"), field("modifier"), field("class"), field("missing"),
+				}},
+				{Name: "Spells", Type: VisCard, ChildVisualizations: []Visualization{
+					{Name: "Spell slots", Type: VisSlotTracker, Config: json.RawMessage(`{"current":"slots","max":"max_slots"}`)},
+					{Type: VisRow, ChildVisualizations: []Visualization{field("notes"), field("class")}},
+				}},
+			},
+		},
+	}
+}
+
+func TestSheetRender(t *testing.T) {
+	schema := sheetTestSchema()
+	instance := &Instance{
+		Name:        "Aria <the Bold>",
+		Description: "Café regular",
+		VariableValues: map[string]any{
+			"strength": 15.0, "slots": 1.0, "class": "wizard",
+			"notes": strings.Repeat("a long note that has to wrap ", 20),
+		},
+	}
+	sheet := NewSheet(schema, instance, EvalOptions{})
+
+	var html bytes.Buffer
+	if err := sheet.RenderHTML(&html); err != nil {
+		t.Fatalf("html failed: %v", err)
+	}
+	for _, want := range []string{
+		"Aria &lt;the Bold&gt;", `class="tab"`, "repeat(3, 1fr)", "wizard", `class="slot spent"`, "unknown field",
+	} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("html missing %q", want)
+		}
+	}
+
+	var pdf bytes.Buffer
+	if err := sheet.RenderPDF(&pdf); err != nil {
+		t.Fatalf("pdf failed: %v", err)
+	}
+	if !bytes.HasPrefix(pdf.Bytes(), []byte("%PDF-")) {
+		t.Fatal("not a pdf")
+	}
+	// one page per tab
+	if pages := bytes.Count(pdf.Bytes(), []byte("/Type /Page\n")); pages != 2 {
+		t.Errorf("expected 2 pages, got %d", pages)
+	}
+}
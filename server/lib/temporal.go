@@ -0,0 +1,242 @@
+package lib
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezones work without the system's database
+
+	"github.com/expr-lang/expr/ast"
+)
+
+// Dates and times //
+
+// Three variable types hold points and spans of time:
+//   - date: a calendar day, "2006-01-02", with no timezone (birthdays).
+//   - datetime: a moment, stored as RFC 3339 with its offset. Values written
+//     without an offset are read in the variable's timezone, UTC by default.
+//   - duration: a span like "1h30m", "45m" or "2d" (days are 24 hours),
+//     stored the way Go prints it: "1h30m0s". Plain numbers are seconds.
+//
+// In formulas dates and datetimes are times and durations are durations, so
+// "cook_time + prep_time", "now() - started" and "deadline < now()" work, as
+// do now(), age(birth_date) and days_between(a, b). Property results that are
+// times or durations come out as strings in the stored formats.
+
+const dateLayout = "2006-01-02"
+
+// Layouts accepted for datetimes without an offset.
+var localLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", dateLayout}
+
+// Formulas find the evaluation's current time in their environment under
+// this key, which no formula can name (see EvalOptions.Now).
+const clockKey = "$now"
+
+// The time now() and age() go by: the evaluation's, or the real one for an
+// environment without it.
+func formulaNow(env any) time.Time {
+	if env, ok := env.(map[string]any); ok {
+		if now, ok := env[clockKey].(time.Time); ok {
+			return now
+		}
+	}
+	return time.Now()
+}
+
+var clockFunctions = []string{"now", "age"}
+
+// Hands the environment to the functions that read the clock, so
+// "age(birth_date)" runs as age(birth_date, $env).
+type clockPatch struct{}
+
+func (clockPatch) Visit(node *ast.Node) {
+	call, ok := (*node).(*ast.CallNode)
+	if !ok {
+		return
+	}
+	if callee, ok := call.Callee.(*ast.IdentifierNode); !ok || !slices.Contains(clockFunctions, callee.Value) {
+		return
+	}
+	arguments := append(slices.Clone(call.Arguments), &ast.IdentifierNode{Value: "$env"})
+	ast.Patch(node, &ast.CallNode{Callee: call.Callee, Arguments: arguments})
+}
+
+// Whole years from birth to now.
+func age(birth, now time.Time) int {
+	now = now.In(birth.Location())
+	years := now.Year() - birth.Year()
+	if now.Month() < birth.Month() || now.Month() == birth.Month() && now.Day() < birth.Day() {
+		years--
+	}
+	return years
+}
+
+// Calendar days from a to b, negative when b comes first.
+func daysBetween(a, b time.Time) int {
+	day := func(t time.Time) int64 {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+	}
+	return int(day(b) - day(a))
+}
+
+func isTemporal(t VariableType) bool {
+	return t == TypeDate || t == TypeDateTime || t == TypeDuration
+}
+
+func (v *Variable) validateTimezone() error {
+	if v.Timezone == "" {
+		return nil
+	}
+	if v.Type != TypeDateTime {
+		return fmt.Errorf("only datetimes can have a timezone")
+	}
+	if _, err := time.LoadLocation(v.Timezone); err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	return nil
+}
+
+func (v *Variable) coerceTemporal(value any) (any, error) {
+	if t, ok := value.(time.Time); ok {
+		if v.Type == TypeDate {
+			return t.Format(dateLayout), nil
+		}
+		if v.Type == TypeDateTime {
+			return t.Format(time.RFC3339), nil
+		}
+	}
+	if d, ok := value.(time.Duration); ok && v.Type == TypeDuration {
+		return d.String(), nil
+	}
+	if seconds, ok := AsNumber(value); ok && v.Type == TypeDuration {
+		return time.Duration(seconds * float64(time.Second)).String(), nil
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected %s, got %T", v.Type, value)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+
+	switch v.Type {
+	case TypeDate:
+		t, err := time.Parse(dateLayout, text)
+		if err != nil {
+			return nil, fmt.Errorf("expected a date like 2006-01-02, got %q", text)
+		}
+		return t.Format(dateLayout), nil
+	case TypeDateTime:
+		t, err := v.parseDateTime(text)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format(time.RFC3339), nil
+	default:
+		d, err := parseDuration(text)
+		if err != nil {
+			return nil, err
+		}
+		return d.String(), nil
+	}
+}
+
+func (v *Variable) parseDateTime(text string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+	location := time.UTC
+	if v.Timezone != "" {
+		loaded, err := time.LoadLocation(v.Timezone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("timezone: %w", err)
+		}
+		location = loaded
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, text, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("expected a datetime like 2006-01-02T15:04:05Z07:00, got %q", text)
+}
+
+// Go's duration syntax, plus days in front: "2d", "1d12h", "1.5d".
+func parseDuration(text string) (time.Duration, error) {
+	negative := strings.HasPrefix(text, "-")
+	days, rest, hasDays := strings.Cut(strings.TrimPrefix(text, "-"), "d")
+	if !hasDays {
+		days, rest = "0", days
+	}
+
+	count, err := strconv.ParseFloat(days, 64)
+	if err != nil || count < 0 || math.IsInf(count, 0) {
+		return 0, fmt.Errorf("expected a duration like 1h30m or 2d, got %q", text)
+	}
+	duration := time.Duration(count * float64(24*time.Hour))
+	if rest != "" {
+		parsed, err := time.ParseDuration(rest)
+		if err != nil || parsed < 0 {
+			return 0, fmt.Errorf("expected a duration like 1h30m or 2d, got %q", text)
+		}
+		duration += parsed
+	}
+	if negative {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+// A time from a formula value: a time, a date or an RFC 3339 datetime.
+func asTime(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		if t, err := time.Parse(dateLayout, v); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		return time.Time{}, fmt.Errorf("%q isn't a date", v)
+	}
+	return time.Time{}, fmt.Errorf("expected a date, got %T", value)
+}
+
+// A copy of the values with dates, datetimes and durations parsed for
+// formulas. Values that don't parse are left as they are.
+func temporalValues(values map[string]any, variables map[string]Variable) map[string]any {
+	parsed := maps.Clone(values)
+	for name, variable := range variables {
+		text, ok := values[name].(string)
+		if !ok || !isTemporal(variable.Type) {
+			continue
+		}
+		if variable.Type == TypeDuration {
+			if d, err := parseDuration(text); err == nil {
+				parsed[name] = d
+			}
+		} else if t, err := variable.parseDateTime(text); err == nil {
+			parsed[name] = t
+		}
+	}
+	return parsed
+}
+
+// Times and durations as stored in variables, for property results.
+func exportTemporal(value any) any {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case time.Duration:
+		return v.String()
+	}
+	return value
+}
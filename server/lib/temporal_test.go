@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoerceTemporal(t *testing.T) {
+	tests := []struct {
+		variable Variable
+		value    any
+		want     any
+	}{
+		{Variable{Type: TypeDate}, " 1815-12-10 ", "1815-12-10"},
+		{Variable{Type: TypeDate}, "", nil},
+		{Variable{Type: TypeDateTime}, "2024-03-01T09:30:00+01:00", "2024-03-01T09:30:00+01:00"},
+		{Variable{Type: TypeDateTime}, "2024-03-01 09:30", "2024-03-01T09:30:00Z"},
+		{Variable{Type: TypeDateTime, Timezone: "Europe/London"}, "2024-07-01T09:30", "2024-07-01T09:30:00+01:00"},
+		{Variable{Type: TypeDateTime, Timezone: "Europe/London"}, "2024-01-01T09:30", "2024-01-01T09:30:00Z"},
+		{Variable{Type: TypeDuration}, "90m", "1h30m0s"},
+		{Variable{Type: TypeDuration}, "1d12h", "36h0m0s"},
+		{Variable{Type: TypeDuration}, 45.0, "45s"},
+		{Variable{Type: TypeDate}, time.Date(2000, 2, 29, 23, 0, 0, 0, time.UTC), "2000-02-29"},
+	}
+	for _, test := range tests {
+		got, err := test.variable.Coerce(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("%s %v: got %v %v, want %v", test.variable.Type, test.value, got, err, test.want)
+		}
+	}
+
+	bad := []struct {
+		variable Variable
+		value    any
+	}{
+		{Variable{Type: TypeDate}, "10/12/1815"},
+		{Variable{Type: TypeDate}, "2023-02-29"},
+		{Variable{Type: TypeDateTime}, "yesterday"},
+		{Variable{Type: TypeDuration}, "an hour"},
+		{Variable{Type: TypeDuration}, "1h-30m"},
+		{Variable{Type: TypeDate}, true},
+	}
+	for _, test := range bad {
+		if got, err := test.variable.Coerce(test.value); err == nil {
+			t.Errorf("%s %v accepted as %v", test.variable.Type, test.value, got)
+		}
+	}
+
+	for _, variable := range []Variable{{Type: TypeDate, Timezone: "UTC"}, {Type: TypeDateTime, Timezone: "Mars/Olympus"}} {
+		if err := variable.validate(); err == nil {
+			t.Errorf("timezone %q on a %s accepted", variable.Timezone, variable.Type)
+		}
+	}
+
+	schema := &Schema{Variables: map[string]Variable{
+		"born":  {Type: TypeDate},
+		"alarm": {Type: TypeDateTime, Timezone: "Europe/London"},
+	}}
+	instance := &Instance{VariableValues: map[string]any{"born": "1815-12-10", "alarm": "2024-07-01T09:30", "note": "kept"}}
+	if err := schema.CoerceValues(instance); err != nil {
+		t.Fatal(err)
+	}
+	if instance.VariableValues["alarm"] != "2024-07-01T09:30:00+01:00" || instance.VariableValues["note"] != "kept" {
+		t.Errorf("coerced values: %v", instance.VariableValues)
+	}
+	instance.VariableValues["born"] = "yesterday"
+	if err := schema.CoerceValues(instance); err == nil {
+		t.Error("a date of \"yesterday\" accepted")
+	}
+}
+
+func TestTemporalFormulas(t *testing.T) {
+	at := func(now time.Time) EvalOptions {
+		return EvalOptions{Now: func() time.Time { return now }}
+	}
+
+	schema := &Schema{
+		Variables: map[string]Variable{
+			"birth_date": {Type: TypeDate, Default: "1990-06-16"},
+			"married":    {Type: TypeDate},
+			"prep_time":  {Type: TypeDuration},
+			"cook_time":  {Type: TypeDuration},
+			"deadline":   {Type: TypeDateTime, Timezone: "America/New_York"},
+		},
+		Properties: map[string]Property{
+			"age":           {Formula: "age(birth_date)"},
+			"days_married":  {Formula: "days_between(married, now())"},
+			"total_time":    {Formula: "prep_time + cook_time"},
+			"long":          {Formula: "prep_time + cook_time > duration(\"1h\")"},
+			"overdue":       {Formula: "deadline < now()"},
+			"reminder":      {Formula: "deadline - duration(\"24h\")"},
+			"today":         {Formula: "now()"},
+			"spouse_age":    {Formula: "age(\"1991-01-01\")"},
+			"hours_to_dine": {Formula: "(deadline - now()).Hours()"},
+		},
+		Features: map[string]Feature{
+			"adult": {Condition: "age(birth_date) >= 18"},
+		},
+	}
+	if err := schema.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &Instance{VariableValues: map[string]any{
+		"married":   "2024-06-01",
+		"prep_time": "20m",
+		"cook_time": "1h",
+		"deadline":  "2024-06-15T10:00",
+	}}
+	evaluation := schema.EvaluateWith(instance, at(time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)))
+	want := map[string]any{
+		"age":           33.0, // turns 34 tomorrow
+		"days_married":  14.0,
+		"total_time":    "1h20m0s",
+		"long":          true,
+		"overdue":       false, // 10:00 in New York is 14:00 UTC
+		"reminder":      "2024-06-14T10:00:00-04:00",
+		"today":         "2024-06-15T12:00:00Z",
+		"spouse_age":    33.0,
+		"hours_to_dine": 2.0,
+	}
+	for name, value := range want {
+		if evaluation.Properties[name] != value {
+			t.Errorf("%s: got %v (%v), want %v", name, evaluation.Properties[name], evaluation.Errors[name], value)
+		}
+	}
+	if evaluation.Variables["deadline"] != "2024-06-15T10:00" {
+		t.Errorf("stored value changed: %v", evaluation.Variables["deadline"])
+	}
+	if _, ok := evaluation.Variables[clockKey]; ok {
+		t.Error("the clock leaked into the variables")
+	}
+	if len(evaluation.ActiveFeatures) != 1 {
+		t.Errorf("active features: %v %v", evaluation.ActiveFeatures, evaluation.FeatureErrors)
+	}
+
+	if evaluation := schema.EvaluateWith(instance, at(time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC))); evaluation.Properties["age"] != 34.0 {
+		t.Errorf("age on the birthday: %v", evaluation.Properties["age"])
+	}
+	if evaluation := schema.Evaluate(instance); evaluation.Properties["age"] == nil {
+		t.Errorf("age by the real clock: %v", evaluation.Errors["age"])
+	}
+}
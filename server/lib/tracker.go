@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Slot trackers //
+
+// Limited uses like spell slots, ki points or hit dice: a number variable
+// holding what's left and a maximum, usually a property. The current value
+// always stays between 0 and the maximum; an instance that never set it is
+// full.
+type SlotTracker struct {
+	Current string   `json:"current"`
+	Max     string   `json:"max"`
+	ResetOn []string `json:"reset_on,omitempty"` // events that refill it, e.g. "long rest"
+}
+
+type TrackerState struct {
+	Name    string  `json:"name"`
+	Current float64 `json:"current"`
+	Max     float64 `json:"max"`
+}
+
+var (
+	ErrUnknownTracker = errors.New("unknown tracker")
+	ErrOutOfRange     = errors.New("out of range")
+)
+
+// Variables of any module count, active or not.
+func (s *Schema) validateSlots(current, max string) error {
+	variables, _ := s.declaredFields()
+	variable, ok := variables[current]
+	if !ok {
+		return fmt.Errorf("current must be a variable, got %q", current)
+	}
+	if variable.Type != TypeNumber {
+		return fmt.Errorf("current %q must be a number, not %s", current, variable.Type)
+	}
+	if variable, ok := variables[max]; ok && variable.Type != TypeNumber {
+		return fmt.Errorf("max %q must be a number, not %s", max, variable.Type)
+	}
+	if !s.hasField(max) {
+		return fmt.Errorf("max must be a property or variable, got %q", max)
+	}
+	return nil
+}
+
+func (s *Schema) tracker(name string) (SlotTracker, error) {
+	tracker, ok := s.Trackers[name]
+	if !ok {
+		return SlotTracker{}, fmt.Errorf("%w %q", ErrUnknownTracker, name)
+	}
+	return tracker, nil
+}
+
+// Trackers that reset on the event, sorted by name. Event names are matched
+// ignoring case.
+func (s *Schema) TrackersResetOn(event string) []string {
+	var names []string
+	for _, name := range slices.Sorted(maps.Keys(s.Trackers)) {
+		if slices.ContainsFunc(s.Trackers[name].ResetOn, func(e string) bool { return strings.EqualFold(e, event) }) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *Schema) TrackerState(instance *Instance, name string, opts EvalOptions) (TrackerState, error) {
+	tracker, err := s.tracker(name)
+	if err != nil {
+		return TrackerState{}, err
+	}
+	return s.trackerState(instance, name, tracker, s.EvaluateWith(instance, opts))
+}
+
+// State of every tracker, sorted by name.
+func (s *Schema) TrackerStates(instance *Instance, opts EvalOptions) ([]TrackerState, error) {
+	evaluation := s.EvaluateWith(instance, opts)
+	states := []TrackerState{}
+	for _, name := range slices.Sorted(maps.Keys(s.Trackers)) {
+		state, err := s.trackerState(instance, name, s.Trackers[name], evaluation)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (s *Schema) trackerState(instance *Instance, name string, tracker SlotTracker, evaluation *Evaluation) (TrackerState, error) {
+	value, _ := evaluation.Lookup(tracker.Max)
+	max, ok := AsNumber(value)
+	if !ok {
+		return TrackerState{}, fmt.Errorf("tracker %q: max %q is %v, not a number", name, tracker.Max, value)
+	}
+	max = math.Max(max, 0)
+
+	current, ok := instance.GetNumber(tracker.Current)
+	if !ok {
+		current = max
+	}
+	return TrackerState{Name: name, Current: math.Min(math.Max(current, 0), max), Max: max}, nil
+}
+
+func (s *Schema) setSlots(instance *Instance, name string, opts EvalOptions, change func(TrackerState) (float64, error)) (TrackerState, error) {
+	tracker, err := s.tracker(name)
+	if err != nil {
+		return TrackerState{}, err
+	}
+	state, err := s.trackerState(instance, name, tracker, s.EvaluateWith(instance, opts))
+	if err != nil {
+		return TrackerState{}, err
+	}
+
+	current, err := change(state)
+	if err != nil {
+		return TrackerState{}, err
+	}
+	state.Current = current
+	instance.SetVariable(tracker.Current, current)
+	return state, nil
+}
+
+func checkAmount(amount float64) error {
+	if amount <= 0 || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return fmt.Errorf("amount must be a positive number, got %v", amount)
+	}
+	return nil
+}
+
+// Uses up slots. Fails without changing anything when fewer are left.
+func (s *Schema) SpendSlots(instance *Instance, name string, amount float64, opts EvalOptions) (TrackerState, error) {
+	if err := checkAmount(amount); err != nil {
+		return TrackerState{}, err
+	}
+	return s.setSlots(instance, name, opts, func(state TrackerState) (float64, error) {
+		if amount > state.Current {
+			return 0, fmt.Errorf("can't spend %v %s with %v left: %w", amount, name, state.Current, ErrOutOfRange)
+		}
+		return state.Current - amount, nil
+	})
+}
+
+// Gives slots back, up to the maximum.
+func (s *Schema) RestoreSlots(instance *Instance, name string, amount float64, opts EvalOptions) (TrackerState, error) {
+	if err := checkAmount(amount); err != nil {
+		return TrackerState{}, err
+	}
+	return s.setSlots(instance, name, opts, func(state TrackerState) (float64, error) {
+		return math.Min(state.Current+amount, state.Max), nil
+	})
+}
+
+// Refills the tracker to its maximum.
+func (s *Schema) ResetSlots(instance *Instance, name string, opts EvalOptions) (TrackerState, error) {
+	return s.setSlots(instance, name, opts, func(state TrackerState) (float64, error) {
+		return state.Max, nil
+	})
+}
@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func trackerTestSchema() *Schema {
+	return &Schema{
+		Variables: map[string]Variable{
+			"level":       {Type: TypeNumber, Default: 3.0},
+			"spell_slots": {Type: TypeNumber},
+			"ki":          {Type: TypeNumber},
+		},
+		Properties: map[string]Property{
+			"max_slots": {Formula: "level + 1"},
+		},
+		Trackers: map[string]SlotTracker{
+			"spells": {Current: "spell_slots", Max: "max_slots", ResetOn: []string{"Long Rest"}},
+			"ki":     {Current: "ki", Max: "level", ResetOn: []string{"short rest", "long rest"}},
+		},
+	}
+}
+
+func TestSlotTrackers(t *testing.T) {
+	schema := trackerTestSchema()
+	if err := schema.Validate(); err != nil {
+		t.Fatalf("valid schema rejected: %v", err)
+	}
+	instance := &Instance{}
+
+	// unset means full
+	state, err := schema.TrackerState(instance, "spells", EvalOptions{})
+	if err != nil || state.Current != 4 || state.Max != 4 {
+		t.Fatalf("unexpected initial state %+v (%v)", state, err)
+	}
+
+	state, err = schema.SpendSlots(instance, "spells", 3, EvalOptions{})
+	if err != nil || state.Current != 1 {
+		t.Fatalf("spend: %+v (%v)", state, err)
+	}
+	if _, err := schema.SpendSlots(instance, "spells", 2, EvalOptions{}); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("overspending should fail, got %v", err)
+	}
+	if current, _ := instance.GetNumber("spell_slots"); current != 1 {
+		t.Errorf("failed spend changed the value to %v", current)
+	}
+
+	state, _ = schema.RestoreSlots(instance, "spells", 10, EvalOptions{})
+	if state.Current != 4 {
+		t.Errorf("restore should stop at the max, got %v", state.Current)
+	}
+	if _, err := schema.RestoreSlots(instance, "spells", -1, EvalOptions{}); err == nil {
+		t.Error("negative amounts should fail")
+	}
+
+	schema.SpendSlots(instance, "spells", 4, EvalOptions{})
+	if state, _ := schema.ResetSlots(instance, "spells", EvalOptions{}); state.Current != 4 {
+		t.Errorf("reset: got %v", state.Current)
+	}
+
+	// the max shrinking caps what's left
+	instance.SetVariable("level", 1.0)
+	if state, _ := schema.TrackerState(instance, "spells", EvalOptions{}); state.Current != 2 || state.Max != 2 {
+		t.Errorf("unexpected state after level drop %+v", state)
+	}
+
+	if _, err := schema.SpendSlots(instance, "rage", 1, EvalOptions{}); !errors.Is(err, ErrUnknownTracker) {
+		t.Errorf("expected unknown tracker, got %v", err)
+	}
+
+	if got := schema.TrackersResetOn("long rest"); !slices.Equal(got, []string{"ki", "spells"}) {
+		t.Errorf("long rest resets %v", got)
+	}
+	if got := schema.TrackersResetOn("short rest"); !slices.Equal(got, []string{"ki"}) {
+		t.Errorf("short rest resets %v", got)
+	}
+}
+
+func TestValidateSlotTrackers(t *testing.T) {
+	schema := trackerTestSchema()
+	schema.Trackers["bad"] = SlotTracker{Current: "max_slots", Max: "level"}
+	if err := schema.Validate(); err == nil {
+		t.Error("a property can't hold the current value")
+	}
+
+	// the current value may live in a module
+	schema = trackerTestSchema()
+	schema.Modules = map[string]Module{"monk": {AddsVariables: map[string]Variable{"chi": {Type: TypeNumber}}}}
+	schema.Trackers["chi"] = SlotTracker{Current: "chi", Max: "level"}
+	if err := schema.Validate(); err != nil {
+		t.Errorf("tracker over a module variable rejected: %v", err)
+	}
+
+	schema = trackerTestSchema()
+	schema.Visualization = Visualization{Type: VisSlotTracker, Config: []byte(`{"tracker":"rage"}`)}
+	if err := schema.Validate(); err == nil {
+		t.Error("unknown tracker should be rejected")
+	}
+
+	schema.Visualization.Config = []byte(`{"tracker":"spells"}`)
+	if err := schema.Validate(); err != nil {
+		t.Fatalf("tracker reference rejected: %v", err)
+	}
+	resolved := schema.ResolveVisualization(&Instance{}, schema.Evaluate(&Instance{}))
+	if resolved.Name != "spells" || resolved.Field != "spell_slots" || resolved.Value != 4.0 || resolved.Max != 4.0 {
+		t.Errorf("unexpected resolved tracker %+v", resolved)
+	}
+}
@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Converts a stored value to a float64 if it is numeric.
+func AsNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// Converts a value from outside (JSON response, form input) into the type the
+// variable stores, checking enum options and min/max along the way.
+// Strings are parsed for number and boolean variables.
+func (v *Variable) Coerce(value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch v.Type {
+	case TypeNumber:
+		number, ok := AsNumber(value)
+		if !ok {
+			text, isString := value.(string)
+			if !isString {
+				return nil, fmt.Errorf("expected number, got %T", value)
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+			if err != nil {
+				return nil, fmt.Errorf("expected number, got %q", text)
+			}
+			number = parsed
+		}
+		if v.Min != nil && number < *v.Min {
+			return nil, fmt.Errorf("%v is below the minimum %v", number, *v.Min)
+		}
+		if v.Max != nil && number > *v.Max {
+			return nil, fmt.Errorf("%v is above the maximum %v", number, *v.Max)
+		}
+		return number, nil
+
+	case TypeString, TypeMarkdown:
+		switch s := value.(type) {
+		case string:
+			return s, nil
+		case map[string]any, []any:
+			return nil, fmt.Errorf("expected string, got %T", value)
+		}
+		if number, ok := AsNumber(value); ok {
+			return strconv.FormatFloat(number, 'f', -1, 64), nil
+		}
+		return fmt.Sprint(value), nil
+
+	case TypeBoolean:
+		switch b := value.(type) {
+		case bool:
+			return b, nil
+		case string:
+			parsed, err := strconv.ParseBool(strings.TrimSpace(b))
+			if err != nil {
+				return nil, fmt.Errorf("expected boolean, got %q", b)
+			}
+			return parsed, nil
+		}
+		return nil, fmt.Errorf("expected boolean, got %T", value)
+
+	case TypeEnum:
+		option, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected one of %v, got %T", v.Options, value)
+		}
+		if !slices.Contains(v.Options, option) {
+			return nil, fmt.Errorf("%q is not one of %v", option, v.Options)
+		}
+		return option, nil
+
+	case TypeReference:
+		id, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected instance id, got %T", value)
+		}
+		id = strings.TrimSpace(id)
+		if id == "" {
+			return nil, nil
+		}
+		return id, nil
+
+	case TypeAttachment:
+		hash, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected attachment hash, got %T", value)
+		}
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if hash == "" {
+			return nil, nil
+		}
+		if !IsAttachmentHash(hash) {
+			return nil, fmt.Errorf("%q isn't an attachment hash", hash)
+		}
+		return hash, nil
+
+	case TypeDate, TypeDateTime, TypeDuration:
+		return v.coerceTemporal(value)
+
+	case TypeArray:
+		list, ok := value.([]any)
+		if !ok {
+			return nil, fmt.Errorf("expected array, got %T", value)
+		}
+		if v.Items == nil {
+			return list, nil
+		}
+		items := make([]any, len(list))
+		for index, item := range list {
+			coerced, err := v.Items.Coerce(item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", index, err)
+			}
+			items[index] = coerced
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("unknown variable type %q", v.Type)
+}
+
+// Converts each of the instance's values to its variable's type, as
+// Variable.Coerce does, in place. Values of names that aren't variables of
+// the schema or its modules are left alone.
+func (s *Schema) CoerceValues(instance *Instance) error {
+	variables, _ := s.declaredFields()
+	for _, name := range slices.Sorted(maps.Keys(instance.VariableValues)) {
+		variable, ok := variables[name]
+		if !ok {
+			continue
+		}
+		value, err := variable.Coerce(instance.VariableValues[name])
+		if err != nil {
+			return fmt.Errorf("variable %q: %w", name, err)
+		}
+		instance.VariableValues[name] = value
+	}
+	return nil
+}
+
+// Checks that the instance has a value for every required variable of the
+// schema. Module variables are never required (see Schema.JSONSchema).
+func (s *Schema) CheckRequired(instance *Instance) error {
+	for _, name := range slices.Sorted(maps.Keys(s.Variables)) {
+		if s.Variables[name].Required && instance.VariableValues[name] == nil {
+			return fmt.Errorf("variable %q is required", name)
+		}
+	}
+	return nil
+}
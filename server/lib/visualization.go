@@ -0,0 +1,336 @@
+package lib
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Visualization config //
+
+// Visualization.Config holds one of these, depending on the type. Types not
+// listed take no config; custom visualizations may hold anything, since only
+// the client that draws them knows what it means.
+
+type GridConfig struct {
+	Columns int `json:"columns,omitempty"` // 2 when unset
+}
+
+// Labels replace the children's names on the tabs, in order.
+type TabsConfig struct {
+	Labels []string `json:"labels,omitempty"`
+}
+
+type AccordionConfig struct {
+	Open bool `json:"open,omitempty"`
+}
+
+// Shows one variable or property.
+type SingleFieldConfig struct {
+	Field string `json:"field"`
+}
+
+// Shows one of the schema's trackers, or a current/max pair directly (see
+// SlotTracker).
+type SlotTrackerConfig struct {
+	Tracker string `json:"tracker,omitempty"`
+	Current string `json:"current,omitempty"`
+	Max     string `json:"max,omitempty"`
+}
+
+// The current/max pair, taken from the schema's tracker when one is named.
+func (c *SlotTrackerConfig) fields(s *Schema) (current, max string) {
+	if tracker, ok := s.Trackers[c.Tracker]; ok {
+		return tracker.Current, tracker.Max
+	}
+	return c.Current, c.Max
+}
+
+// Decodes Config into the struct for the visualization's type. Returns nil
+// for types without config. Unknown keys are an error.
+func (v *Visualization) DecodeConfig() (any, error) {
+	var config any
+	switch v.Type {
+	case VisGrid:
+		config = &GridConfig{}
+	case VisTabs:
+		config = &TabsConfig{}
+	case VisAccordion:
+		config = &AccordionConfig{}
+	case VisSingleField:
+		config = &SingleFieldConfig{}
+	case VisSlotTracker:
+		config = &SlotTrackerConfig{}
+	case VisCustom:
+		return nil, nil
+	}
+
+	if len(v.Config) == 0 || string(v.Config) == "null" {
+		return config, nil
+	}
+	if config == nil {
+		config = &struct{}{}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(v.Config))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid %s config: %w", v.typeName(), err)
+	}
+	if _, ok := config.(*struct{}); ok {
+		return nil, nil
+	}
+	return config, nil
+}
+
+func (v *Visualization) typeName() string {
+	if v.Type == "" {
+		return string(VisDefault)
+	}
+	return string(v.Type)
+}
+
+// Checks the tree: known types, configs that decode, leaves without
+// children, and bindings to variables and properties that exist and have
+// the right type.
+func (s *Schema) validateVisualization(v *Visualization, path string) error {
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("%s (%s): %s", path, v.typeName(), fmt.Sprintf(format, args...))
+	}
+
+	switch v.Type {
+	case "", VisGrid, VisRow, VisAccordion, VisSingleField, VisSlotTracker, VisCard, VisTabs, VisDefault, VisCustom:
+	default:
+		return fail("unknown visualization type %q", v.Type)
+	}
+
+	config, err := v.DecodeConfig()
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	switch config := config.(type) {
+	case *GridConfig:
+		if config.Columns < 0 {
+			return fail("columns can't be negative")
+		}
+	case *TabsConfig:
+		if len(config.Labels) > len(v.ChildVisualizations) {
+			return fail("%d labels for %d tabs", len(config.Labels), len(v.ChildVisualizations))
+		}
+	case *SingleFieldConfig:
+		if config.Field == "" {
+			return fail("no field")
+		}
+		if !s.hasField(config.Field) {
+			return fail("unknown field %q", config.Field)
+		}
+	case *SlotTrackerConfig:
+		if config.Tracker != "" {
+			if _, ok := s.Trackers[config.Tracker]; !ok {
+				return fail("unknown tracker %q", config.Tracker)
+			}
+			if config.Current != "" || config.Max != "" {
+				return fail("give either a tracker or current and max")
+			}
+		}
+		if err := s.validateSlots(config.fields(s)); err != nil {
+			return fail("%v", err)
+		}
+	}
+
+	if (v.Type == VisSingleField || v.Type == VisSlotTracker) && len(v.ChildVisualizations) > 0 {
+		return fail("%s can't have children", v.Type)
+	}
+
+	for i := range v.ChildVisualizations {
+		child := &v.ChildVisualizations[i]
+		childPath := path + " > #" + strconv.Itoa(i)
+		if child.Name != "" {
+			childPath = path + " > " + strconv.Quote(child.Name)
+		}
+		if err := s.validateVisualization(child, childPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether the schema or any of its modules declares the name.
+func (s *Schema) hasField(name string) bool {
+	variables, properties := s.declaredFields()
+	_, isVariable := variables[name]
+	_, isProperty := properties[name]
+	return isVariable || isProperty
+}
+
+// Resolved visualizations //
+
+// A visualization with the instance's values filled in, ready to render
+// without the schema. Containers (grid, row, card, tabs...) keep their
+// children; single fields and slot trackers carry their values.
+type ResolvedVisualization struct {
+	Name     string                  `json:"name"`
+	Type     VisualizationType       `json:"type"`
+	Columns  int                     `json:"columns,omitempty"` // grid
+	Field    string                  `json:"field,omitempty"`   // bound variable or property
+	Value    any                     `json:"value,omitempty"`   // current value for slot trackers
+	Max      any                     `json:"max,omitempty"`     // slot trackers
+	Tracker  string                  `json:"tracker,omitempty"` // schema tracker behind a slot tracker
+	Open     bool                    `json:"open,omitempty"`    // accordion starts expanded
+	Error    string                  `json:"error,omitempty"`   // binding that couldn't be resolved
+	Children []ResolvedVisualization `json:"children,omitempty"`
+}
+
+const defaultGridColumns = 2
+
+// Fills the schema's visualization (or the instance's own, if it has one)
+// with the evaluated values. Without any visualization every variable and
+// property is listed.
+func (s *Schema) ResolveVisualization(instance *Instance, evaluation *Evaluation) ResolvedVisualization {
+	visualization := s.Visualization
+	if instance.Visualization.Type != "" || len(instance.Visualization.ChildVisualizations) > 0 {
+		visualization = instance.Visualization
+	}
+	if visualization.Type == "" && len(visualization.ChildVisualizations) == 0 {
+		visualization = s.defaultVisualization()
+	}
+	return s.resolveVisualization(visualization, evaluation)
+}
+
+func (s *Schema) resolveVisualization(visualization Visualization, evaluation *Evaluation) ResolvedVisualization {
+	resolved := ResolvedVisualization{Name: visualization.Name, Type: visualization.Type}
+	if resolved.Type == "" {
+		resolved.Type = VisDefault
+	}
+
+	config, err := visualization.DecodeConfig()
+	if err != nil {
+		resolved.Error = err.Error()
+	}
+
+	switch config := config.(type) {
+	case *GridConfig:
+		resolved.Columns = config.Columns
+	case *AccordionConfig:
+		resolved.Open = config.Open
+	case *SingleFieldConfig:
+		resolved.Field = config.Field
+		if resolved.Name == "" {
+			resolved.Name = config.Field
+		}
+		value, ok := evaluation.Lookup(config.Field)
+		if !ok {
+			resolved.Error = "unknown field " + strconv.Quote(config.Field)
+		}
+		resolved.Value = value
+	case *SlotTrackerConfig:
+		currentField, maxField := config.fields(s)
+		resolved.Field = currentField
+		resolved.Tracker = config.Tracker
+		if resolved.Name == "" {
+			resolved.Name = cmp.Or(config.Tracker, currentField)
+		}
+		current, currentOK := evaluation.Lookup(currentField)
+		max, maxOK := evaluation.Lookup(maxField)
+		switch {
+		case !currentOK:
+			resolved.Error = "unknown field " + strconv.Quote(currentField)
+		case !maxOK:
+			resolved.Error = "unknown field " + strconv.Quote(maxField)
+		}
+		if current == nil {
+			current = max // full until something is spent
+		}
+		resolved.Value, resolved.Max = current, max
+	}
+	if resolved.Type == VisGrid && resolved.Columns <= 0 {
+		resolved.Columns = defaultGridColumns
+	}
+
+	for i, child := range visualization.ChildVisualizations {
+		if tabs, ok := config.(*TabsConfig); ok && i < len(tabs.Labels) && tabs.Labels[i] != "" {
+			child.Name = tabs.Labels[i]
+		}
+		resolved.Children = append(resolved.Children, s.resolveVisualization(child, evaluation))
+	}
+	return resolved
+}
+
+// A card of variables and a card of properties, each sorted by name.
+func (s *Schema) defaultVisualization() Visualization {
+	fields := func(names []string) []Visualization {
+		children := make([]Visualization, 0, len(names))
+		for _, name := range names {
+			config, _ := json.Marshal(SingleFieldConfig{Field: name})
+			children = append(children, Visualization{Type: VisSingleField, Config: config})
+		}
+		return children
+	}
+
+	root := Visualization{Name: s.Name, Type: VisDefault}
+	if len(s.Variables) > 0 {
+		root.ChildVisualizations = append(root.ChildVisualizations, Visualization{
+			Name: "Variables", Type: VisCard,
+			ChildVisualizations: []Visualization{{
+				Type:                VisGrid,
+				ChildVisualizations: fields(slices.Sorted(maps.Keys(s.Variables))),
+			}},
+		})
+	}
+	if len(s.Properties) > 0 {
+		root.ChildVisualizations = append(root.ChildVisualizations, Visualization{
+			Name: "Properties", Type: VisCard,
+			ChildVisualizations: []Visualization{{
+				Type:                VisGrid,
+				ChildVisualizations: fields(slices.Sorted(maps.Keys(s.Properties))),
+			}},
+		})
+	}
+	return root
+}
+
+// Value of a variable or, failing that, a property.
+func (e *Evaluation) Lookup(name string) (any, bool) {
+	if value, ok := e.Variables[name]; ok {
+		return value, true
+	}
+	value, ok := e.Properties[name]
+	return value, ok
+}
+
+// Human readable form of a value: whole numbers without decimals, booleans
+// as yes/no, lists separated by commas. nil is empty.
+func FormatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = FormatValue(item)
+		}
+		return strings.Join(items, ", ")
+	}
+	if number, ok := AsNumber(value); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,95 @@
+package lib
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestResolveVisualization(t *testing.T) {
+	schema := sheetTestSchema()
+	instance := &Instance{VariableValues: map[string]any{"strength": 15.0, "slots": 1.0}}
+
+	root := schema.ResolveVisualization(instance, schema.Evaluate(instance))
+	stats := root.Children[0]
+	if stats.Columns != 3 || stats.Children[1].Field != "modifier" || stats.Children[1].Value != 2.0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.Children[3].Error == "" {
+		t.Error("unknown field should be reported")
+	}
+	slots := root.Children[1].Children[0]
+	if slots.Value != 1.0 || slots.Max != 4.0 {
+		t.Errorf("unexpected slot tracker %+v", slots)
+	}
+	if got := slotBoxes(slots); len(got) != 4 || got[0] || !got[1] {
+		t.Errorf("unexpected slot boxes %v", got)
+	}
+
+	// without a visualization everything is listed
+	schema.Visualization = Visualization{}
+	root = schema.ResolveVisualization(instance, schema.Evaluate(instance))
+	if len(root.Children) != 2 || len(root.Children[0].Children[0].Children) != 4 {
+		t.Errorf("unexpected default layout %+v", root)
+	}
+}
+
+func TestValidateVisualization(t *testing.T) {
+	base := func() *Schema {
+		schema := sheetTestSchema()
+		// drop the deliberately broken binding
+		stats := &schema.Visualization.ChildVisualizations[0]
+		stats.ChildVisualizations = stats.ChildVisualizations[:3]
+		return schema
+	}
+	if err := base().Validate(); err != nil {
+		t.Fatalf("valid schema rejected: %v", err)
+	}
+
+	tests := map[string]struct {
+		edit func(*Visualization)
+		want string
+	}{
+		"unknown type": {func(v *Visualization) { v.Type = "carousel" }, "unknown visualization type"},
+		"unknown key":  {func(v *Visualization) { v.Config = json.RawMessage(`{"colums":2}`) }, "invalid tabs config"},
+		"labels":       {func(v *Visualization) { v.Config = json.RawMessage(`{"labels":["a","b","c"]}`) }, "3 labels for 2 tabs"},
+		"field": {func(v *Visualization) {
+			v.ChildVisualizations[0].ChildVisualizations[0].Config = json.RawMessage(`{"field":"dexterity"}`)
+		}, `"Stats" > #0 (single_field): unknown field "dexterity"`},
+		"slot current type": {func(v *Visualization) {
+			v.ChildVisualizations[1].ChildVisualizations[0].Config = json.RawMessage(`{"current":"notes","max":"max_slots"}`)
+		}, "must be a number"},
+		"slot max": {func(v *Visualization) {
+			v.ChildVisualizations[1].ChildVisualizations[0].Config = json.RawMessage(`{"current":"slots","max":"nope"}`)
+		}, `max must be a property or variable, got "nope"`},
+		"leaf children": {func(v *Visualization) {
+			field := &v.ChildVisualizations[0].ChildVisualizations[0]
+			field.ChildVisualizations = []Visualization{{Type: VisCard}}
+		}, "can't have children"},
+	}
+	for name, test := range tests {
+		schema := base()
+		test.edit(&schema.Visualization)
+		err := schema.Validate()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error containing %q", name, err, test.want)
+		}
+	}
+
+	// fields added by modules can be shown and used in conditions
+	schema := base()
+	schema.Modules = map[string]Module{"rogue": {AddsProperties: map[string]Property{"sneak": {Formula: "strength + 2"}}}}
+	schema.Features = map[string]Feature{"sneaky": {Condition: "sneak > 10"}}
+	schema.Visualization.ChildVisualizations[0].ChildVisualizations[0].Config = json.RawMessage(`{"field":"sneak"}`)
+	if err := schema.Validate(); err != nil {
+		t.Errorf("module field rejected: %v", err)
+	}
+
+	// tab labels name the resolved tabs
+	schema = base()
+	schema.Visualization.Config = json.RawMessage(`{"labels":["Abilities"]}`)
+	root := schema.ResolveVisualization(&Instance{}, schema.Evaluate(&Instance{}))
+	if root.Children[0].Name != "Abilities" || root.Children[1].Name != "Spells" {
+		t.Errorf("labels not applied: %q, %q", root.Children[0].Name, root.Children[1].Name)
+	}
+}
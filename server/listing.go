@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Listing endpoints
+//
+// GET /:user/schemas, /instances, /webhooks, /encounters and /groups return a
+// page of summaries:
+//
+//	{"items": [...], "total": 42, "next_cursor": "..."}
+//
+// Query parameters:
+//   - limit: page size, 1 to maxListLimit (default defaultListLimit)
+//   - sort: updated_at (default), created_at or name; relevance when searching
+//   - order: asc or desc (default desc for dates and relevance, asc for name)
+//   - cursor: next_cursor from the previous page
+//
+// Cursors hold the sort key and ID of the last item, so pages stay stable when
+// entries are added or removed between requests.
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+const (
+	SortUpdatedAt = "updated_at"
+	SortCreatedAt = "created_at"
+	SortName      = "name"
+	SortRelevance = "relevance"
+)
+
+// Fields shared by the listed collections. Decoding straight into this skips
+// the variables and the rest of the document.
+type Summary struct {
+	ID          string    `json:"_id"`
+	SchemaID    string    `json:"schema_id,omitempty"`
+	URL         string    `json:"url,omitempty"` // webhooks, which have no name
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type Page struct {
+	Items      []Summary `json:"items"`
+	Total      int       `json:"total"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type listOptions struct {
+	limit  int
+	sort   string
+	desc   bool
+	cursor *listCursor
+}
+
+type listCursor struct {
+	Key string `json:"k"`
+	ID  string `json:"id"`
+}
+
+// defaultSort is used when the request doesn't pick one.
+func parseListOptions(c echo.Context, defaultSort string) (listOptions, error) {
+	opts := listOptions{limit: defaultListLimit, sort: defaultSort}
+
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		opts.limit = n
+	}
+
+	if sort := c.QueryParam("sort"); sort != "" {
+		switch sort {
+		case SortUpdatedAt, SortCreatedAt, SortName:
+		case SortRelevance:
+			if defaultSort != SortRelevance {
+				return opts, fmt.Errorf("sort=relevance needs a search query")
+			}
+		default:
+			return opts, fmt.Errorf("unknown sort %q", sort)
+		}
+		opts.sort = sort
+	}
+
+	opts.desc = opts.sort != SortName
+	switch c.QueryParam("order") {
+	case "":
+	case "asc":
+		opts.desc = false
+	case "desc":
+		opts.desc = true
+	default:
+		return opts, fmt.Errorf("order must be asc or desc")
+	}
+
+	if cursor := c.QueryParam("cursor"); cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return opts, fmt.Errorf("invalid cursor")
+		}
+		opts.cursor = &listCursor{}
+		if err := json.Unmarshal(data, opts.cursor); err != nil {
+			return opts, fmt.Errorf("invalid cursor")
+		}
+	}
+
+	return opts, nil
+}
+
+// Sort key as a string that orders the same way the field does.
+// scores is only used for relevance.
+func sortKey(summary Summary, sort string, scores map[string]int) string {
+	switch sort {
+	case SortName:
+		return strings.ToLower(summary.Name)
+	case SortCreatedAt:
+		return summary.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000000")
+	case SortRelevance:
+		return fmt.Sprintf("%010d", scores[summary.ID])
+	default:
+		return summary.UpdatedAt.UTC().Format("2006-01-02T15:04:05.000000000")
+	}
+}
+
+// Sorts the summaries and cuts out the requested page.
+func paginate(summaries []Summary, opts listOptions, scores map[string]int) Page {
+	type keyed struct {
+		key     string
+		summary Summary
+	}
+
+	items := make([]keyed, len(summaries))
+	for i, summary := range summaries {
+		items[i] = keyed{sortKey(summary, opts.sort, scores), summary}
+	}
+
+	compare := func(key, id string, other keyed) int {
+		if c := strings.Compare(key, other.key); c != 0 {
+			return c
+		}
+		return strings.Compare(id, other.summary.ID)
+	}
+	if opts.desc {
+		inner := compare
+		compare = func(key, id string, other keyed) int { return -inner(key, id, other) }
+	}
+
+	slices.SortFunc(items, func(a, b keyed) int {
+		return compare(a.key, a.summary.ID, b)
+	})
+
+	start := 0
+	if opts.cursor != nil {
+		start = len(items)
+		for i, item := range items {
+			if compare(opts.cursor.Key, opts.cursor.ID, item) < 0 {
+				start = i
+				break
+			}
+		}
+	}
+	end := min(start+opts.limit, len(items))
+
+	page := Page{Items: make([]Summary, 0, end-start), Total: len(items)}
+	for _, item := range items[start:end] {
+		page.Items = append(page.Items, item.summary)
+	}
+
+	if end < len(items) {
+		last := items[end-1]
+		data, _ := json.Marshal(listCursor{Key: last.key, ID: last.summary.ID})
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	return page
+}
+
+// Lists a whole collection as one page of summaries.
+func listSummaries(c echo.Context, db *JsonDB, collection string) error {
+	user := c.Param("user")
+
+	opts, err := parseListOptions(c, SortUpdatedAt)
+	if err != nil {
+		return httpError(c, http.StatusBadRequest, err)
+	}
+
+	entries, err := GetAll[Summary](db, collection, user)
+	if err != nil {
+		return httpError(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, paginate(summaryList(entries), opts, nil))
+}
+
+// Lists the instances using a schema through the by_schema index.
+func listSchemaInstances(c echo.Context, db *JsonDB, schemaID string) error {
+	user := c.Param("user")
+
+	opts, err := parseListOptions(c, SortUpdatedAt)
+	if err != nil {
+		return httpError(c, http.StatusBadRequest, err)
+	}
+
+	ids, err := db.Lookup(CollectionInstances, IndexInstancesBySchema, user, schemaID)
+	if err != nil {
+		return httpError(c, http.StatusInternalServerError, err)
+	}
+
+	entries, err := GetMany[Summary](db, CollectionInstances, user, ids)
+	if err != nil {
+		return httpError(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, paginate(summaryList(entries), opts, nil))
+}
+
+// Flattens GetAll's result, trusting the file name over the stored _id.
+func summaryList(entries map[string]Summary) []Summary {
+	summaries := make([]Summary, 0, len(entries))
+	for id, summary := range entries {
+		summary.ID = id
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// Lists the instances matching a search query (see lib.ParseQuery).
+// Results are ordered by relevance unless another sort is asked for.
+// ?schema_id= narrows the results to one schema.
+func listSearchResults(c echo.Context, db *JsonDB, search *SearchIndex, q string) error {
+	user := c.Param("user")
+
+	opts, err := parseListOptions(c, SortRelevance)
+	if err != nil {
+		return httpError(c, http.StatusBadRequest, err)
+	}
+
+	hits, err := search.Search(user, lib.ParseQuery(q))
+	if err != nil {
+		return httpError(c, http.StatusInternalServerError, err)
+	}
+
+	entries, err := GetAll[Summary](db, CollectionInstances, user)
+	if err != nil {
+		return httpError(c, http.StatusInternalServerError, err)
+	}
+
+	schemaID := c.QueryParam("schema_id")
+
+	scores := make(map[string]int, len(hits))
+	summaries := make([]Summary, 0, len(hits))
+	for _, hit := range hits {
+		summary, ok := entries[hit.ID]
+		if !ok || (schemaID != "" && summary.SchemaID != schemaID) {
+			continue
+		}
+		summary.ID = hit.ID
+		scores[hit.ID] = hit.Score
+		summaries = append(summaries, summary)
+	}
+
+	return c.JSON(http.StatusOK, paginate(summaries, opts, scores))
+}
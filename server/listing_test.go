@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func listPage(t *testing.T, db *JsonDB, collection string, query url.Values) Page {
+	t.Helper()
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("user")
+	c.SetParamValues("gm")
+
+	if err := listSummaries(c, db, collection); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var page Page
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestListSummariesPagination(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	names := []string{"Eve", "alice", "Dave", "carol", "Bob"}
+	for i, name := range names {
+		db.Set(CollectionInstances, "gm", fmt.Sprintf("i%d", i), lib.Instance{
+			ID:             fmt.Sprintf("i%d", i),
+			SchemaID:       "pc",
+			Name:           name,
+			VariableValues: map[string]any{"notes": "not part of the summary"},
+			UpdatedAt:      base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	// default: most recently updated first
+	page := listPage(t, db, CollectionInstances, url.Values{"limit": {"2"}})
+	if page.Total != 5 || len(page.Items) != 2 || page.Items[0].Name != "Bob" || page.Items[1].Name != "carol" {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+	if page.Items[0].SchemaID != "pc" {
+		t.Errorf("summary is missing schema_id: %+v", page.Items[0])
+	}
+
+	// walk the pages sorted by name
+	var got []string
+	query := url.Values{"limit": {"2"}, "sort": {"name"}}
+	for {
+		page := listPage(t, db, CollectionInstances, query)
+		for _, item := range page.Items {
+			got = append(got, item.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+
+	want := []string{"alice", "Bob", "carol", "Dave", "Eve"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseListOptionsRejectsBadInput(t *testing.T) {
+	e := echo.New()
+	for _, query := range []string{"limit=0", "limit=1000", "sort=level", "order=up", "cursor=not-a-cursor", "sort=relevance"} {
+		req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		c := e.NewContext(req, httptest.NewRecorder())
+		if _, err := parseListOptions(c, SortUpdatedAt); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
+func TestListCollectionSummaries(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	db.Set(CollectionWebhooks, "gm", "w1", Webhook{ID: "w1", URL: "https://example.test/hook", Secret: "s3cret", CreatedAt: created})
+	db.Set(CollectionEncounters, "gm", "e1", Encounter{ID: "e1", Name: "Ambush", Combatants: []Combatant{{InstanceID: "i1"}}, CreatedAt: created})
+	db.Set(CollectionGroups, "gm", "g1", lib.Group{ID: "g1", Name: "Party", Members: []string{"i1"}, CreatedAt: created})
+
+	want := map[string]Summary{
+		CollectionWebhooks:   {ID: "w1", URL: "https://example.test/hook", CreatedAt: created},
+		CollectionEncounters: {ID: "e1", Name: "Ambush", CreatedAt: created},
+		CollectionGroups:     {ID: "g1", Name: "Party", CreatedAt: created},
+	}
+	for collection, summary := range want {
+		page := listPage(t, db, collection, nil)
+		if page.Total != 1 || len(page.Items) != 1 || page.Items[0] != summary {
+			t.Errorf("%s: unexpected page %+v", collection, page)
+		}
+	}
+}
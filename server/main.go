@@ -3,7 +3,12 @@ package main
 // TODO: set up tests for the web server and database
 
 import (
+	"context"
+	"fmt"
+	"maps"
 	"net/http"
+	"os"
+	"slices"
 	"time"
 
 	//"github.com/charmbracelet/log"
@@ -14,8 +19,13 @@ import (
 )
 
 const (
-	CollectionInstances = "instances"
-	CollectionSchemas   = "schemas"
+	CollectionInstances  = "instances"
+	CollectionSchemas    = "schemas"
+	CollectionWebhooks   = "webhooks"
+	CollectionDeliveries = "webhook_deliveries"
+	CollectionHistory    = "history"
+	CollectionEncounters = "encounters"
+	CollectionGroups     = "groups"
 )
 
 type NewSchemaRequest struct {
@@ -30,7 +40,17 @@ type NewInstanceRequest struct {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	db := NewJsonDB("./data")
+	blobs := NewBlobStore("./blobs")
+	createIndexes(db)
+	hooks := NewWebhookDispatcher(db)
+	fetcher := NewSourceFetcher()
+	search := NewSearchIndex(db)
+	go NewDataSourceScheduler(db, fetcher, hooks).Run(context.Background())
 
 	router := echo.New()
 	router.Use(middleware.Logger())
@@ -43,6 +63,20 @@ func main() {
 	u := router.Group("/:user")
 	schemas := u.Group("/schemas")
 	instances := u.Group("/instances")
+	registerWebhookRoutes(u, db)
+	registerDataSourceRoutes(instances, db, fetcher, hooks)
+	registerBundleRoutes(u, db, blobs)
+	registerCSVRoutes(schemas, db, blobs, hooks)
+	registerSheetRoutes(instances, db)
+	registerTrackerRoutes(instances, db, hooks)
+	registerEventRoutes(instances, db, hooks)
+	registerFeatureRoutes(instances, db, hooks)
+	registerEffectRoutes(instances, db, hooks)
+	registerEncounterRoutes(u, db, hooks)
+	registerGroupRoutes(u, db)
+	registerReferenceRoutes(instances, db)
+	registerGenealogyRoutes(u, instances, db)
+	registerAttachmentRoutes(u, blobs)
 
 	schemas.GET("/:id", func(c echo.Context) error {
 		user := c.Param("user")
@@ -56,7 +90,7 @@ func main() {
 			})
 		}
 
-		return c.JSON(http.StatusOK, schema)
+		return respondSchema(c, http.StatusOK, &schema)
 	})
 
 	schemas.POST("/new", func(c echo.Context) error {
@@ -88,8 +122,9 @@ func main() {
 				"error": err.Error(),
 			})
 		}
+		hooks.Dispatch(user, EventSchemaCreated, schema.ID, "", schema)
 
-		return c.JSON(http.StatusOK, schema)
+		return respondSchema(c, http.StatusOK, &schema)
 	})
 
 	schemas.POST("/save", func(c echo.Context) error {
@@ -97,33 +132,58 @@ func main() {
 
 		var req lib.Schema
 
-		if err := c.Bind(&req); err != nil {
+		// JSON, YAML or TOML depending on Content-Type
+		if err := bindSchema(c, &req); err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{
 				"error": err.Error(),
 			})
 		}
 
+		if err := req.Validate(); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
 		err := db.Set(CollectionSchemas, user, req.ID, req)
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{
 				"error": err.Error(),
 			})
 		}
+		hooks.Dispatch(user, EventSchemaSaved, req.ID, "", req)
 
 		return c.String(http.StatusOK, "schema saved")
 	})
 
 	schemas.GET("", func(c echo.Context) error {
+		return listSummaries(c, db, CollectionSchemas)
+	})
+
+	// total in the response is the "used by" count
+	schemas.GET("/:id/instances", func(c echo.Context) error {
 		user := c.Param("user")
+		id := c.Param("id")
 
-		schemaIDs, err := db.List(CollectionSchemas, user)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": err.Error(),
-			})
+		var schema lib.Schema
+		if err := db.Get(CollectionSchemas, user, id, &schema); err != nil {
+			return httpError(c, http.StatusNotFound, err)
 		}
 
-		return c.JSON(http.StatusOK, schemaIDs)
+		return listSchemaInstances(c, db, id)
+	})
+
+	// JSON Schema (draft 2020-12) for an instance's variable_values
+	schemas.GET("/:id/json-schema", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		var schema lib.Schema
+		if err := db.Get(CollectionSchemas, user, id, &schema); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		url := c.Scheme() + "://" + c.Request().Host + c.Request().URL.Path
+		c.Response().Header().Set(echo.HeaderContentType, "application/schema+json")
+		return c.JSON(http.StatusOK, schema.JSONSchema(url))
 	})
 
 	instances.GET("/:id", func(c echo.Context) error {
@@ -168,6 +228,7 @@ func main() {
 			CreatedAt:   time.Now(),
 			UpdatedAt:   time.Now(),
 		}
+		instance.UpdateActiveFeatures(&schema, evalOptions(db, user))
 
 		err = db.Set(CollectionInstances, user, instanceID, instance)
 		if err != nil {
@@ -175,6 +236,7 @@ func main() {
 				"error": err.Error(),
 			})
 		}
+		hooks.DispatchInstance(user, EventInstanceCreated, nil, &instance)
 
 		return c.JSON(http.StatusOK, instance)
 	})
@@ -190,27 +252,43 @@ func main() {
 			})
 		}
 
+		// previous version is needed to detect threshold crossings
+		var previous *lib.Instance
+		var stored lib.Instance
+		if db.Get(CollectionInstances, user, req.ID, &stored) == nil {
+			previous = &stored
+		}
+
+		var schema lib.Schema
+		if err := db.Get(CollectionSchemas, user, req.SchemaID, &schema); err != nil {
+			return httpError(c, http.StatusBadRequest, fmt.Errorf("schema %s: %w", req.SchemaID, err))
+		}
+		if err := checkInstance(blobs, user, &schema, &req, instanceLookup(db, user)); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
 		err := db.Set(CollectionInstances, user, req.ID, req)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{
 				"error": err.Error(),
 			})
 		}
+		hooks.DispatchInstance(user, EventInstanceSaved, previous, &req)
 
 		return c.String(http.StatusOK, "instance saved")
 	})
 
+	// ?q= searches names, descriptions and string values, and can hold
+	// filters on variables: ?q=smith level>=5 class=wizard
+	// ?schema_id= only lists instances of that schema
 	instances.GET("", func(c echo.Context) error {
-		user := c.Param("user")
-
-		instanceIDs, err := db.List(CollectionInstances, user)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{
-				"error": err.Error(),
-			})
+		if q := c.QueryParam("q"); q != "" {
+			return listSearchResults(c, db, search, q)
 		}
-
-		return c.JSON(http.StatusOK, instanceIDs)
+		if schemaID := c.QueryParam("schema_id"); schemaID != "" {
+			return listSchemaInstances(c, db, schemaID)
+		}
+		return listSummaries(c, db, CollectionInstances)
 	})
 
 	// run server with error checking
@@ -221,3 +299,80 @@ func main() {
 func httpError(c echo.Context, code int, err error) error {
 	return c.JSON(code, echo.Map{"error": err.Error()})
 }
+
+// Loads an instance together with the schema it's based on.
+func getInstance(db *JsonDB, user, id string) (*lib.Instance, *lib.Schema, error) {
+	return loadInstance(db.Get, user, id)
+}
+
+// getInstance with the given reader, db.Get or (under the lock) db.read.
+func loadInstance(get func(collection, user, entry string, dest any) error, user, id string) (*lib.Instance, *lib.Schema, error) {
+	var instance lib.Instance
+	if err := get(CollectionInstances, user, id, &instance); err != nil {
+		return nil, nil, err
+	}
+
+	var schema lib.Schema
+	if err := get(CollectionSchemas, user, instance.SchemaID, &schema); err != nil {
+		return nil, nil, fmt.Errorf("schema %s: %w", instance.SchemaID, err)
+	}
+	return &instance, &schema, nil
+}
+
+// Readies an instance for saving, whichever way it arrives: values are
+// converted to their variables' types and required ones must be set,
+// conditional features follow the new values, its own bonuses must be valid,
+// and references and attachments must exist. lookup finds the referenced
+// instances.
+func checkInstance(blobs *BlobStore, user string, schema *lib.Schema, instance *lib.Instance, lookup lib.InstanceLookup) error {
+	if err := checkInstanceValues(schema, instance, lookup); err != nil {
+		return err
+	}
+	return blobs.CheckAttachments(user, schema, instance)
+}
+
+// checkInstance without the attachments.
+func checkInstanceValues(schema *lib.Schema, instance *lib.Instance, lookup lib.InstanceLookup) error {
+	if err := schema.CoerceValues(instance); err != nil {
+		return err
+	}
+	if err := schema.CheckRequired(instance); err != nil {
+		return err
+	}
+	instance.UpdateActiveFeatures(schema, lib.EvalOptions{Lookup: lookup})
+	if err := schema.CheckInstanceBonuses(instance); err != nil {
+		return err
+	}
+	return schema.CheckReferences(instance, lookup)
+}
+
+// Applies change to the stored instance under the database lock, updates its
+// active features, checks its references and dispatches instance.saved.
+// Nothing is saved if change fails. change gets options that resolve
+// references without taking the lock again, and must evaluate with those.
+func updateInstance(db *JsonDB, hooks *WebhookDispatcher, user string, schema *lib.Schema, id string, change func(*lib.Instance, lib.EvalOptions) error) (*lib.Instance, error) {
+	opts := lib.EvalOptions{Lookup: lockedInstanceLookup(db, user)}
+
+	var previous, updated lib.Instance
+	err := Update(db, CollectionInstances, user, id, func(instance *lib.Instance) error {
+		previous = *instance
+		previous.VariableValues = maps.Clone(instance.VariableValues)
+		previous.Effects = slices.Clone(instance.Effects)
+		if err := change(instance, opts); err != nil {
+			return err
+		}
+		instance.UpdateActiveFeatures(schema, opts)
+		if err := schema.CheckReferences(instance, opts.Lookup); err != nil {
+			return err
+		}
+		instance.UpdatedAt = time.Now()
+		updated = *instance
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hooks.DispatchInstance(user, EventInstanceSaved, &previous, &updated)
+	return &updated, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// References between instances (see lib.Reference): who points at an
+// instance, and deleting instances without leaving references dangling.
+
+// A reference to the looked up instance, from the point of view of the
+// instance holding it.
+type Referrer struct {
+	InstanceID string `json:"instance_id"`
+	Name       string `json:"name"`
+	SchemaID   string `json:"schema_id"`
+	Variable   string `json:"variable"`
+}
+
+// Looks up the user's instances for lib.Schema.EvaluateWith and friends.
+func instanceLookup(db *JsonDB, user string) lib.InstanceLookup {
+	return func(id string) (*lib.Instance, *lib.Schema, error) {
+		return getInstance(db, user, id)
+	}
+}
+
+// instanceLookup for Update callbacks, which already hold the database lock.
+func lockedInstanceLookup(db *JsonDB, user string) lib.InstanceLookup {
+	return func(id string) (*lib.Instance, *lib.Schema, error) {
+		return loadInstance(db.read, user, id)
+	}
+}
+
+// Evaluates the user's instances with their references resolved.
+func evalOptions(db *JsonDB, user string) lib.EvalOptions {
+	return lib.EvalOptions{Lookup: instanceLookup(db, user)}
+}
+
+// Every reference to the instance from another one, sorted by instance and
+// variable. References from an instance to itself don't count.
+func findReferrers(db *JsonDB, user, id string) ([]Referrer, error) {
+	instances, err := GetAll[lib.Instance](db, CollectionInstances, user)
+	if err != nil {
+		return nil, err
+	}
+	schemas, err := GetAll[lib.Schema](db, CollectionSchemas, user)
+	if err != nil {
+		return nil, err
+	}
+
+	referrers := []Referrer{}
+	for _, instance := range instances {
+		schema, ok := schemas[instance.SchemaID]
+		if !ok || instance.ID == id {
+			continue
+		}
+		for _, reference := range schema.References(&instance) {
+			if reference.Target == id {
+				referrers = append(referrers, Referrer{
+					InstanceID: instance.ID,
+					Name:       instance.Name,
+					SchemaID:   instance.SchemaID,
+					Variable:   reference.Variable,
+				})
+			}
+		}
+	}
+	slices.SortFunc(referrers, func(a, b Referrer) int {
+		return strings.Compare(a.InstanceID+"\x00"+a.Variable, b.InstanceID+"\x00"+b.Variable)
+	})
+	return slices.Compact(referrers), nil
+}
+
+func registerReferenceRoutes(instances *echo.Group, db *JsonDB) {
+	instances.GET("/:id/referrers", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		var instance lib.Instance
+		if err := db.Get(CollectionInstances, user, id, &instance); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		referrers, err := findReferrers(db, user, id)
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, referrers)
+	})
+
+	// refused while other instances reference it
+	instances.POST("/:id/delete", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		var instance lib.Instance
+		if err := db.Get(CollectionInstances, user, id, &instance); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		referrers, err := findReferrers(db, user, id)
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		if len(referrers) > 0 {
+			names := make([]string, len(referrers))
+			for i, referrer := range referrers {
+				names[i] = fmt.Sprintf("%s (%s)", referrer.InstanceID, referrer.Variable)
+			}
+			return c.JSON(http.StatusConflict, echo.Map{
+				"error":     "instance is referenced by " + strings.Join(names, ", "),
+				"referrers": referrers,
+			})
+		}
+
+		if err := db.Delete(CollectionInstances, user, id); err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.String(http.StatusOK, "instance deleted")
+	})
+}
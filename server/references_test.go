@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestReferenceRoutes(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	db.Set(CollectionSchemas, "fam", "person", lib.Schema{
+		ID: "person",
+		Variables: map[string]lib.Variable{
+			"mother": {Type: lib.TypeReference, Schema: "person"},
+			"notes":  {Type: lib.TypeMarkdown},
+		},
+		Properties: map[string]lib.Property{"mother_name": {Formula: "mother?.name"}},
+	})
+	db.Set(CollectionInstances, "fam", "mary", lib.Instance{ID: "mary", Name: "Mary", SchemaID: "person"})
+	db.Set(CollectionInstances, "fam", "john", lib.Instance{ID: "john", Name: "John", SchemaID: "person",
+		VariableValues: map[string]any{"mother": "mary", "notes": "Son of [[mary]]"}})
+
+	router := echo.New()
+	instances := router.Group("/:user/instances")
+	registerReferenceRoutes(instances, db)
+	registerSheetRoutes(instances, db)
+	request := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	var evaluation lib.Evaluation
+	json.Unmarshal(request(http.MethodGet, "/fam/instances/john/evaluated").Body.Bytes(), &evaluation)
+	if evaluation.Properties["mother_name"] != "Mary" {
+		t.Errorf("mother_name: %v %v", evaluation.Properties["mother_name"], evaluation.Errors)
+	}
+	if !strings.Contains(evaluation.Rendered["notes"], `data-instance="mary"`) {
+		t.Errorf("rendered notes: %q", evaluation.Rendered["notes"])
+	}
+
+	rec := request(http.MethodGet, "/fam/instances/mary/referrers")
+	var referrers []Referrer
+	json.Unmarshal(rec.Body.Bytes(), &referrers)
+	if len(referrers) != 1 || referrers[0].InstanceID != "john" || referrers[0].Variable != "mother" {
+		t.Errorf("referrers: %s", rec.Body.String())
+	}
+
+	if rec := request(http.MethodPost, "/fam/instances/mary/delete"); rec.Code != http.StatusConflict {
+		t.Errorf("deleting a referenced instance: got %d", rec.Code)
+	}
+	if rec := request(http.MethodPost, "/fam/instances/john/delete"); rec.Code != http.StatusOK {
+		t.Errorf("deleting john: got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := request(http.MethodPost, "/fam/instances/mary/delete"); rec.Code != http.StatusOK {
+		t.Errorf("deleting mary once unreferenced: got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := request(http.MethodGet, "/fam/instances/mary/referrers"); rec.Code != http.StatusNotFound {
+		t.Errorf("referrers of a deleted instance: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Instance search index
+//
+// An inverted index from word to instance IDs, kept per user in memory.
+// A user's index is built from disk on their first search and then kept up
+// to date through JsonDB write hooks, so every write path (handlers, data
+// source refreshes, imports) is covered.
+
+type SearchIndex struct {
+	db *JsonDB
+
+	mu    sync.Mutex
+	users map[string]*userIndex
+}
+
+type userIndex struct {
+	instances map[string]*lib.Instance
+	postings  map[string]map[string]struct{} // token -> instance IDs
+	tokens    map[string][]string            // instance ID -> tokens
+}
+
+func NewSearchIndex(db *JsonDB) *SearchIndex {
+	index := &SearchIndex{db: db, users: make(map[string]*userIndex)}
+	db.Watch(CollectionInstances, index.onWrite)
+	return index
+}
+
+func (s *SearchIndex) onWrite(op WriteOp, user, entry string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// users that haven't searched yet get loaded from disk later
+	index, ok := s.users[user]
+	if !ok {
+		return
+	}
+
+	index.remove(entry)
+	if op != WriteSet {
+		return
+	}
+
+	var instance lib.Instance
+	if err := json.Unmarshal(data, &instance); err != nil {
+		return
+	}
+	index.add(entry, &instance)
+}
+
+func (s *SearchIndex) load(user string) (*userIndex, error) {
+	if index, ok := s.users[user]; ok {
+		return index, nil
+	}
+
+	instances, err := GetAll[lib.Instance](s.db, CollectionInstances, user)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &userIndex{
+		instances: make(map[string]*lib.Instance),
+		postings:  make(map[string]map[string]struct{}),
+		tokens:    make(map[string][]string),
+	}
+	for id, instance := range instances {
+		index.add(id, &instance)
+	}
+
+	s.users[user] = index
+	return index, nil
+}
+
+func (u *userIndex) add(id string, instance *lib.Instance) {
+	seen := make(map[string]bool)
+	for _, text := range lib.SearchableText(instance) {
+		for _, token := range lib.Tokenize(text) {
+			if seen[token] {
+				continue
+			}
+			seen[token] = true
+
+			ids, ok := u.postings[token]
+			if !ok {
+				ids = make(map[string]struct{})
+				u.postings[token] = ids
+			}
+			ids[id] = struct{}{}
+			u.tokens[id] = append(u.tokens[id], token)
+		}
+	}
+	u.instances[id] = instance
+}
+
+func (u *userIndex) remove(id string) {
+	for _, token := range u.tokens[id] {
+		delete(u.postings[token], id)
+		if len(u.postings[token]) == 0 {
+			delete(u.postings, token)
+		}
+	}
+	delete(u.tokens, id)
+	delete(u.instances, id)
+}
+
+// IDs of instances containing a word starting with term.
+func (u *userIndex) match(term string) map[string]int {
+	hits := make(map[string]int)
+	for token, ids := range u.postings {
+		if !strings.HasPrefix(token, term) {
+			continue
+		}
+		// exact word matches rank above prefix matches
+		weight := 1
+		if token == term {
+			weight = 2
+		}
+		for id := range ids {
+			hits[id] = max(hits[id], weight)
+		}
+	}
+	return hits
+}
+
+type SearchHit struct {
+	ID    string
+	Score int
+}
+
+// Instances matching every term and filter, best matches first.
+func (s *SearchIndex) Search(user string, query lib.Query) ([]SearchHit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.load(user)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]int, len(index.instances))
+	for id := range index.instances {
+		scores[id] = 0
+	}
+
+	for _, term := range query.Terms {
+		hits := index.match(term)
+		for id, score := range scores {
+			hit, ok := hits[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] = score + hit
+		}
+	}
+
+	results := make([]SearchHit, 0, len(scores))
+	for id, score := range scores {
+		matches := true
+		for _, filter := range query.Filters {
+			if !filter.Matches(index.instances[id]) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			results = append(results, SearchHit{ID: id, Score: score})
+		}
+	}
+
+	slices.SortFunc(results, func(a, b SearchHit) int {
+		if a.Score != b.Score {
+			return b.Score - a.Score
+		}
+		return strings.Compare(index.instances[a.ID].Name, index.instances[b.ID].Name)
+	})
+
+	return results, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestSearchIndex(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	search := NewSearchIndex(db)
+
+	db.Set(CollectionInstances, "fam", "p1", lib.Instance{
+		ID:             "p1",
+		Name:           "Mary Smith",
+		Description:    "Born in Dublin",
+		VariableValues: map[string]any{"birth_year": 1901.0, "occupation": "seamstress"},
+	})
+	db.Set(CollectionInstances, "fam", "p2", lib.Instance{
+		ID:             "p2",
+		Name:           "John Smithson",
+		VariableValues: map[string]any{"birth_year": 1875.0, "occupation": "farmer"},
+	})
+
+	query := func(q string) []string {
+		t.Helper()
+		hits, err := search.Search("fam", lib.ParseQuery(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, hit := range hits {
+			ids = append(ids, hit.ID)
+		}
+		return ids
+	}
+
+	// exact word ranks above the prefix match
+	if got := query("smith"); !reflect.DeepEqual(got, []string{"p1", "p2"}) {
+		t.Errorf("smith: got %v", got)
+	}
+	if got := query("dublin"); !reflect.DeepEqual(got, []string{"p1"}) {
+		t.Errorf("dublin: got %v", got)
+	}
+	if got := query("smith birth_year<1900"); !reflect.DeepEqual(got, []string{"p2"}) {
+		t.Errorf("filtered: got %v", got)
+	}
+
+	// writes after the index is loaded are picked up incrementally
+	db.Set(CollectionInstances, "fam", "p2", lib.Instance{
+		ID:             "p2",
+		Name:           "John Smithson",
+		VariableValues: map[string]any{"occupation": "blacksmith"},
+	})
+	if got := query("farmer"); len(got) != 0 {
+		t.Errorf("stale token still indexed: %v", got)
+	}
+	if got := query("blacksmith"); !reflect.DeepEqual(got, []string{"p2"}) {
+		t.Errorf("blacksmith: got %v", got)
+	}
+
+	db.Delete(CollectionInstances, "fam", "p1")
+	if got := query("mary"); len(got) != 0 {
+		t.Errorf("deleted instance still found: %v", got)
+	}
+}
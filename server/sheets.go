@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Rendering an instance: its evaluated values and visualization for
+// clients, and printable character sheets (see lib.Sheet).
+
+func registerSheetRoutes(instances *echo.Group, db *JsonDB) {
+	// variables, properties and the active features and modules
+	instances.GET("/:id/evaluated", func(c echo.Context) error {
+		user := c.Param("user")
+		instance, schema, err := getInstance(db, user, c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		return c.JSON(http.StatusOK, schema.EvaluateWith(instance, evalOptions(db, user)))
+	})
+
+	instances.GET("/:id/visualization", func(c echo.Context) error {
+		user := c.Param("user")
+		instance, schema, err := getInstance(db, user, c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		evaluation := schema.EvaluateWith(instance, evalOptions(db, user))
+		return c.JSON(http.StatusOK, schema.ResolveVisualization(instance, evaluation))
+	})
+
+	instances.GET("/:id/sheet", func(c echo.Context) error {
+		sheet, err := loadSheet(db, c.Param("user"), c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		var buf bytes.Buffer
+		if err := sheet.RenderHTML(&buf); err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.HTMLBlob(http.StatusOK, buf.Bytes())
+	})
+
+	instances.GET("/:id/sheet.pdf", func(c echo.Context) error {
+		id := c.Param("id")
+		sheet, err := loadSheet(db, c.Param("user"), id)
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		var buf bytes.Buffer
+		if err := sheet.RenderPDF(&buf); err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition,
+			fmt.Sprintf(`inline; filename="%s.pdf"`, id))
+		return c.Blob(http.StatusOK, "application/pdf", buf.Bytes())
+	})
+}
+
+func loadSheet(db *JsonDB, user, id string) (*lib.Sheet, error) {
+	instance, schema, err := getInstance(db, user, id)
+	if err != nil {
+		return nil, err
+	}
+	return lib.NewSheet(schema, instance, evalOptions(db, user)), nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestSheetRoutes(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	db.Set(CollectionSchemas, "gm", "dnd", lib.Schema{ID: "dnd", Name: "D&D"})
+	db.Set(CollectionInstances, "gm", "hero", lib.Instance{ID: "hero", SchemaID: "dnd", Name: "Hero"})
+
+	router := echo.New()
+	registerSheetRoutes(router.Group("/:user/instances"), db)
+
+	tests := []struct {
+		path, contentType string
+		code              int
+	}{
+		{"/gm/instances/hero/evaluated", "application/json", http.StatusOK},
+		{"/gm/instances/hero/visualization", "application/json", http.StatusOK},
+		{"/gm/instances/hero/sheet", "text/html", http.StatusOK},
+		{"/gm/instances/hero/sheet.pdf", "application/pdf", http.StatusOK},
+		{"/gm/instances/nobody/sheet.pdf", "application/json", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.code || !strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), test.contentType) {
+			t.Errorf("%s: got %d %s", test.path, rec.Code, rec.Header().Get(echo.HeaderContentType))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Spending and refilling an instance's slot trackers (see lib.SlotTracker).
+
+type TrackerRequest struct {
+	Amount float64 `json:"amount"` // 1 when unset
+}
+
+type TrackerResetRequest struct {
+	Event string `json:"event"`
+}
+
+func trackerError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, lib.ErrUnknownTracker):
+		return httpError(c, http.StatusNotFound, err)
+	case errors.Is(err, lib.ErrOutOfRange):
+		return httpError(c, http.StatusConflict, err)
+	}
+	return httpError(c, http.StatusBadRequest, err)
+}
+
+func registerTrackerRoutes(instances *echo.Group, db *JsonDB, hooks *WebhookDispatcher) {
+	instances.GET("/:id/trackers", func(c echo.Context) error {
+		instance, schema, err := getInstance(db, c.Param("user"), c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		states, err := schema.TrackerStates(instance, evalOptions(db, c.Param("user")))
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+		return c.JSON(http.StatusOK, states)
+	})
+
+	// resets every tracker that resets on the event
+	instances.POST("/:id/trackers/reset", func(c echo.Context) error {
+		user := c.Param("user")
+		_, schema, err := getInstance(db, user, c.Param("id"))
+		if err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		var req TrackerResetRequest
+		if err := c.Bind(&req); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+		if req.Event == "" {
+			return httpError(c, http.StatusBadRequest, fmt.Errorf("no event"))
+		}
+		if _, ok := schema.EventName(req.Event); !ok {
+			return httpError(c, http.StatusNotFound, fmt.Errorf("%w %q", lib.ErrUnknownEvent, req.Event))
+		}
+
+		states := []lib.TrackerState{}
+		_, err = updateInstance(db, hooks, user, schema, c.Param("id"), func(instance *lib.Instance, opts lib.EvalOptions) error {
+			for _, name := range schema.TrackersResetOn(req.Event) {
+				state, err := schema.ResetSlots(instance, name, opts)
+				if err != nil {
+					return err
+				}
+				states = append(states, state)
+			}
+			return nil
+		})
+		if err != nil {
+			return trackerError(c, err)
+		}
+		return c.JSON(http.StatusOK, states)
+	})
+
+	actions := map[string]func(*lib.Schema, *lib.Instance, string, float64, lib.EvalOptions) (lib.TrackerState, error){
+		"spend":   (*lib.Schema).SpendSlots,
+		"restore": (*lib.Schema).RestoreSlots,
+		"reset": func(schema *lib.Schema, instance *lib.Instance, name string, _ float64, opts lib.EvalOptions) (lib.TrackerState, error) {
+			return schema.ResetSlots(instance, name, opts)
+		},
+	}
+	for action, apply := range actions {
+		instances.POST("/:id/trackers/:name/"+action, func(c echo.Context) error {
+			user := c.Param("user")
+			name := c.Param("name")
+			_, schema, err := getInstance(db, user, c.Param("id"))
+			if err != nil {
+				return httpError(c, http.StatusNotFound, err)
+			}
+
+			req := TrackerRequest{Amount: 1}
+			if err := c.Bind(&req); err != nil {
+				return httpError(c, http.StatusBadRequest, err)
+			}
+
+			var state lib.TrackerState
+			_, err = updateInstance(db, hooks, user, schema, c.Param("id"), func(instance *lib.Instance, opts lib.EvalOptions) error {
+				state, err = apply(schema, instance, name, req.Amount, opts)
+				return err
+			})
+			if err != nil {
+				return trackerError(c, err)
+			}
+			return c.JSON(http.StatusOK, state)
+		})
+	}
+}
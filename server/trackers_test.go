@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestTrackerRoutes(t *testing.T) {
+	server := newTestServer(t)
+	db := server.db
+	hooks := NewWebhookDispatcher(db)
+	db.Set(CollectionSchemas, "gm", "dnd", lib.Schema{
+		ID:         "dnd",
+		Variables:  map[string]lib.Variable{"ki": {Type: lib.TypeNumber}},
+		Properties: map[string]lib.Property{"max_ki": {Formula: "20"}},
+		Trackers: map[string]lib.SlotTracker{
+			"ki": {Current: "ki", Max: "max_ki", ResetOn: []string{"short rest"}},
+		},
+	})
+	db.Set(CollectionInstances, "gm", "monk", lib.Instance{ID: "monk", SchemaID: "dnd"})
+
+	registerTrackerRoutes(server.router.Group("/:user/instances"), db, hooks)
+
+	// concurrent spends must not lose updates
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			if rec := server.post("/gm/instances/monk/trackers/ki/spend", ""); rec.Code != http.StatusOK {
+				t.Errorf("spend failed %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+	wg.Wait()
+
+	if rec := server.post("/gm/instances/monk/trackers/ki/spend", ""); rec.Code != http.StatusConflict {
+		t.Errorf("spending an empty tracker: got %d", rec.Code)
+	}
+	if rec := server.post("/gm/instances/monk/trackers/rage/spend", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown tracker: got %d", rec.Code)
+	}
+
+	rec := server.post("/gm/instances/monk/trackers/ki/restore", `{"amount":5}`)
+	var state lib.TrackerState
+	json.Unmarshal(rec.Body.Bytes(), &state)
+	if state.Current != 5 || state.Max != 20 {
+		t.Errorf("restore: %s", rec.Body.String())
+	}
+
+	rec = server.post("/gm/instances/monk/trackers/reset", `{"event":"Short Rest"}`)
+	var states []lib.TrackerState
+	json.Unmarshal(rec.Body.Bytes(), &states)
+	if len(states) != 1 || states[0].Current != 20 {
+		t.Errorf("event reset: %s", rec.Body.String())
+	}
+	if rec := server.post("/gm/instances/monk/trackers/reset", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("reset without an event: got %d", rec.Code)
+	}
+	if rec := server.post("/gm/instances/monk/trackers/reset", `{"event":"nap"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("reset on an unknown event: got %d", rec.Code)
+	}
+	var monk lib.Instance
+	db.Get(CollectionInstances, "gm", "monk", &monk)
+	if ki, _ := monk.GetNumber("ki"); ki != 20 {
+		t.Errorf("stored ki is %v", ki)
+	}
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/plexlad/gardi/server/lib"
+)
+
+// Outgoing webhooks
+//
+// Users register a URL against a schema, an instance or everything they
+// own. When something happens to it the server POSTs a JSON payload signed
+// with the webhook's secret (HMAC-SHA256 of the body, hex encoded in
+// SignatureHeader). Each webhook gets its payloads one at a time, in order.
+// Failed deliveries are retried with exponential backoff and every attempt is
+// kept in the delivery log. URLs must be public: the server won't connect to
+// loopback, private or link-local addresses.
+
+const (
+	SignatureHeader = "X-Gardi-Signature"
+	EventHeader     = "X-Gardi-Event"
+	DeliveryHeader  = "X-Gardi-Delivery"
+)
+
+type WebhookEvent string
+
+const (
+	EventSchemaCreated     WebhookEvent = "schema.created"
+	EventSchemaSaved       WebhookEvent = "schema.saved"
+	EventInstanceCreated   WebhookEvent = "instance.created"
+	EventInstanceSaved     WebhookEvent = "instance.saved"
+	EventInstanceThreshold WebhookEvent = "instance.threshold"
+)
+
+type ThresholdDirection string
+
+const (
+	CrossUp   ThresholdDirection = "up"
+	CrossDown ThresholdDirection = "down"
+	CrossAny  ThresholdDirection = "any"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// A registered webhook. At most one of SchemaID and InstanceID is set.
+// Schema webhooks also receive events for every instance of that schema;
+// webhooks with neither receive every event of the user, and are the only
+// ones that can hear of a schema being created.
+type Webhook struct {
+	ID         string         `json:"_id"`
+	URL        string         `json:"url"`
+	Secret     string         `json:"secret"`
+	SchemaID   string         `json:"schema_id,omitempty"`
+	InstanceID string         `json:"instance_id,omitempty"`
+	Events     []WebhookEvent `json:"events"`
+	Thresholds []Threshold    `json:"thresholds,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// Fires an instance.threshold event when a numeric variable moves across Value.
+// Going up means the old value was below Value and the new one is at or above.
+type Threshold struct {
+	Variable  string             `json:"variable"`
+	Value     float64            `json:"value"`
+	Direction ThresholdDirection `json:"direction,omitempty"` // defaults to any
+}
+
+// Body sent to the webhook URL.
+type WebhookPayload struct {
+	DeliveryID string             `json:"delivery_id"`
+	Event      WebhookEvent       `json:"event"`
+	User       string             `json:"user"`
+	SchemaID   string             `json:"schema_id,omitempty"`
+	InstanceID string             `json:"instance_id,omitempty"`
+	Threshold  *ThresholdCrossing `json:"threshold,omitempty"`
+	Data       any                `json:"data"`
+	Timestamp  time.Time          `json:"timestamp"`
+}
+
+type ThresholdCrossing struct {
+	Threshold
+	Crossed  ThresholdDirection `json:"crossed"`
+	OldValue float64            `json:"old_value"`
+	NewValue float64            `json:"new_value"`
+}
+
+// Delivery log entry, one per payload sent to one webhook.
+type WebhookDelivery struct {
+	ID        string            `json:"_id"`
+	WebhookID string            `json:"webhook_id"`
+	Event     WebhookEvent      `json:"event"`
+	URL       string            `json:"url"`
+	Status    DeliveryStatus    `json:"status"`
+	Payload   json.RawMessage   `json:"payload"`
+	Attempts  []DeliveryAttempt `json:"attempts"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type NewWebhookRequest struct {
+	URL        string         `json:"url"`
+	SchemaID   string         `json:"schema_id"`
+	InstanceID string         `json:"instance_id"`
+	Events     []WebhookEvent `json:"events"`
+	Thresholds []Threshold    `json:"thresholds"`
+}
+
+func (w *Webhook) Validate() error {
+	if w.URL == "" {
+		return fmt.Errorf("webhook url is required")
+	}
+	if err := checkWebhookURL(w.URL); err != nil {
+		return err
+	}
+	if w.SchemaID != "" && w.InstanceID != "" {
+		return fmt.Errorf("webhook takes at most one of schema_id or instance_id")
+	}
+	for _, event := range w.Events {
+		switch event {
+		case EventSchemaCreated:
+			if w.SchemaID != "" || w.InstanceID != "" {
+				return fmt.Errorf("%s needs a webhook without schema_id or instance_id", event)
+			}
+		case EventSchemaSaved:
+			if w.InstanceID != "" {
+				return fmt.Errorf("%s can't be sent to an instance webhook", event)
+			}
+		case EventInstanceCreated, EventInstanceSaved, EventInstanceThreshold:
+		default:
+			return fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+	if len(w.Thresholds) > 0 && !slices.Contains(w.Events, EventInstanceThreshold) {
+		return fmt.Errorf("thresholds need the %s event", EventInstanceThreshold)
+	}
+	for _, threshold := range w.Thresholds {
+		if threshold.Variable == "" {
+			return fmt.Errorf("threshold variable is required")
+		}
+		switch threshold.Direction {
+		case "", CrossUp, CrossDown, CrossAny:
+		default:
+			return fmt.Errorf("unknown threshold direction %q", threshold.Direction)
+		}
+	}
+	return nil
+}
+
+// Only http and https, and no host that is obviously internal. Names are
+// checked again once resolved, when connecting (see publicDialer).
+func checkWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("webhook url must be http or https")
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "" {
+		return fmt.Errorf("webhook url has no host")
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("webhook url can't point at this server")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && isInternalAddr(addr) {
+		return fmt.Errorf("webhook url can't point at internal address %s", addr)
+	}
+	return nil
+}
+
+func isInternalAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast()
+}
+
+// Refuses to connect to internal addresses, whatever name led there, so
+// neither DNS nor redirects can point a webhook at the server's network.
+func publicDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if isInternalAddr(addr) {
+				return fmt.Errorf("refusing to connect to internal address %s", addr)
+			}
+			return nil
+		},
+	}
+}
+
+// Whether the webhook wants this event for the given schema/instance.
+func (w *Webhook) Matches(event WebhookEvent, schemaID, instanceID string) bool {
+	if !slices.Contains(w.Events, event) {
+		return false
+	}
+	switch {
+	case w.InstanceID != "":
+		return instanceID != "" && w.InstanceID == instanceID
+	case w.SchemaID != "":
+		return w.SchemaID == schemaID
+	}
+	return true
+}
+
+// Hex encoded HMAC-SHA256 of body, prefixed the same way GitHub does it.
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks a signature header against the body. For receivers written in Go.
+func VerifySignature(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignPayload(secret, body)), []byte(signature))
+}
+
+// Compares two versions of an instance against the thresholds.
+// Missing or non numeric values never cross.
+func CrossedThresholds(thresholds []Threshold, before, after *lib.Instance) []ThresholdCrossing {
+	var crossings []ThresholdCrossing
+	for _, threshold := range thresholds {
+		oldValue, ok := before.GetNumber(threshold.Variable)
+		if !ok {
+			continue
+		}
+		newValue, ok := after.GetNumber(threshold.Variable)
+		if !ok {
+			continue
+		}
+
+		var crossed ThresholdDirection
+		switch {
+		case oldValue < threshold.Value && newValue >= threshold.Value:
+			crossed = CrossUp
+		case oldValue >= threshold.Value && newValue < threshold.Value:
+			crossed = CrossDown
+		default:
+			continue
+		}
+
+		if threshold.Direction != "" && threshold.Direction != CrossAny && threshold.Direction != crossed {
+			continue
+		}
+
+		crossings = append(crossings, ThresholdCrossing{
+			Threshold: threshold,
+			Crossed:   crossed,
+			OldValue:  oldValue,
+			NewValue:  newValue,
+		})
+	}
+	return crossings
+}
+
+func newWebhookSecret() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Dispatcher //
+
+// Sends webhook payloads in the background, through one queue per webhook.
+type WebhookDispatcher struct {
+	db          *JsonDB
+	client      *http.Client
+	maxAttempts int
+	baseDelay   time.Duration
+	wg          sync.WaitGroup
+
+	mu     sync.Mutex
+	queues map[string][]queuedDelivery // user/webhook -> deliveries waiting their turn
+}
+
+type queuedDelivery struct {
+	user     string
+	webhook  Webhook
+	delivery *WebhookDelivery
+}
+
+func NewWebhookDispatcher(db *JsonDB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db: db,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{DialContext: publicDialer().DialContext},
+		},
+		maxAttempts: 5,
+		baseDelay:   2 * time.Second,
+		queues:      make(map[string][]queuedDelivery),
+	}
+}
+
+// Blocks until every queued delivery has finished (succeeded or given up).
+func (d *WebhookDispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Queues the event for every matching webhook of the user.
+func (d *WebhookDispatcher) Dispatch(user string, event WebhookEvent, schemaID, instanceID string, data any) {
+	webhooks, err := d.webhooks(user)
+	if err != nil {
+		return
+	}
+	for _, webhook := range webhooks {
+		if webhook.Matches(event, schemaID, instanceID) {
+			d.send(user, webhook, event, schemaID, instanceID, nil, data)
+		}
+	}
+}
+
+// Sends created/saved events for an instance, plus threshold events when
+// before is set and a registered threshold was crossed.
+func (d *WebhookDispatcher) DispatchInstance(user string, event WebhookEvent, before, after *lib.Instance) {
+	webhooks, err := d.webhooks(user)
+	if err != nil {
+		return
+	}
+	for _, webhook := range webhooks {
+		if webhook.Matches(event, after.SchemaID, after.ID) {
+			d.send(user, webhook, event, after.SchemaID, after.ID, nil, after)
+		}
+		if before == nil || !webhook.Matches(EventInstanceThreshold, after.SchemaID, after.ID) {
+			continue
+		}
+		for _, crossing := range CrossedThresholds(webhook.Thresholds, before, after) {
+			d.send(user, webhook, EventInstanceThreshold, after.SchemaID, after.ID, &crossing, after)
+		}
+	}
+}
+
+// Oldest first, so deliveries go out in the order the webhooks were added.
+func (d *WebhookDispatcher) webhooks(user string) ([]Webhook, error) {
+	all, err := GetAll[Webhook](d.db, CollectionWebhooks, user)
+	if err != nil {
+		return nil, err
+	}
+	webhooks := slices.SortedFunc(maps.Values(all), func(a, b Webhook) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return webhooks, nil
+}
+
+// Delivery log writes happen in the background, away from any request, so
+// failures can only be logged.
+func (d *WebhookDispatcher) saveDelivery(user string, delivery *WebhookDelivery) {
+	if err := d.db.Set(CollectionDeliveries, user, delivery.ID, delivery); err != nil {
+		log.Printf("webhook %s: failed to log delivery %s: %v", delivery.WebhookID, delivery.ID, err)
+	}
+}
+
+func (d *WebhookDispatcher) send(user string, webhook Webhook, event WebhookEvent, schemaID, instanceID string, crossing *ThresholdCrossing, data any) {
+	now := time.Now()
+	payload := WebhookPayload{
+		DeliveryID: uuid.New().String(),
+		Event:      event,
+		User:       user,
+		SchemaID:   schemaID,
+		InstanceID: instanceID,
+		Threshold:  crossing,
+		Data:       data,
+		Timestamp:  now,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("webhook %s: failed to encode %s payload: %v", webhook.ID, event, err)
+		return
+	}
+
+	delivery := WebhookDelivery{
+		ID:        payload.DeliveryID,
+		WebhookID: webhook.ID,
+		Event:     event,
+		URL:       webhook.URL,
+		Status:    DeliveryPending,
+		Payload:   body,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	d.saveDelivery(user, &delivery)
+	d.enqueue(queuedDelivery{user: user, webhook: webhook, delivery: &delivery})
+}
+
+// Adds the delivery to its webhook's queue, starting a worker for the queue
+// unless one is running.
+func (d *WebhookDispatcher) enqueue(queued queuedDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := queued.user + "/" + queued.webhook.ID
+	waiting, running := d.queues[key]
+	d.queues[key] = append(waiting, queued)
+	if running {
+		return
+	}
+
+	d.wg.Add(1)
+	go d.drain(key)
+}
+
+// Delivers the queue one payload at a time, retries included, so the
+// receiver sees events in the order they happened. Stops once it's empty.
+func (d *WebhookDispatcher) drain(key string) {
+	defer d.wg.Done()
+
+	for {
+		d.mu.Lock()
+		waiting := d.queues[key]
+		if len(waiting) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		next := waiting[0]
+		d.queues[key] = waiting[1:]
+		d.mu.Unlock()
+
+		d.deliver(next.user, next.webhook, next.delivery)
+	}
+}
+
+// Tries until a 2xx response or maxAttempts, doubling the wait each time.
+func (d *WebhookDispatcher) deliver(user string, webhook Webhook, delivery *WebhookDelivery) {
+	delay := d.baseDelay
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		result := d.attempt(webhook, delivery)
+		delivery.Attempts = append(delivery.Attempts, result)
+		delivery.UpdatedAt = result.At
+
+		if result.Error == "" {
+			delivery.Status = DeliverySucceeded
+			d.saveDelivery(user, delivery)
+			return
+		}
+
+		if attempt == d.maxAttempts {
+			break
+		}
+		d.saveDelivery(user, delivery)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	delivery.Status = DeliveryFailed
+	d.saveDelivery(user, delivery)
+}
+
+func (d *WebhookDispatcher) attempt(webhook Webhook, delivery *WebhookDelivery) DeliveryAttempt {
+	result := DeliveryAttempt{At: time.Now()}
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(SignatureHeader, SignPayload(webhook.Secret, delivery.Payload))
+	req.Header.Set(EventHeader, string(delivery.Event))
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return result
+}
+
+// Routes //
+
+func registerWebhookRoutes(u *echo.Group, db *JsonDB) {
+	webhooks := u.Group("/webhooks")
+
+	webhooks.GET("", func(c echo.Context) error {
+		return listSummaries(c, db, CollectionWebhooks)
+	})
+
+	webhooks.GET("/:id", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		var webhook Webhook
+		if err := db.Get(CollectionWebhooks, user, id, &webhook); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		return c.JSON(http.StatusOK, webhook)
+	})
+
+	webhooks.POST("/new", func(c echo.Context) error {
+		user := c.Param("user")
+
+		var req NewWebhookRequest
+		if err := c.Bind(&req); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		webhook := Webhook{
+			ID:         uuid.New().String(),
+			URL:        req.URL,
+			Secret:     newWebhookSecret(),
+			SchemaID:   req.SchemaID,
+			InstanceID: req.InstanceID,
+			Events:     req.Events,
+			Thresholds: req.Thresholds,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		if err := webhook.Validate(); err != nil {
+			return httpError(c, http.StatusBadRequest, err)
+		}
+
+		if err := db.Set(CollectionWebhooks, user, webhook.ID, webhook); err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, webhook)
+	})
+
+	webhooks.POST("/:id/delete", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		if err := db.Delete(CollectionWebhooks, user, id); err != nil {
+			return httpError(c, http.StatusNotFound, err)
+		}
+
+		return c.String(http.StatusOK, "webhook deleted")
+	})
+
+	webhooks.GET("/:id/deliveries", func(c echo.Context) error {
+		user := c.Param("user")
+		id := c.Param("id")
+
+		all, err := GetAll[WebhookDelivery](db, CollectionDeliveries, user)
+		if err != nil {
+			return httpError(c, http.StatusInternalServerError, err)
+		}
+
+		deliveries := []WebhookDelivery{}
+		for _, delivery := range all {
+			if delivery.WebhookID == id {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		slices.SortFunc(deliveries, func(a, b WebhookDelivery) int {
+			return b.CreatedAt.Compare(a.CreatedAt)
+		})
+
+		return c.JSON(http.StatusOK, deliveries)
+	})
+}
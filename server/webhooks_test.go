@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/plexlad/gardi/server/lib"
+)
+
+func TestSignPayload(t *testing.T) {
+	body := []byte(`{"event":"instance.saved"}`)
+
+	signature := SignPayload("secret", body)
+	if !VerifySignature("secret", body, signature) {
+		t.Errorf("signature %q did not verify", signature)
+	}
+	if VerifySignature("other", body, signature) {
+		t.Error("signature verified with the wrong secret")
+	}
+	if VerifySignature("secret", []byte(`{}`), signature) {
+		t.Error("signature verified with a different body")
+	}
+}
+
+func TestCrossedThresholds(t *testing.T) {
+	instance := func(hp any) *lib.Instance {
+		return &lib.Instance{VariableValues: map[string]any{"hp": hp}}
+	}
+
+	tests := []struct {
+		name      string
+		threshold Threshold
+		before    any
+		after     any
+		want      ThresholdDirection
+	}{
+		{"drops below", Threshold{Variable: "hp", Value: 10}, 12.0, 4.0, CrossDown},
+		{"rises to", Threshold{Variable: "hp", Value: 10}, 4.0, 10.0, CrossUp},
+		{"stays above", Threshold{Variable: "hp", Value: 10}, 12.0, 11.0, ""},
+		{"wrong direction", Threshold{Variable: "hp", Value: 10, Direction: CrossUp}, 12.0, 4.0, ""},
+		{"not a number", Threshold{Variable: "hp", Value: 10}, "12", 4.0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crossings := CrossedThresholds([]Threshold{tt.threshold}, instance(tt.before), instance(tt.after))
+			if tt.want == "" {
+				if len(crossings) != 0 {
+					t.Errorf("expected no crossing, got %+v", crossings)
+				}
+				return
+			}
+			if len(crossings) != 1 || crossings[0].Crossed != tt.want {
+				t.Errorf("expected crossing %q, got %+v", tt.want, crossings)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	var calls atomic.Int32
+	var gotSignature, gotEvent string
+	var gotBody []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// first attempt fails so the retry path runs
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gotSignature = r.Header.Get(SignatureHeader)
+		gotEvent = r.Header.Get(EventHeader)
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	db := NewJsonDB(t.TempDir())
+	webhook := Webhook{
+		ID:       "hook-1",
+		URL:      server.URL,
+		Secret:   "s3cret",
+		SchemaID: "schema-1",
+		Events:   []WebhookEvent{EventInstanceThreshold},
+		Thresholds: []Threshold{
+			{Variable: "hp", Value: 1, Direction: CrossDown},
+		},
+	}
+	if err := db.Set(CollectionWebhooks, "alice", webhook.ID, webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := NewWebhookDispatcher(db)
+	dispatcher.client = server.Client() // the test server is on loopback
+	dispatcher.baseDelay = time.Millisecond
+
+	before := &lib.Instance{ID: "inst-1", SchemaID: "schema-1", VariableValues: map[string]any{"hp": 3.0}}
+	after := &lib.Instance{ID: "inst-1", SchemaID: "schema-1", VariableValues: map[string]any{"hp": 0.0}}
+
+	// unrelated schema must not be delivered
+	dispatcher.DispatchInstance("alice", EventInstanceSaved, before, &lib.Instance{ID: "x", SchemaID: "schema-2"})
+	dispatcher.Wait()
+	if calls.Load() != 0 {
+		t.Fatalf("expected no calls for another schema, got %d", calls.Load())
+	}
+
+	dispatcher.DispatchInstance("alice", EventInstanceSaved, before, after)
+	dispatcher.Wait()
+
+	if calls.Load() != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls.Load())
+	}
+	if gotEvent != string(EventInstanceThreshold) {
+		t.Errorf("expected event header %q, got %q", EventInstanceThreshold, gotEvent)
+	}
+	if !VerifySignature(webhook.Secret, gotBody, gotSignature) {
+		t.Error("delivered payload signature did not verify")
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(gotBody, &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if payload.Threshold == nil || payload.Threshold.Crossed != CrossDown {
+		t.Errorf("expected a downward crossing in payload, got %+v", payload.Threshold)
+	}
+
+	deliveryIDs, err := db.List(CollectionDeliveries, "alice")
+	if err != nil || len(deliveryIDs) != 1 {
+		t.Fatalf("expected one delivery log entry, got %v (%v)", deliveryIDs, err)
+	}
+	var delivery WebhookDelivery
+	if err := db.Get(CollectionDeliveries, "alice", deliveryIDs[0], &delivery); err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != DeliverySucceeded || len(delivery.Attempts) != 2 {
+		t.Errorf("expected succeeded delivery with 2 attempts, got %s with %d", delivery.Status, len(delivery.Attempts))
+	}
+}
+
+func TestWebhookTargets(t *testing.T) {
+	everything := Webhook{URL: "http://example.test", Events: []WebhookEvent{EventSchemaCreated, EventInstanceSaved}}
+	if err := everything.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !everything.Matches(EventSchemaCreated, "schema-1", "") || !everything.Matches(EventInstanceSaved, "schema-2", "inst-1") {
+		t.Error("a webhook without a target should match everything")
+	}
+
+	invalid := map[string]Webhook{
+		"both targets":             {URL: "http://example.test", SchemaID: "s", InstanceID: "i"},
+		"schema.created on schema": {URL: "http://example.test", SchemaID: "s", Events: []WebhookEvent{EventSchemaCreated}},
+		"schema.saved on instance": {URL: "http://example.test", InstanceID: "i", Events: []WebhookEvent{EventSchemaSaved}},
+		"thresholds without event": {URL: "http://example.test", Thresholds: []Threshold{{Variable: "hp", Value: 1}}},
+		"not http":                 {URL: "ftp://example.test"},
+		"localhost":                {URL: "http://localhost:5499/hook"},
+		"loopback":                 {URL: "http://127.0.0.1:8080"},
+		"private":                  {URL: "https://10.0.0.5/hook"},
+		"link-local":               {URL: "http://169.254.169.254/latest/meta-data"},
+		"ipv6 loopback":            {URL: "http://[::1]/"},
+		"mapped loopback":          {URL: "http://[::ffff:127.0.0.1]/"},
+	}
+	for name, webhook := range invalid {
+		if err := webhook.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWebhookRefusesInternalAddresses(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	// stored directly, as if saved before URLs were checked
+	db := NewJsonDB(t.TempDir())
+	db.Set(CollectionWebhooks, "alice", "hook-1", Webhook{ID: "hook-1", URL: server.URL, Events: []WebhookEvent{EventSchemaCreated}})
+
+	dispatcher := NewWebhookDispatcher(db)
+	dispatcher.maxAttempts = 1
+	dispatcher.Dispatch("alice", EventSchemaCreated, "schema-1", "", nil)
+	dispatcher.Wait()
+
+	if calls.Load() != 0 {
+		t.Errorf("the dispatcher connected to %s", server.URL)
+	}
+}
+
+func TestWebhookDeliveryOrder(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	var failed atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload WebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		// the first payload fails once, so a later one could overtake its retry
+		if payload.SchemaID == "first" && !failed.Swap(true) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mu.Lock()
+		received = append(received, payload.SchemaID)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	db := NewJsonDB(t.TempDir())
+	db.Set(CollectionWebhooks, "alice", "hook-1", Webhook{ID: "hook-1", URL: server.URL, Events: []WebhookEvent{EventSchemaCreated}})
+
+	dispatcher := NewWebhookDispatcher(db)
+	dispatcher.client = server.Client()
+	dispatcher.baseDelay = 20 * time.Millisecond
+	for _, id := range []string{"first", "second", "third"} {
+		dispatcher.Dispatch("alice", EventSchemaCreated, id, "", nil)
+	}
+	dispatcher.Wait()
+
+	if !slices.Equal(received, []string{"first", "second", "third"}) {
+		t.Errorf("payloads arrived out of order: %v", received)
+	}
+}
+
+func TestWebhookOrder(t *testing.T) {
+	db := NewJsonDB(t.TempDir())
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"c", "a", "b"} {
+		db.Set(CollectionWebhooks, "alice", id, Webhook{ID: id, CreatedAt: start.Add(time.Duration(i) * time.Hour)})
+	}
+
+	webhooks, err := NewWebhookDispatcher(db).webhooks("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, webhook := range webhooks {
+		ids = append(ids, webhook.ID)
+	}
+	if !slices.Equal(ids, []string{"c", "a", "b"}) {
+		t.Errorf("webhooks should be in creation order: %v", ids)
+	}
+}